@@ -0,0 +1,340 @@
+package pockettts
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// CircuitState is the state of a CircuitBreaker.
+type CircuitState string
+
+const (
+	// CircuitClosed: requests pass through and their outcomes are counted.
+	CircuitClosed CircuitState = "closed"
+
+	// CircuitOpen: requests fail fast with ErrCircuitOpen until
+	// CircuitBreakerOptions.OpenTimeout has passed.
+	CircuitOpen CircuitState = "open"
+
+	// CircuitHalfOpen: the breaker probes the backend before letting a
+	// request through; one probe runs at a time.
+	CircuitHalfOpen CircuitState = "half-open"
+)
+
+// CircuitBreakerOptions configures a CircuitBreaker.
+type CircuitBreakerOptions struct {
+	// FailureRate is the fraction of failed requests within Window that
+	// opens the circuit. Defaults to 0.5.
+	FailureRate float64
+
+	// MinRequests is the number of requests within Window below which the
+	// failure rate is not evaluated. Defaults to 10.
+	MinRequests int
+
+	// Window is the sliding period over which outcomes are counted.
+	// Defaults to 1 minute.
+	Window time.Duration
+
+	// OpenTimeout is how long the circuit stays open before a probe is let
+	// through. Defaults to 30 seconds.
+	OpenTimeout time.Duration
+
+	// HalfOpenProbes is the number of consecutive successful probes that
+	// close the circuit again. Defaults to 1.
+	HalfOpenProbes int
+
+	// ProbeText, if set, makes probes synthesize this text (keep it short)
+	// instead of calling the backend's Health. A synthesis also catches
+	// failures that a health check cannot see, such as a corrupted model.
+	ProbeText string
+
+	// IsFailure decides whether a request error counts against the backend.
+	// Nil counts every error except caller mistakes: ErrEmptyText,
+	// ErrInvalidVoice and HTTP 4xx responses other than 408 and 429.
+	// Requests whose context ended are never counted.
+	IsFailure func(error) bool
+
+	// OnStateChange, if set, is called after each state change. It is
+	// called synchronously and must not block.
+	OnStateChange func(from, to CircuitState)
+}
+
+func (o *CircuitBreakerOptions) failureRate() float64 {
+	if o.FailureRate > 0 {
+		return o.FailureRate
+	}
+	return 0.5
+}
+
+func (o *CircuitBreakerOptions) minRequests() int {
+	if o.MinRequests > 0 {
+		return o.MinRequests
+	}
+	return 10
+}
+
+func (o *CircuitBreakerOptions) window() time.Duration {
+	if o.Window > 0 {
+		return o.Window
+	}
+	return time.Minute
+}
+
+func (o *CircuitBreakerOptions) openTimeout() time.Duration {
+	if o.OpenTimeout > 0 {
+		return o.OpenTimeout
+	}
+	return 30 * time.Second
+}
+
+func (o *CircuitBreakerOptions) halfOpenProbes() int {
+	if o.HalfOpenProbes > 0 {
+		return o.HalfOpenProbes
+	}
+	return 1
+}
+
+func (o *CircuitBreakerOptions) isFailure(err error) bool {
+	if o.IsFailure != nil {
+		return o.IsFailure(err)
+	}
+	return isBackendFailure(err)
+}
+
+// isBackendFailure reports whether err points at the backend rather than at
+// the request.
+func isBackendFailure(err error) bool {
+	var (
+		voice *ErrInvalidVoice
+		exit  *ErrNonZeroExit
+	)
+	switch {
+	case errors.Is(err, ErrEmptyText), errors.As(err, &voice):
+		return false
+	case errors.As(err, &exit) && exit.HTTP:
+		return exit.ExitCode < 400 || exit.ExitCode >= 500 ||
+			exit.ExitCode == http.StatusRequestTimeout || exit.ExitCode == http.StatusTooManyRequests
+	default:
+		return true
+	}
+}
+
+// breakerBuckets is the number of buckets Window is divided into.
+const breakerBuckets = 10
+
+// breakerBucket counts the outcomes of one slice of the window.
+type breakerBucket struct {
+	start    time.Time
+	requests int
+	failures int
+}
+
+// CircuitBreaker wraps a Synthesizer (typically a Client or ServerClient) and
+// stops sending it requests while it is failing.
+//
+// While closed, the breaker counts outcomes over a sliding Window. Once at
+// least MinRequests requests have been made and FailureRate of them failed,
+// it opens: requests fail with *ErrCircuitOpen, without reaching the backend,
+// for OpenTimeout. The next request after that moves the breaker to
+// half-open and first probes the backend with Health (or ProbeText); if the
+// probe succeeds the request goes through, and after HalfOpenProbes
+// successful probes the circuit closes. A failed probe or request reopens it.
+//
+// Create with NewCircuitBreaker.
+type CircuitBreaker struct {
+	opts CircuitBreakerOptions
+	s    Synthesizer
+
+	mu        sync.Mutex
+	state     CircuitState
+	buckets   [breakerBuckets]breakerBucket
+	openUntil time.Time
+	lastErr   error
+	probing   bool
+	probesOK  int
+}
+
+// NewCircuitBreaker returns s wrapped in a closed circuit breaker.
+func NewCircuitBreaker(s Synthesizer, opts CircuitBreakerOptions) *CircuitBreaker {
+	return &CircuitBreaker{opts: opts, s: s, state: CircuitClosed}
+}
+
+// State returns the breaker's current state.
+func (b *CircuitBreaker) State() CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Synthesize implements Synthesizer. It fails with *ErrCircuitOpen while the
+// circuit is open.
+func (b *CircuitBreaker) Synthesize(ctx context.Context, text string, opts *GenerateOptions) (*WAVResult, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err // the backend would reject it too; not its failure
+	}
+	if err := b.admit(ctx); err != nil {
+		return nil, err
+	}
+	res, err := b.s.Synthesize(ctx, text, opts)
+	b.record(ctx, err)
+	return res, err
+}
+
+// Health implements Synthesizer by checking the wrapped backend, whatever
+// the state of the circuit.
+func (b *CircuitBreaker) Health(ctx context.Context) error {
+	return b.s.Health(ctx)
+}
+
+// Close implements Synthesizer by closing the wrapped backend.
+func (b *CircuitBreaker) Close() error {
+	return b.s.Close()
+}
+
+// synthesisParams lets a CachedSynthesizer around the breaker key requests
+// like the wrapped backend.
+func (b *CircuitBreaker) synthesisParams(opts *GenerateOptions) synthesisParams {
+	if r, ok := b.s.(synthesisParamsResolver); ok {
+		return r.synthesisParams(opts)
+	}
+	return synthesisParams{Voice: opts.voice()}
+}
+
+// admit decides whether a request may reach the backend, probing it first
+// when the circuit is half-open.
+func (b *CircuitBreaker) admit(ctx context.Context) error {
+	b.mu.Lock()
+	if b.state == CircuitClosed {
+		b.mu.Unlock()
+		return nil
+	}
+	now := time.Now()
+	if b.probing || now.Before(b.openUntil) {
+		err := &ErrCircuitOpen{RetryAfter: max(b.openUntil.Sub(now), 0), LastErr: b.lastErr}
+		b.mu.Unlock()
+		return err
+	}
+	b.probing = true
+	notify := b.setState(CircuitHalfOpen)
+	b.mu.Unlock()
+	notify()
+
+	err := b.probe(ctx)
+
+	b.mu.Lock()
+	b.probing = false
+	switch {
+	case err != nil && ctx.Err() != nil:
+		// The caller gave up; the probe says nothing about the backend.
+		b.mu.Unlock()
+		return err
+	case err != nil && b.opts.isFailure(err):
+		notify = b.trip(err)
+		open := &ErrCircuitOpen{RetryAfter: b.opts.openTimeout(), LastErr: err}
+		b.mu.Unlock()
+		notify()
+		return open
+	}
+	b.probesOK++
+	notify = func() {}
+	if b.probesOK >= b.opts.halfOpenProbes() {
+		notify = b.reset()
+	}
+	b.mu.Unlock()
+	notify()
+	return nil
+}
+
+// probe checks the backend with ProbeText or Health.
+func (b *CircuitBreaker) probe(ctx context.Context) error {
+	if b.opts.ProbeText != "" {
+		_, err := b.s.Synthesize(ctx, b.opts.ProbeText, nil)
+		return err
+	}
+	return b.s.Health(ctx)
+}
+
+// record counts the outcome of a request that reached the backend.
+func (b *CircuitBreaker) record(ctx context.Context, err error) {
+	if err != nil && ctx.Err() != nil {
+		return // cancelled or timed out by the caller
+	}
+	failed := err != nil && b.opts.isFailure(err)
+
+	b.mu.Lock()
+	notify := func() {}
+	switch b.state {
+	case CircuitClosed:
+		bucket := b.bucket(time.Now())
+		bucket.requests++
+		if failed {
+			bucket.failures++
+			notify = b.checkRate(err)
+		}
+	case CircuitHalfOpen:
+		if failed {
+			notify = b.trip(err)
+		}
+	}
+	b.mu.Unlock()
+	notify()
+}
+
+// bucket returns the bucket for now, recycling it if it has aged out.
+// b.mu must be held.
+func (b *CircuitBreaker) bucket(now time.Time) *breakerBucket {
+	width := max(b.opts.window()/breakerBuckets, 1)
+	start := now.Truncate(width)
+	bucket := &b.buckets[int(start.UnixNano()/int64(width))%breakerBuckets]
+	if !bucket.start.Equal(start) {
+		*bucket = breakerBucket{start: start}
+	}
+	return bucket
+}
+
+// checkRate opens the circuit if the failure rate within the window has
+// reached the threshold. b.mu must be held.
+func (b *CircuitBreaker) checkRate(err error) (notify func()) {
+	cutoff := time.Now().Add(-b.opts.window())
+	var requests, failures int
+	for _, bucket := range b.buckets {
+		if bucket.start.After(cutoff) {
+			requests += bucket.requests
+			failures += bucket.failures
+		}
+	}
+	if requests < b.opts.minRequests() || float64(failures) < b.opts.failureRate()*float64(requests) {
+		return func() {}
+	}
+	return b.trip(err)
+}
+
+// trip opens the circuit. b.mu must be held.
+func (b *CircuitBreaker) trip(err error) (notify func()) {
+	b.openUntil = time.Now().Add(b.opts.openTimeout())
+	b.lastErr = err
+	b.probesOK = 0
+	return b.setState(CircuitOpen)
+}
+
+// reset closes the circuit and forgets past outcomes. b.mu must be held.
+func (b *CircuitBreaker) reset() (notify func()) {
+	b.buckets = [breakerBuckets]breakerBucket{}
+	b.lastErr = nil
+	b.probesOK = 0
+	return b.setState(CircuitClosed)
+}
+
+// setState changes the state and returns a func that reports the change to
+// OnStateChange; call it after releasing b.mu. b.mu must be held.
+func (b *CircuitBreaker) setState(to CircuitState) (notify func()) {
+	from := b.state
+	b.state = to
+	if from == to || b.opts.OnStateChange == nil {
+		return func() {}
+	}
+	return func() { b.opts.OnStateChange(from, to) }
+}
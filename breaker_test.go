@@ -0,0 +1,229 @@
+package pockettts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakySynth fails Synthesize with err and Health with healthErr, both of
+// which can be changed while it is in use.
+type flakySynth struct {
+	mu        sync.Mutex
+	err       error
+	healthErr error
+	calls     int
+	texts     []string
+}
+
+func (f *flakySynth) set(err, healthErr error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err, f.healthErr = err, healthErr
+}
+
+func (f *flakySynth) Synthesize(ctx context.Context, text string, _ *GenerateOptions) (*WAVResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	f.texts = append(f.texts, text)
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &WAVResult{Data: encodeWAV(24000, 1, 16, nil), SampleRate: 24000, Channels: 1, BitsPerSample: 16}, nil
+}
+
+func (f *flakySynth) Health(context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.healthErr
+}
+
+func (f *flakySynth) Close() error { return nil }
+
+func (f *flakySynth) callCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+// transitionLog records OnStateChange calls.
+type transitionLog struct {
+	mu  sync.Mutex
+	got []string
+}
+
+func (l *transitionLog) record(from, to CircuitState) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.got = append(l.got, fmt.Sprintf("%s->%s", from, to))
+}
+
+func (l *transitionLog) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return fmt.Sprint(l.got)
+}
+
+var errBackendDown = &ErrNonZeroExit{ExitCode: 1, Stderr: "model cache corrupted"}
+
+func TestCircuitBreaker_OpensAtFailureRate(t *testing.T) {
+	f := &flakySynth{}
+	var log transitionLog
+	b := NewCircuitBreaker(f, CircuitBreakerOptions{MinRequests: 4, FailureRate: 0.5, OnStateChange: log.record})
+	ctx := context.Background()
+
+	// 1 failure out of 4 stays closed.
+	for i := range 4 {
+		if i == 0 {
+			f.set(errBackendDown, nil)
+		} else {
+			f.set(nil, nil)
+		}
+		_, _ = b.Synthesize(ctx, "hi", nil)
+	}
+	if s := b.State(); s != CircuitClosed {
+		t.Fatalf("state = %s after 1/4 failures, want closed", s)
+	}
+
+	// Two more failures make 3/6.
+	f.set(errBackendDown, nil)
+	_, _ = b.Synthesize(ctx, "hi", nil)
+	_, _ = b.Synthesize(ctx, "hi", nil)
+	if s := b.State(); s != CircuitOpen {
+		t.Fatalf("state = %s after 3/6 failures, want open", s)
+	}
+
+	calls := f.callCount()
+	_, err := b.Synthesize(ctx, "hi", nil)
+	var open *ErrCircuitOpen
+	if !errors.As(err, &open) {
+		t.Fatalf("err = %v, want *ErrCircuitOpen", err)
+	}
+	if open.LastErr != errBackendDown || open.RetryAfter <= 0 {
+		t.Errorf("ErrCircuitOpen = %+v, want LastErr and a positive RetryAfter", open)
+	}
+	if f.callCount() != calls {
+		t.Error("open circuit let a request through")
+	}
+	if got := log.String(); got != "[closed->open]" {
+		t.Errorf("transitions = %s", got)
+	}
+}
+
+func TestCircuitBreaker_CallerErrorsDoNotCount(t *testing.T) {
+	f := &flakySynth{}
+	b := NewCircuitBreaker(f, CircuitBreakerOptions{MinRequests: 2})
+	for _, err := range []error{
+		&ErrInvalidVoice{Voice: "bob"},
+		&ErrNonZeroExit{ExitCode: 400, HTTP: true},
+		ErrEmptyText,
+	} {
+		f.set(err, nil)
+		for range 3 {
+			_, _ = b.Synthesize(context.Background(), "hi", nil)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	f.set(&ErrProcessTimeout{}, nil)
+	for range 3 {
+		_, _ = b.Synthesize(ctx, "hi", nil)
+	}
+	if s := b.State(); s != CircuitClosed {
+		t.Errorf("state = %s, want closed", s)
+	}
+}
+
+func TestCircuitBreaker_HalfOpenProbe(t *testing.T) {
+	f := &flakySynth{}
+	var log transitionLog
+	b := NewCircuitBreaker(f, CircuitBreakerOptions{MinRequests: 1, OpenTimeout: 50 * time.Millisecond, OnStateChange: log.record})
+	ctx := context.Background()
+
+	f.set(errBackendDown, errBackendDown)
+	_, _ = b.Synthesize(ctx, "hi", nil)
+	if s := b.State(); s != CircuitOpen {
+		t.Fatalf("state = %s, want open", s)
+	}
+
+	// A failed health probe reopens the circuit without a synthesis.
+	time.Sleep(60 * time.Millisecond)
+	calls := f.callCount()
+	_, err := b.Synthesize(ctx, "hi", nil)
+	var open *ErrCircuitOpen
+	if !errors.As(err, &open) || f.callCount() != calls {
+		t.Fatalf("err = %v, calls %d -> %d; want *ErrCircuitOpen without a synthesis", err, calls, f.callCount())
+	}
+
+	// A passing probe lets the request through and closes the circuit.
+	f.set(nil, nil)
+	time.Sleep(60 * time.Millisecond)
+	if _, err := b.Synthesize(ctx, "hi", nil); err != nil {
+		t.Fatal(err)
+	}
+	if s := b.State(); s != CircuitClosed {
+		t.Errorf("state = %s, want closed", s)
+	}
+	want := "[closed->open open->half-open half-open->open open->half-open half-open->closed]"
+	if got := log.String(); got != want {
+		t.Errorf("transitions = %s, want %s", got, want)
+	}
+}
+
+func TestCircuitBreaker_ProbeText(t *testing.T) {
+	f := &flakySynth{}
+	b := NewCircuitBreaker(f, CircuitBreakerOptions{MinRequests: 1, OpenTimeout: time.Millisecond, ProbeText: "ping", HalfOpenProbes: 2})
+	ctx := context.Background()
+
+	f.set(errBackendDown, nil) // healthy, but synthesis fails
+	_, _ = b.Synthesize(ctx, "hi", nil)
+	time.Sleep(5 * time.Millisecond)
+	if _, err := b.Synthesize(ctx, "hi", nil); err == nil {
+		t.Fatal("expected the synthesis probe to fail")
+	}
+	if s := b.State(); s != CircuitOpen {
+		t.Fatalf("state = %s, want open after failed probe", s)
+	}
+
+	f.set(nil, nil)
+	for i := range 2 {
+		time.Sleep(5 * time.Millisecond)
+		if _, err := b.Synthesize(ctx, "hi", nil); err != nil {
+			t.Fatal(err)
+		}
+		want := CircuitHalfOpen
+		if i == 1 {
+			want = CircuitClosed
+		}
+		if s := b.State(); s != want {
+			t.Errorf("after %d good probes: state = %s, want %s", i+1, s, want)
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	probes := 0
+	for _, text := range f.texts {
+		if text == "ping" {
+			probes++
+		}
+	}
+	if probes != 3 {
+		t.Errorf("sent %d probe syntheses, want 3", probes)
+	}
+}
+
+func TestCircuitBreaker_WindowExpires(t *testing.T) {
+	f := &flakySynth{}
+	b := NewCircuitBreaker(f, CircuitBreakerOptions{MinRequests: 2, Window: 50 * time.Millisecond})
+	f.set(errBackendDown, nil)
+	_, _ = b.Synthesize(context.Background(), "hi", nil)
+	time.Sleep(70 * time.Millisecond)
+	_, _ = b.Synthesize(context.Background(), "hi", nil)
+	if s := b.State(); s != CircuitClosed {
+		t.Errorf("state = %s, want closed: the first failure left the window", s)
+	}
+}
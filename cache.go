@@ -0,0 +1,452 @@
+package pockettts
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Cache stores synthesized audio by content key. Keys are lower-case hex
+// SHA-256 digests computed by CachedSynthesizer. Implementations must be safe
+// for concurrent use. Results passed to Put and returned by Get are shared:
+// neither the cache nor its callers may modify WAVResult.Data.
+type Cache interface {
+	// Get returns the result stored under key. A miss is (nil, false, nil).
+	Get(ctx context.Context, key string) (*WAVResult, bool, error)
+
+	// Put stores res under key, replacing any previous entry.
+	Put(ctx context.Context, key string, res *WAVResult) error
+}
+
+// synthesisParams are the inputs, besides the text, that determine the audio
+// a backend generates for a request.
+type synthesisParams struct {
+	Voice          string  `json:"voice"`
+	Config         string  `json:"config"`
+	Temperature    float64 `json:"temperature"`
+	LSDDecodeSteps int     `json:"lsd_decode_steps"`
+	NoiseClamp     float64 `json:"noise_clamp"`
+	EOSThreshold   float64 `json:"eos_threshold"`
+	FramesAfterEOS int     `json:"frames_after_eos"`
+	MaxTokens      int     `json:"max_tokens"`
+}
+
+// synthesisParamsResolver is implemented by backends that can resolve the
+// effective generation parameters of a request, including their own
+// defaults.
+type synthesisParamsResolver interface {
+	synthesisParams(opts *GenerateOptions) synthesisParams
+}
+
+// synthesisParams resolves opts against the Client's Options.
+func (c *Client) synthesisParams(opts *GenerateOptions) synthesisParams {
+	o := c.effectiveOptions(opts)
+	return synthesisParams{
+		Voice:          o.Voice,
+		Config:         o.Config,
+		Temperature:    o.Temperature,
+		LSDDecodeSteps: o.LSDDecodeSteps,
+		NoiseClamp:     o.NoiseClamp,
+		EOSThreshold:   o.EOSThreshold,
+		FramesAfterEOS: o.FramesAfterEOS,
+		MaxTokens:      o.MaxTokens,
+	}
+}
+
+// synthesisParams resolves opts against the server's defaults. The /tts
+// endpoint ignores the numeric generation parameters, so they stay zero.
+func (s *ServerClient) synthesisParams(opts *GenerateOptions) synthesisParams {
+	return synthesisParams{
+		Voice:  s.requestVoice(opts.serverOptions()),
+		Config: s.opts.Config,
+	}
+}
+
+// cacheKey returns the content key for synthesizing text with opts on s.
+func cacheKey(s Synthesizer, text string, opts *GenerateOptions) string {
+	var params synthesisParams
+	if r, ok := s.(synthesisParamsResolver); ok {
+		params = r.synthesisParams(opts)
+	} else if opts != nil {
+		params = synthesisParams{
+			Voice:          opts.voice(),
+			Temperature:    opts.Temperature,
+			LSDDecodeSteps: opts.LSDDecodeSteps,
+			NoiseClamp:     opts.NoiseClamp,
+			EOSThreshold:   opts.EOSThreshold,
+			FramesAfterEOS: opts.FramesAfterEOS,
+			MaxTokens:      opts.MaxTokens,
+		}
+	}
+
+	k := struct {
+		Text   string          `json:"text"`
+		Params synthesisParams `json:"params"`
+		Rate   uint32          `json:"sample_rate,omitempty"`
+		Chans  uint16          `json:"channels,omitempty"`
+		Bits   uint16          `json:"bits_per_sample,omitempty"`
+		Format AudioFormat     `json:"format,omitempty"`
+	}{Text: text, Params: params}
+	if opts != nil {
+		k.Rate, k.Chans, k.Bits = opts.SampleRate, opts.Channels, opts.BitsPerSample
+		if opts.Format != FormatWAV {
+			k.Format = opts.Format
+		}
+	}
+
+	b, _ := json.Marshal(k) // cannot fail: plain strings and numbers
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// CachedSynthesizer wraps a Synthesizer with a content-addressed Cache.
+//
+// The key covers the text, every parameter that affects the audio (voice,
+// config, temperature, LSD decode steps, noise clamp, EOS threshold, frames
+// after EOS, max tokens — resolved against the backend's defaults for Client
+// and ServerClient) and the output format options. Voice files are keyed by
+// path, not content. Requests with a custom GenerateOptions.Encoder bypass
+// the cache, since the encoder cannot be keyed.
+//
+// Concurrent identical requests are coalesced into one synthesis. Cache hits
+// have Stats.CacheHit set and Stats.Duration measuring the lookup. Every
+// caller gets its own copy of Data, so results may be modified freely.
+type CachedSynthesizer struct {
+	// OnError, if set, receives cache read and write failures. They never
+	// fail a request: a failed Get is treated as a miss and a failed Put
+	// only loses the entry.
+	OnError func(err error)
+
+	s     Synthesizer
+	cache Cache
+
+	mu       sync.Mutex
+	inFlight map[string]*cacheCall
+}
+
+// cacheCall is a synthesis shared by concurrent identical requests.
+type cacheCall struct {
+	done     chan struct{}
+	res      *WAVResult
+	err      error
+	canceled bool // the leader's context ended; followers should retry
+}
+
+// NewCachedSynthesizer returns s wrapped with cache.
+func NewCachedSynthesizer(s Synthesizer, cache Cache) *CachedSynthesizer {
+	return &CachedSynthesizer{s: s, cache: cache, inFlight: make(map[string]*cacheCall)}
+}
+
+// Synthesize implements Synthesizer, serving results from the cache when
+// possible.
+func (c *CachedSynthesizer) Synthesize(ctx context.Context, text string, opts *GenerateOptions) (*WAVResult, error) {
+	if opts != nil && opts.Encoder != nil {
+		return c.s.Synthesize(ctx, text, opts)
+	}
+	key := cacheKey(c.s, text, opts)
+
+	for {
+		start := time.Now()
+		if res, ok := c.get(ctx, key); ok {
+			out := detachResult(res)
+			out.Stats = GenerationStats{Duration: time.Since(start), CacheHit: true}
+			return out, nil
+		}
+
+		c.mu.Lock()
+		call, shared := c.inFlight[key]
+		if !shared {
+			call = &cacheCall{done: make(chan struct{})}
+			c.inFlight[key] = call
+		}
+		c.mu.Unlock()
+
+		if !shared {
+			c.lead(ctx, key, call, text, opts)
+			if call.err != nil {
+				return nil, call.err
+			}
+			return detachResult(call.res), nil
+		}
+
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, waitError(ctx.Err(), time.Since(start))
+		}
+		if call.canceled && ctx.Err() == nil {
+			continue // the leader gave up; try again with our own context
+		}
+		if call.err != nil {
+			return nil, call.err
+		}
+		return detachResult(call.res), nil
+	}
+}
+
+// detachResult returns a copy of a result shared with the cache, so that
+// the caller cannot modify the cached Data.
+func detachResult(res *WAVResult) *WAVResult {
+	out := *res
+	out.Data = bytes.Clone(res.Data)
+	return &out
+}
+
+// lead runs the synthesis for call and publishes its result.
+func (c *CachedSynthesizer) lead(ctx context.Context, key string, call *cacheCall, text string, opts *GenerateOptions) {
+	defer func() {
+		c.mu.Lock()
+		delete(c.inFlight, key)
+		c.mu.Unlock()
+		close(call.done)
+	}()
+
+	call.res, call.err = c.s.Synthesize(ctx, text, opts)
+	if call.err != nil {
+		call.canceled = ctx.Err() != nil
+		return
+	}
+	if err := c.cache.Put(ctx, key, call.res); err != nil {
+		c.reportError(fmt.Errorf("pockettts: cache put: %w", err))
+	}
+}
+
+func (c *CachedSynthesizer) get(ctx context.Context, key string) (*WAVResult, bool) {
+	res, ok, err := c.cache.Get(ctx, key)
+	if err != nil {
+		c.reportError(fmt.Errorf("pockettts: cache get: %w", err))
+		return nil, false
+	}
+	return res, ok
+}
+
+func (c *CachedSynthesizer) reportError(err error) {
+	if c.OnError != nil {
+		c.OnError(err)
+	}
+}
+
+// Health implements Synthesizer by checking the wrapped backend.
+func (c *CachedSynthesizer) Health(ctx context.Context) error {
+	return c.s.Health(ctx)
+}
+
+// Close implements Synthesizer by closing the wrapped backend.
+func (c *CachedSynthesizer) Close() error {
+	return c.s.Close()
+}
+
+// ---------------------------------------------------------------------------
+// In-memory LRU
+// ---------------------------------------------------------------------------
+
+// MemoryCache is an in-memory Cache that evicts the least recently used
+// entries once it holds more than MaxEntries results or MaxBytes of audio.
+type MemoryCache struct {
+	maxEntries int
+	maxBytes   int64
+
+	mu    sync.Mutex
+	bytes int64
+	order *list.List // front = most recently used
+	items map[string]*list.Element
+}
+
+type memoryEntry struct {
+	key string
+	res *WAVResult
+}
+
+// NewMemoryCache returns an LRU cache bounded by maxEntries results and
+// maxBytes of WAVResult.Data. Zero or negative means no limit.
+func NewMemoryCache(maxEntries int, maxBytes int64) *MemoryCache {
+	return &MemoryCache{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		order:      list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+// Get implements Cache.
+func (m *MemoryCache) Get(_ context.Context, key string) (*WAVResult, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	el, ok := m.items[key]
+	if !ok {
+		return nil, false, nil
+	}
+	m.order.MoveToFront(el)
+	return el.Value.(*memoryEntry).res, true, nil
+}
+
+// Put implements Cache. Results larger than maxBytes are not stored.
+func (m *MemoryCache) Put(_ context.Context, key string, res *WAVResult) error {
+	size := int64(len(res.Data))
+	if m.maxBytes > 0 && size > m.maxBytes {
+		return nil
+	}
+	stored := *res
+	stored.Stats = GenerationStats{}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if el, ok := m.items[key]; ok {
+		m.remove(el)
+	}
+	m.items[key] = m.order.PushFront(&memoryEntry{key: key, res: &stored})
+	m.bytes += size
+
+	for (m.maxEntries > 0 && m.order.Len() > m.maxEntries) || (m.maxBytes > 0 && m.bytes > m.maxBytes) {
+		m.remove(m.order.Back())
+	}
+	return nil
+}
+
+// Len returns the number of cached results.
+func (m *MemoryCache) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.order.Len()
+}
+
+func (m *MemoryCache) remove(el *list.Element) {
+	e := m.order.Remove(el).(*memoryEntry)
+	delete(m.items, e.key)
+	m.bytes -= int64(len(e.res.Data))
+}
+
+// ---------------------------------------------------------------------------
+// On-disk directory store
+// ---------------------------------------------------------------------------
+
+// DirCache is a Cache that stores one file per result in a directory, sharded
+// by the first two characters of the key. Writes are atomic, so several
+// processes may share a directory. DirCache never evicts; prune it externally
+// (e.g. by file modification time) if it must stay bounded.
+type DirCache struct {
+	dir string
+}
+
+// dirCacheMagic starts every DirCache file; its last byte is the layout
+// version.
+var dirCacheMagic = []byte("PTTSC\x01")
+
+// errCacheVersion reports an entry in another layout version, e.g. written
+// by a newer release sharing the directory. It is a miss, not corruption.
+var errCacheVersion = errors.New("unsupported cache entry version")
+
+// NewDirCache returns a DirCache rooted at dir, creating it if needed.
+func NewDirCache(dir string) (*DirCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("pockettts: create cache dir: %w", err)
+	}
+	return &DirCache{dir: dir}, nil
+}
+
+func (d *DirCache) path(key string) string {
+	if len(key) < 3 {
+		return filepath.Join(d.dir, key)
+	}
+	return filepath.Join(d.dir, key[:2], key)
+}
+
+// Get implements Cache. A corrupt entry is removed and reported as an error;
+// an entry in another layout version is left alone and reported as a miss.
+func (d *DirCache) Get(_ context.Context, key string) (*WAVResult, bool, error) {
+	data, err := os.ReadFile(d.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	res, err := decodeCacheEntry(data)
+	if errors.Is(err, errCacheVersion) {
+		return nil, false, nil
+	}
+	if err != nil {
+		_ = os.Remove(d.path(key))
+		return nil, false, fmt.Errorf("corrupt cache entry %s: %w", key, err)
+	}
+	return res, true, nil
+}
+
+// Put implements Cache.
+func (d *DirCache) Put(_ context.Context, key string, res *WAVResult) error {
+	path := d.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
+
+	// CreateTemp makes the file private; entries are readable by other
+	// processes sharing the directory, like the directory itself.
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(encodeCacheEntry(res)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// encodeCacheEntry serializes the audio and format of res (not its Stats).
+func encodeCacheEntry(res *WAVResult) []byte {
+	var buf bytes.Buffer
+	buf.Write(dirCacheMagic)
+	_ = binary.Write(&buf, binary.LittleEndian, res.SampleRate)
+	_ = binary.Write(&buf, binary.LittleEndian, res.Channels)
+	_ = binary.Write(&buf, binary.LittleEndian, res.BitsPerSample)
+	buf.WriteByte(byte(len(res.Format)))
+	buf.WriteString(string(res.Format))
+	buf.Write(res.Data)
+	return buf.Bytes()
+}
+
+func decodeCacheEntry(data []byte) (*WAVResult, error) {
+	const fixed = 4 + 2 + 2 + 1
+	v := len(dirCacheMagic) - 1
+	if !bytes.HasPrefix(data, dirCacheMagic[:v]) || len(data) <= v {
+		return nil, errors.New("bad header")
+	}
+	if data[v] != dirCacheMagic[v] {
+		return nil, errCacheVersion
+	}
+	if len(data) < len(dirCacheMagic)+fixed {
+		return nil, errors.New("bad header")
+	}
+	p := data[len(dirCacheMagic):]
+	res := &WAVResult{
+		SampleRate:    binary.LittleEndian.Uint32(p[0:]),
+		Channels:      binary.LittleEndian.Uint16(p[4:]),
+		BitsPerSample: binary.LittleEndian.Uint16(p[6:]),
+	}
+	n := int(p[8])
+	p = p[fixed:]
+	if len(p) < n {
+		return nil, errors.New("truncated format")
+	}
+	res.Format = AudioFormat(p[:n])
+	res.Data = p[n:]
+	return res, nil
+}
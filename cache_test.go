@@ -0,0 +1,401 @@
+package pockettts
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingSynth counts Synthesize calls and blocks each one until release is
+// closed (if set).
+type countingSynth struct {
+	calls   atomic.Int32
+	release chan struct{}
+	err     error
+}
+
+func (c *countingSynth) Synthesize(ctx context.Context, text string, _ *GenerateOptions) (*WAVResult, error) {
+	c.calls.Add(1)
+	if c.release != nil {
+		select {
+		case <-c.release:
+		case <-ctx.Done():
+			return nil, &ErrProcessTimeout{}
+		}
+	}
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &WAVResult{
+		Data:          encodeWAV(24000, 1, 16, []byte(text)),
+		SampleRate:    24000,
+		Channels:      1,
+		BitsPerSample: 16,
+		Stats:         GenerationStats{Duration: time.Second},
+	}, nil
+}
+
+func (c *countingSynth) Health(context.Context) error { return nil }
+func (c *countingSynth) Close() error                 { return nil }
+
+// ---------------------------------------------------------------------------
+// Keys
+// ---------------------------------------------------------------------------
+
+func TestCacheKey_CoversAudioParameters(t *testing.T) {
+	c := NewClient(Options{Voice: "alba", Temperature: 0.7})
+	base := cacheKey(c, "Hello", nil)
+
+	if k := cacheKey(c, "Hello", &GenerateOptions{Voice: "alba", Temperature: 0.7}); k != base {
+		t.Error("explicit options equal to the client defaults should give the same key")
+	}
+
+	variants := map[string]*GenerateOptions{
+		"voice":            {Voice: "marius"},
+		"temperature":      {Temperature: 0.3},
+		"lsd decode steps": {LSDDecodeSteps: 4},
+		"noise clamp":      {NoiseClamp: 1},
+		"eos threshold":    {EOSThreshold: -3},
+		"frames after eos": {FramesAfterEOS: 2},
+		"max tokens":       {MaxTokens: 50},
+		"sample rate":      {SampleRate: 8000},
+		"format":           {Format: FormatFLAC},
+	}
+	seen := map[string]string{base: "base"}
+	for name, opts := range variants {
+		k := cacheKey(c, "Hello", opts)
+		if prev, dup := seen[k]; dup {
+			t.Errorf("%s: same key as %s", name, prev)
+		}
+		seen[k] = name
+	}
+
+	if cacheKey(c, "Hello!", nil) == base {
+		t.Error("text must be part of the key")
+	}
+	if cacheKey(NewClient(Options{Voice: "alba", Temperature: 0.7, Config: "x.yaml"}), "Hello", nil) == base {
+		t.Error("config must be part of the key")
+	}
+	if cacheKey(c, "Hello", &GenerateOptions{Format: FormatWAV}) != base {
+		t.Error("explicit FormatWAV should match the default format")
+	}
+}
+
+func TestCacheKey_ServerIgnoresCLIParameters(t *testing.T) {
+	s := NewServerClient(ServerOptions{Voice: "alba"})
+	base := cacheKey(s, "Hello", nil)
+	if cacheKey(s, "Hello", &GenerateOptions{Temperature: 0.3}) != base {
+		t.Error("temperature is not sent to the server and should not change the key")
+	}
+	if cacheKey(s, "Hello", &GenerateOptions{Voice: "alba"}) != base {
+		t.Error("the server's default voice should resolve to the same key")
+	}
+	if cacheKey(s, "Hello", &GenerateOptions{Voice: "marius"}) == base {
+		t.Error("voice must be part of the key")
+	}
+}
+
+// ---------------------------------------------------------------------------
+// CachedSynthesizer
+// ---------------------------------------------------------------------------
+
+func TestCachedSynthesizer_Hit(t *testing.T) {
+	backend := &countingSynth{}
+	cs := NewCachedSynthesizer(backend, NewMemoryCache(0, 0))
+
+	first, err := cs.Synthesize(context.Background(), "Hello", nil)
+	if err != nil {
+		t.Fatalf("first call: %v", err)
+	}
+	if first.Stats.CacheHit {
+		t.Error("first call should not be a cache hit")
+	}
+	second, err := cs.Synthesize(context.Background(), "Hello", nil)
+	if err != nil {
+		t.Fatalf("second call: %v", err)
+	}
+	if !second.Stats.CacheHit || second.Stats.Duration >= time.Second {
+		t.Errorf("expected cache hit with lookup duration, got %+v", second.Stats)
+	}
+	if !bytes.Equal(first.Data, second.Data) || second.SampleRate != 24000 {
+		t.Error("cached result differs from the original")
+	}
+	if n := backend.calls.Load(); n != 1 {
+		t.Errorf("backend called %d times, want 1", n)
+	}
+
+	if _, err := cs.Synthesize(context.Background(), "Other", nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := backend.calls.Load(); n != 2 {
+		t.Errorf("different text should miss; backend called %d times", n)
+	}
+}
+
+func TestCachedSynthesizer_ResultsDoNotAliasCache(t *testing.T) {
+	cs := NewCachedSynthesizer(&countingSynth{}, NewMemoryCache(0, 0))
+
+	first, err := cs.Synthesize(context.Background(), "Hello", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := bytes.Clone(first.Data)
+	clear(first.Data)
+
+	second, err := cs.Synthesize(context.Background(), "Hello", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(second.Data, want) {
+		t.Fatal("modifying the leader's result corrupted the cache")
+	}
+	clear(second.Data)
+
+	third, err := cs.Synthesize(context.Background(), "Hello", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(third.Data, want) {
+		t.Error("modifying a cache hit corrupted the cache")
+	}
+}
+
+func TestCachedSynthesizer_Coalesces(t *testing.T) {
+	backend := &countingSynth{release: make(chan struct{})}
+	cs := NewCachedSynthesizer(backend, NewMemoryCache(0, 0))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := cs.Synthesize(context.Background(), "Hello", nil)
+			errs <- err
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(backend.release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if n := backend.calls.Load(); n != 1 {
+		t.Errorf("backend called %d times for identical concurrent requests, want 1", n)
+	}
+}
+
+func TestCachedSynthesizer_LeaderCanceled(t *testing.T) {
+	backend := &countingSynth{release: make(chan struct{})}
+	cs := NewCachedSynthesizer(backend, NewMemoryCache(0, 0))
+
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	leaderDone := make(chan error, 1)
+	go func() {
+		_, err := cs.Synthesize(leaderCtx, "Hello", nil)
+		leaderDone <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	followerDone := make(chan error, 1)
+	go func() {
+		_, err := cs.Synthesize(context.Background(), "Hello", nil)
+		followerDone <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	cancelLeader()
+	if err := <-leaderDone; err == nil {
+		t.Error("leader should fail after its context is cancelled")
+	}
+	close(backend.release)
+	if err := <-followerDone; err != nil {
+		t.Errorf("follower should retry with its own context, got %v", err)
+	}
+	if n := backend.calls.Load(); n != 2 {
+		t.Errorf("backend called %d times, want 2", n)
+	}
+}
+
+func TestCachedSynthesizer_ErrorsNotCached(t *testing.T) {
+	backend := &countingSynth{err: &ErrNonZeroExit{ExitCode: 1}}
+	cs := NewCachedSynthesizer(backend, NewMemoryCache(0, 0))
+	for i := 0; i < 2; i++ {
+		if _, err := cs.Synthesize(context.Background(), "Hello", nil); err == nil {
+			t.Fatal("expected error")
+		}
+	}
+	if n := backend.calls.Load(); n != 2 {
+		t.Errorf("failures must not be cached; backend called %d times", n)
+	}
+}
+
+func TestCachedSynthesizer_CustomEncoderBypasses(t *testing.T) {
+	backend := &countingSynth{}
+	cache := NewMemoryCache(0, 0)
+	cs := NewCachedSynthesizer(backend, cache)
+	opts := &GenerateOptions{Encoder: EncoderFunc(func(context.Context, *WAVResult) ([]byte, error) { return nil, nil })}
+	for i := 0; i < 2; i++ {
+		if _, err := cs.Synthesize(context.Background(), "Hello", opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if backend.calls.Load() != 2 || cache.Len() != 0 {
+		t.Errorf("custom encoder should bypass the cache (calls=%d, entries=%d)", backend.calls.Load(), cache.Len())
+	}
+}
+
+// failingCache fails every operation.
+type failingCache struct{}
+
+func (failingCache) Get(context.Context, string) (*WAVResult, bool, error) {
+	return nil, false, errors.New("disk on fire")
+}
+func (failingCache) Put(context.Context, string, *WAVResult) error { return errors.New("disk on fire") }
+
+func TestCachedSynthesizer_CacheErrors(t *testing.T) {
+	cs := NewCachedSynthesizer(&countingSynth{}, failingCache{})
+	var reported []error
+	cs.OnError = func(err error) { reported = append(reported, err) }
+	if _, err := cs.Synthesize(context.Background(), "Hello", nil); err != nil {
+		t.Fatalf("cache failures must not fail the request: %v", err)
+	}
+	if len(reported) != 2 {
+		t.Errorf("expected get and put errors to be reported, got %v", reported)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// MemoryCache
+// ---------------------------------------------------------------------------
+
+func TestMemoryCache_LRU(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryCache(2, 0)
+	for _, k := range []string{"a", "b"} {
+		_ = m.Put(ctx, k, &WAVResult{Data: []byte(k)})
+	}
+	_, _, _ = m.Get(ctx, "a") // a is now most recently used
+	_ = m.Put(ctx, "c", &WAVResult{Data: []byte("c")})
+
+	if _, ok, _ := m.Get(ctx, "b"); ok {
+		t.Error("b should have been evicted")
+	}
+	for _, k := range []string{"a", "c"} {
+		if _, ok, _ := m.Get(ctx, k); !ok {
+			t.Errorf("%s should still be cached", k)
+		}
+	}
+}
+
+func TestMemoryCache_MaxBytes(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryCache(0, 10)
+	_ = m.Put(ctx, "a", &WAVResult{Data: make([]byte, 6)})
+	_ = m.Put(ctx, "b", &WAVResult{Data: make([]byte, 6)})
+	if _, ok, _ := m.Get(ctx, "a"); ok || m.Len() != 1 {
+		t.Errorf("expected a to be evicted by the byte limit (len=%d)", m.Len())
+	}
+	_ = m.Put(ctx, "huge", &WAVResult{Data: make([]byte, 11)})
+	if _, ok, _ := m.Get(ctx, "huge"); ok {
+		t.Error("entries larger than the byte limit should not be stored")
+	}
+}
+
+// ---------------------------------------------------------------------------
+// DirCache
+// ---------------------------------------------------------------------------
+
+func TestDirCache_RoundTrip(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	d, err := NewDirCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := cacheKey(&countingSynth{}, "Hello", nil)
+	in := &WAVResult{Data: []byte("fLaC..."), Format: FormatFLAC, SampleRate: 16000, Channels: 2, BitsPerSample: 24}
+	if err := d.Put(ctx, key, in); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	// A second instance on the same directory sees the entry.
+	d2, _ := NewDirCache(dir)
+	out, ok, err := d2.Get(ctx, key)
+	if err != nil || !ok {
+		t.Fatalf("Get: ok=%v err=%v", ok, err)
+	}
+	if !bytes.Equal(out.Data, in.Data) || out.Format != FormatFLAC || out.SampleRate != 16000 ||
+		out.Channels != 2 || out.BitsPerSample != 24 {
+		t.Errorf("round trip mismatch: %+v", out)
+	}
+
+	if _, ok, err := d.Get(ctx, "0000"); ok || err != nil {
+		t.Errorf("missing key: ok=%v err=%v", ok, err)
+	}
+}
+
+func TestDirCache_Corrupt(t *testing.T) {
+	ctx := context.Background()
+	d, _ := NewDirCache(t.TempDir())
+	key := "abcdef"
+	_ = d.Put(ctx, key, &WAVResult{Data: []byte("x")})
+	if err := os.WriteFile(d.path(key), []byte("garbage"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := d.Get(ctx, key); ok || err == nil {
+		t.Errorf("expected corrupt entry error, got ok=%v err=%v", ok, err)
+	}
+	if _, err := os.Stat(d.path(key)); !os.IsNotExist(err) {
+		t.Error("corrupt entry should be removed")
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(d.path(key))); len(entries) != 0 {
+		t.Errorf("temporary files left behind: %v", entries)
+	}
+}
+
+func TestDirCache_OtherVersionKept(t *testing.T) {
+	ctx := context.Background()
+	d, _ := NewDirCache(t.TempDir())
+	key := "abcdef"
+	_ = d.Put(ctx, key, &WAVResult{Data: []byte("x")})
+	entry := append([]byte("PTTSC\x02"), "a future layout"...)
+	if err := os.WriteFile(d.path(key), entry, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := d.Get(ctx, key); ok || err != nil {
+		t.Errorf("other version: ok=%v err=%v, want a miss", ok, err)
+	}
+	if _, err := os.Stat(d.path(key)); err != nil {
+		t.Errorf("entry of another version should be kept: %v", err)
+	}
+}
+
+func TestDirCache_EntriesShareable(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Unix permissions")
+	}
+	d, _ := NewDirCache(t.TempDir())
+	key := "abcdef"
+	if err := d.Put(context.Background(), key, &WAVResult{Data: []byte("x")}); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(d.path(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0o644 {
+		t.Errorf("entry mode %v, want 0644", perm)
+	}
+}
@@ -0,0 +1,102 @@
+package pockettts
+
+import (
+	"os"
+	"regexp"
+	"strings"
+)
+
+// stderr patterns recognised by classifyExit. They are matched against the
+// stderr excerpt of the CLI (or the error body of the server) and are
+// deliberately loose, because pocket-tts, Hugging Face Hub and PyTorch word
+// their errors differently across versions.
+var (
+	reHFAuth = regexp.MustCompile(`(?i)gatedrepoerror|gated repo|cannot access gated|` +
+		`repositorynotfounderror|access to model .* is restricted`)
+
+	// reAuth matches generic authentication failures. They only count as a
+	// Hugging Face download failure together with reHFDownload, since a
+	// proxy in front of the server fails the same way.
+	reAuth = regexp.MustCompile(`(?i)401 client error|403 client error|unauthorized|` +
+		`invalid (user )?token|you must be authenticated`)
+
+	reHFDownload = regexp.MustCompile(`(?i)huggingface|hf_hub|hf\.co|localentrynotfounderror|` +
+		`snapshot_download|hf_hub_download`)
+
+	reNetwork = regexp.MustCompile(`(?i)connection(error| (reset|refused|aborted))|timed? ?out|` +
+		`max retries exceeded|temporary failure in name resolution|name or service not known|` +
+		`failed to establish a new connection|offline mode|cannot find the requested files|` +
+		`incompleteread|chunkedencodingerror|sslerror|502 server error|503 server error`)
+
+	reOOM = regexp.MustCompile(`(?i)out of memory|outofmemoryerror|memoryerror|` +
+		`cannot allocate memory|std::bad_alloc|defaultcpuallocator: can't allocate|` +
+		`(^|\n)\s*killed\s*$`)
+
+	reMissingModule = regexp.MustCompile(`(?i)no module named '?([\w.]+)'?`)
+
+	reMissingTorch = regexp.MustCompile(`(?i)(importerror|oserror).*(torch|libtorch|libc10)`)
+
+	reUnknownVoice = regexp.MustCompile(`(?i)unknown voice|invalid voice|not a valid voice|` +
+		`voice .*(not found|does not exist|is not (one of|a (valid|known|predefined)))|` +
+		`(predefined|available) voices`)
+
+	reFileError = regexp.MustCompile(`(?i)no such file or directory|filenotfounderror|` +
+		`permission denied|isadirectoryerror|safetensorerror|headertoolarge|` +
+		`error opening|could not (open|read|load)|failed to (open|read|load)|` +
+		`format not recognised|libsndfile|soundfile|audioread`)
+)
+
+// classifyExit inspects the stderr of a failed pocket-tts invocation and wraps
+// exit in a more specific error type when the failure is recognised:
+//
+//	Hugging Face auth / gated model   → *ErrModelDownloadFailed{AuthRequired: true}
+//	Hugging Face network failure      → *ErrModelDownloadFailed
+//	out of memory (incl. SIGKILL)     → *ErrOutOfMemory
+//	missing Python module / torch     → *ErrMissingDependency
+//	unknown voice, bad voice file     → *ErrInvalidVoice
+//
+// voice is the voice that was requested (a name, path or URL), used both to
+// attribute file errors to the voice and to fill ErrInvalidVoice.Voice. All
+// returned errors unwrap to exit; unrecognised failures return exit itself.
+func classifyExit(exit *ErrNonZeroExit, voice string) error {
+	stderr := exit.Stderr
+
+	switch {
+	case reHFAuth.MatchString(stderr),
+		reAuth.MatchString(stderr) && reHFDownload.MatchString(stderr):
+		return &ErrModelDownloadFailed{Stderr: stderr, AuthRequired: true, Exit: exit}
+	case reHFDownload.MatchString(stderr) && reNetwork.MatchString(stderr):
+		return &ErrModelDownloadFailed{Stderr: stderr, Exit: exit}
+	case exit.Signal == os.Kill, !exit.HTTP && exit.ExitCode == 128+9, reOOM.MatchString(stderr):
+		// A shell reports a child killed by SIGKILL as exit 137.
+		return &ErrOutOfMemory{Exit: exit}
+	}
+
+	if m := reMissingModule.FindStringSubmatch(stderr); m != nil {
+		return &ErrMissingDependency{Module: m[1], Exit: exit}
+	}
+	if reMissingTorch.MatchString(stderr) {
+		return &ErrMissingDependency{Module: "torch", Exit: exit}
+	}
+
+	if reUnknownVoice.MatchString(stderr) {
+		return &ErrInvalidVoice{Voice: voice, Exit: exit}
+	}
+	if voice != "" && reFileError.MatchString(stderr) && mentionsVoice(stderr, voice) {
+		return &ErrInvalidVoice{Voice: voice, Exit: exit}
+	}
+
+	return exit
+}
+
+// mentionsVoice reports whether stderr refers to the requested voice, either
+// by its full value or by its base name.
+func mentionsVoice(stderr, voice string) bool {
+	if strings.Contains(stderr, voice) {
+		return true
+	}
+	if i := strings.LastIndexAny(voice, `/\`); i >= 0 && i+1 < len(voice) {
+		return strings.Contains(stderr, voice[i+1:])
+	}
+	return false
+}
@@ -0,0 +1,285 @@
+package pockettts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+)
+
+// ---------------------------------------------------------------------------
+// classifyExit
+// ---------------------------------------------------------------------------
+
+func TestClassifyExit(t *testing.T) {
+	cases := []struct {
+		name   string
+		code   int
+		stderr string
+		voice  string
+		want   any
+	}{
+		{
+			"unknown voice",
+			1, "ValueError: Unknown voice 'bob'. Available voices: alba, marius", "bob",
+			&ErrInvalidVoice{},
+		},
+		{
+			"missing voice file",
+			1, "FileNotFoundError: [Errno 2] No such file or directory: '/voices/me.safetensors'", "/voices/me.safetensors",
+			&ErrInvalidVoice{},
+		},
+		{
+			"unreadable voice file",
+			1, "soundfile.LibsndfileError: Error opening 'me.wav': Format not recognised.", "/tmp/me.wav",
+			&ErrInvalidVoice{},
+		},
+		{
+			"gated model",
+			1, "huggingface_hub.errors.GatedRepoError: 401 Client Error. Cannot access gated repo for url https://huggingface.co/kyutai/pocket-tts", "",
+			&ErrModelDownloadFailed{AuthRequired: true},
+		},
+		{
+			"download network failure",
+			1, "huggingface_hub.errors.LocalEntryNotFoundError: ... ConnectionError: Max retries exceeded with url: /kyutai/pocket-tts", "",
+			&ErrModelDownloadFailed{},
+		},
+		{
+			"torch OOM",
+			1, "RuntimeError: [enforce fail at alloc_cpu.cpp:114] . DefaultCPUAllocator: can't allocate memory: you tried to allocate 123 bytes.", "",
+			&ErrOutOfMemory{},
+		},
+		{
+			"OOM killer",
+			137, "", "",
+			&ErrOutOfMemory{},
+		},
+		{
+			"missing torch",
+			1, "Traceback (most recent call last):\nModuleNotFoundError: No module named 'torch'", "",
+			&ErrMissingDependency{Module: "torch"},
+		},
+		{
+			"unrelated file error is not a voice error",
+			1, "FileNotFoundError: [Errno 2] No such file or directory: 'config.toml'", "alba",
+			&ErrNonZeroExit{},
+		},
+		{
+			"auth failure without Hugging Face context",
+			1, "401 Unauthorized", "",
+			&ErrNonZeroExit{},
+		},
+		{
+			"Hugging Face token rejected",
+			1, "huggingface_hub.errors.HfHubHTTPError: 401 Client Error: Unauthorized for url: https://huggingface.co/api/models/kyutai/pocket-tts", "",
+			&ErrModelDownloadFailed{AuthRequired: true},
+		},
+		{
+			"unclassified",
+			1, "RuntimeError: something odd", "",
+			&ErrNonZeroExit{},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			exit := &ErrNonZeroExit{ExitCode: tc.code, Stderr: tc.stderr}
+			err := classifyExit(exit, tc.voice)
+
+			switch want := tc.want.(type) {
+			case *ErrInvalidVoice:
+				var got *ErrInvalidVoice
+				if !errors.As(err, &got) {
+					t.Fatalf("got %T: %v, want *ErrInvalidVoice", err, err)
+				}
+				if got.Voice != tc.voice {
+					t.Errorf("Voice: got %q, want %q", got.Voice, tc.voice)
+				}
+			case *ErrModelDownloadFailed:
+				var got *ErrModelDownloadFailed
+				if !errors.As(err, &got) {
+					t.Fatalf("got %T: %v, want *ErrModelDownloadFailed", err, err)
+				}
+				if got.AuthRequired != want.AuthRequired {
+					t.Errorf("AuthRequired: got %v, want %v", got.AuthRequired, want.AuthRequired)
+				}
+			case *ErrOutOfMemory:
+				var got *ErrOutOfMemory
+				if !errors.As(err, &got) {
+					t.Fatalf("got %T: %v, want *ErrOutOfMemory", err, err)
+				}
+			case *ErrMissingDependency:
+				var got *ErrMissingDependency
+				if !errors.As(err, &got) {
+					t.Fatalf("got %T: %v, want *ErrMissingDependency", err, err)
+				}
+				if got.Module != want.Module {
+					t.Errorf("Module: got %q, want %q", got.Module, want.Module)
+				}
+			case *ErrNonZeroExit:
+				if err != exit {
+					t.Fatalf("got %T: %v, want the unchanged *ErrNonZeroExit", err, err)
+				}
+			}
+
+			// Every classified error must still unwrap to the exit error.
+			var exitErr *ErrNonZeroExit
+			if !errors.As(err, &exitErr) || exitErr != exit {
+				t.Errorf("%T does not unwrap to *ErrNonZeroExit", err)
+			}
+		})
+	}
+}
+
+func TestClassifyExit_KilledChild(t *testing.T) {
+	exe := fakeExecutable(t, `cat >/dev/null; kill -9 $$`)
+	c := NewClient(Options{ExecutablePath: exe})
+
+	_, err := c.Generate(context.Background(), "hello")
+	var oom *ErrOutOfMemory
+	if !errors.As(err, &oom) {
+		t.Fatalf("err = %v, want *ErrOutOfMemory", err)
+	}
+	if oom.Exit.Signal != os.Kill || oom.Exit.ExitCode != -1 {
+		t.Errorf("Exit = %+v, want SIGKILL and exit code -1", oom.Exit)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// IsRetryable
+// ---------------------------------------------------------------------------
+
+func TestIsRetryable(t *testing.T) {
+	exit := &ErrNonZeroExit{ExitCode: 1}
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"empty text", ErrEmptyText, false},
+		{"not found", &ErrExecutableNotFound{Executable: "x"}, false},
+		{"invalid voice", &ErrInvalidVoice{Voice: "bob", Exit: exit}, false},
+		{"missing dependency", &ErrMissingDependency{Module: "torch", Exit: exit}, false},
+		{"gated model", &ErrModelDownloadFailed{AuthRequired: true, Exit: exit}, false},
+		{"download network", &ErrModelDownloadFailed{Exit: exit}, true},
+		{"oom", &ErrOutOfMemory{Exit: exit}, true},
+		{"generic exit", exit, true},
+		{"cli usage error", &ErrNonZeroExit{ExitCode: 2}, false},
+		{"cli exit 404", &ErrNonZeroExit{ExitCode: 404}, true},
+		{"http 400", &ErrNonZeroExit{ExitCode: 400, HTTP: true}, false},
+		{"http 429", &ErrNonZeroExit{ExitCode: 429, HTTP: true}, true},
+		{"http 503", &ErrNonZeroExit{ExitCode: 503, HTTP: true}, true},
+		{"process timeout", &ErrProcessTimeout{Err: context.DeadlineExceeded}, true},
+		{"queue timeout", &ErrQueueTimeout{Err: context.DeadlineExceeded}, true},
+		{"canceled", &ErrCanceled{Err: context.Canceled}, false},
+		{"canceled while queued", &ErrCanceled{Queued: true, Err: context.Canceled}, false},
+		{"server stopped", ErrServerStopped, false},
+		{"wrapped server stopped", fmt.Errorf("pool member: %w", ErrServerStopped), false},
+		{"queue full", ErrQueueFull, true},
+	}
+	for _, tc := range cases {
+		if got := IsRetryable(tc.err); got != tc.want {
+			t.Errorf("%s: IsRetryable = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	exit := &ErrNonZeroExit{ExitCode: 1}
+	cases := []struct {
+		err  error
+		want ErrorClass
+	}{
+		{nil, ""},
+		{&ErrCanceled{Queued: true, Err: context.Canceled}, ErrorClassCanceled},
+		{ErrQueueFull, ErrorClassQueueFull},
+		{&ErrQueueTimeout{Err: context.DeadlineExceeded}, ErrorClassQueueTimeout},
+		{&ErrProcessTimeout{}, ErrorClassTimeout},
+		{ErrServerRestarting, ErrorClassUnavailable},
+		{&ErrCircuitOpen{}, ErrorClassUnavailable},
+		{&ErrExecutableNotFound{Executable: "x"}, ErrorClassNotFound},
+		{ErrEmptyText, ErrorClassInvalidRequest},
+		{&ErrInvalidVoice{Voice: "bob", Exit: exit}, ErrorClassInvalidRequest},
+		{&ErrNonZeroExit{ExitCode: 422, HTTP: true}, ErrorClassInvalidRequest},
+		{&ErrModelDownloadFailed{Exit: exit}, ErrorClassModelDownload},
+		{&ErrOutOfMemory{Exit: exit}, ErrorClassOutOfMemory},
+		{&ErrMissingDependency{Module: "torch", Exit: exit}, ErrorClassMissingDependency},
+		{exit, ErrorClassBackend},
+		{&ErrNonZeroExit{ExitCode: 429, HTTP: true}, ErrorClassBackend},
+		{errors.New("connection refused"), ErrorClassOther},
+	}
+	for _, tc := range cases {
+		if got := ClassifyError(tc.err); got != tc.want {
+			t.Errorf("ClassifyError(%v) = %q, want %q", tc.err, got, tc.want)
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Integration with runner and ServerClient
+// ---------------------------------------------------------------------------
+
+func TestRunner_ClassifiesStderr(t *testing.T) {
+	exe := fakeExecutable(t, `echo "ModuleNotFoundError: No module named 'torch'" >&2; exit 1`)
+	r := &runner{executablePath: exe}
+	_, err := r.run(context.Background(), nil, nil)
+
+	var dep *ErrMissingDependency
+	if !errors.As(err, &dep) {
+		t.Fatalf("expected ErrMissingDependency, got %T: %v", err, err)
+	}
+	var exitErr *ErrNonZeroExit
+	if !errors.As(err, &exitErr) || exitErr.ExitCode != 1 {
+		t.Errorf("expected to unwrap to ErrNonZeroExit with code 1, got %v", err)
+	}
+}
+
+func TestClient_InvalidVoice(t *testing.T) {
+	exe := fakeExecutable(t, `cat >/dev/null; echo "ValueError: unknown voice 'bob'" >&2; exit 1`)
+	c := NewClient(Options{ExecutablePath: exe})
+	_, err := c.GenerateWithOptions(context.Background(), "Hello", &GenerateOptions{Voice: "bob"})
+
+	var voiceErr *ErrInvalidVoice
+	if !errors.As(err, &voiceErr) {
+		t.Fatalf("expected ErrInvalidVoice, got %T: %v", err, err)
+	}
+	if voiceErr.Voice != "bob" {
+		t.Errorf("Voice: got %q, want %q", voiceErr.Voice, "bob")
+	}
+}
+
+func TestServerClient_Generate_ClassifiesErrorBody(t *testing.T) {
+	fs := newFakeServer(http.StatusOK, http.StatusBadRequest, []byte(`{"detail":"Unknown voice: bob"}`))
+	defer fs.ts.Close()
+
+	sc := serverClientFor(fs.ts)
+	_, err := sc.Generate(context.Background(), "Hello", &ServerGenerateOptions{VoiceURL: "bob"})
+	var voiceErr *ErrInvalidVoice
+	if !errors.As(err, &voiceErr) {
+		t.Fatalf("expected ErrInvalidVoice, got %T: %v", err, err)
+	}
+	if IsRetryable(err) {
+		t.Error("invalid voice must not be retryable")
+	}
+}
+
+func TestServerClient_Generate_ProxyAuthFailure(t *testing.T) {
+	fs := newFakeServer(http.StatusOK, http.StatusUnauthorized, []byte("401 Unauthorized"))
+	defer fs.ts.Close()
+
+	sc := serverClientFor(fs.ts)
+	_, err := sc.Generate(context.Background(), "Hello", nil)
+	var download *ErrModelDownloadFailed
+	if errors.As(err, &download) {
+		t.Fatalf("proxy auth failure classified as download failure: %v", err)
+	}
+	var exitErr *ErrNonZeroExit
+	if !errors.As(err, &exitErr) || !exitErr.HTTP || exitErr.ExitCode != http.StatusUnauthorized {
+		t.Fatalf("expected HTTP 401 ErrNonZeroExit, got %T: %v", err, err)
+	}
+	if IsRetryable(err) {
+		t.Error("HTTP 401 must not be retryable")
+	}
+}
@@ -13,37 +13,69 @@ import (
 
 // newClient constructs a client from options (internal).
 func newClient(opts *Options) *Client {
-	c := &Client{opts: *opts}
-	if opts.Concurrency > 0 {
-		c.sem = make(chan struct{}, opts.Concurrency)
+	return &Client{
+		opts:  *opts,
+		slots: &limiter{limit: opts.Concurrency, aging: opts.QueueAging},
 	}
-	return c
 }
 
-// generate is the core implementation shared by Client.Generate and the
-// package-level Generate function.
-func (c *Client) generate(ctx context.Context, text string) (*WAVResult, error) {
+// acquire takes a slot from the concurrency limiter, blocking until one is
+// free or ctx is done (*ErrQueueTimeout or *ErrCanceled). Waiting requests
+// are admitted by priority. The returned func releases the slot; waited is
+// the time spent in the queue.
+func (c *Client) acquire(ctx context.Context, prio Priority) (release func(), waited time.Duration, err error) {
+	return c.slots.acquire(ctx, prio)
+}
+
+// Queued returns the number of requests waiting for a concurrency slot (see
+// Options.Concurrency).
+func (c *Client) Queued() int {
+	return c.slots.queued()
+}
+
+// QueueDepth returns the number of requests waiting for a concurrency slot
+// by priority. Priorities without waiting requests are omitted.
+func (c *Client) QueueDepth() map[Priority]int {
+	return c.slots.depth()
+}
+
+// generate is the core implementation shared by Client.Generate,
+// Client.Synthesize and the package-level Generate function.
+// opts may be nil.
+func (c *Client) generate(ctx context.Context, text string, opts *GenerateOptions) (*WAVResult, error) {
 	// Input validation
 	if strings.TrimSpace(text) == "" {
 		return nil, ErrEmptyText
 	}
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
 
-	// Concurrency limiter: acquire slot (blocks until one is free or ctx is done).
-	if c.sem != nil {
-		select {
-		case c.sem <- struct{}{}:
-			defer func() { <-c.sem }()
-		case <-ctx.Done():
-			return nil, &ErrProcessTimeout{Stderr: "context cancelled while waiting for concurrency slot"}
-		}
+	res, err := c.opts.Retry.do(ctx, func(ctx context.Context) (*WAVResult, error) {
+		return c.generateOnce(ctx, text, opts)
+	})
+	if err != nil {
+		return nil, err
 	}
+	return finishResult(ctx, res, opts)
+}
 
-	args := c.buildArgs()
+// generateOnce runs a single pocket-tts subprocess for generate and returns
+// its WAV output.
+func (c *Client) generateOnce(ctx context.Context, text string, opts *GenerateOptions) (result *WAVResult, err error) {
+	obs := observe(c.opts.Observer, ModeCLI, opts.priority())
+	defer func() { obs.done(result, err) }()
 
-	r := &runner{
-		executablePath: c.opts.ExecutablePath,
-		logWriter:      c.opts.LogWriter,
+	release, queued, err := c.acquire(ctx, opts.priority())
+	if err != nil {
+		return nil, err
 	}
+	defer release()
+	obs.admitted(queued)
+
+	args := c.buildArgs(opts)
+
+	r := c.newRunner(opts)
 
 	start := time.Now()
 	res, err := r.run(ctx, args, []byte(text))
@@ -61,20 +93,36 @@ func (c *Client) generate(ctx context.Context, text string) (*WAVResult, error)
 		return nil, err
 	}
 
-	if c.opts.LogWriter != nil {
-		fmt.Fprintf(c.opts.LogWriter, "pockettts: generated %d bytes in %s (mode=cli)\n",
-			len(res.stdout), elapsed.Round(time.Millisecond))
+	stats := GenerationStats{
+		Duration:  elapsed,
+		QueueWait: queued,
+		SpawnTime: res.spawn,
+		TTFB:      res.ttfb,
+		ExitCode:  res.exitCode,
+		Backend:   ModeCLI,
 	}
+	stats.measure(res.stdout)
+	stats.log(c.opts.LogWriter)
 
 	return &WAVResult{
 		Data:          res.stdout,
 		SampleRate:    sr,
 		Channels:      ch,
 		BitsPerSample: bps,
-		Stats:         GenerationStats{Duration: elapsed},
+		Stats:         stats,
 	}, nil
 }
 
+// newRunner returns a runner configured from the Client's options and the
+// per-call overrides in opts (which may be nil).
+func (c *Client) newRunner(opts *GenerateOptions) *runner {
+	return &runner{
+		executablePath: c.opts.ExecutablePath,
+		logWriter:      c.opts.LogWriter,
+		voice:          c.effectiveOptions(opts).Voice,
+	}
+}
+
 // parseWAVHeader reads WAV metadata from data and returns format information.
 func parseWAVHeader(data []byte) (sampleRate uint32, channels uint16, bitsPerSample uint16, err error) {
 	if len(data) == 0 {
@@ -94,7 +142,40 @@ func parseWAVHeader(data []byte) (sampleRate uint32, channels uint16, bitsPerSam
 	return dec.SampleRate, dec.NumChans, dec.BitDepth, nil
 }
 
+// effectiveOptions returns the Client's Options with every non-zero field of
+// opts (which may be nil) applied on top.
+func (c *Client) effectiveOptions(opts *GenerateOptions) Options {
+	o := c.opts
+	if opts == nil {
+		return o
+	}
+	if v := opts.voice(); v != "" {
+		o.Voice = v
+	}
+	if opts.Temperature != 0 {
+		o.Temperature = opts.Temperature
+	}
+	if opts.LSDDecodeSteps != 0 {
+		o.LSDDecodeSteps = opts.LSDDecodeSteps
+	}
+	if opts.NoiseClamp != 0 {
+		o.NoiseClamp = opts.NoiseClamp
+	}
+	if opts.EOSThreshold != 0 {
+		o.EOSThreshold = opts.EOSThreshold
+	}
+	if opts.FramesAfterEOS != 0 {
+		o.FramesAfterEOS = opts.FramesAfterEOS
+	}
+	if opts.MaxTokens != 0 {
+		o.MaxTokens = opts.MaxTokens
+	}
+	return o
+}
+
 // buildArgs constructs the CLI argument slice for `pocket-tts generate`.
+// Non-zero fields in opts (which may be nil) override the Client's Options
+// for this call only; see effectiveOptions.
 //
 // Mapping table:
 //
@@ -109,38 +190,40 @@ func parseWAVHeader(data []byte) (sampleRate uint32, channels uint16, bitsPerSam
 //	Options.Quiet          → --quiet
 //	stdin                  → --text -
 //	stdout                 → --output-path -
-func (c *Client) buildArgs() []string {
+func (c *Client) buildArgs(opts *GenerateOptions) []string {
+	o := c.effectiveOptions(opts)
+
 	args := []string{
 		"generate",
 		"--text", "-",
 		"--output-path", "-",
 	}
 
-	if c.opts.Voice != "" {
-		args = append(args, "--voice", c.opts.Voice)
+	if o.Voice != "" {
+		args = append(args, "--voice", o.Voice)
 	}
-	if c.opts.Config != "" {
-		args = append(args, "--config", c.opts.Config)
+	if o.Config != "" {
+		args = append(args, "--config", o.Config)
 	}
-	if c.opts.Temperature != 0 {
-		args = append(args, "--temperature", formatFloat(c.opts.Temperature))
+	if o.Temperature != 0 {
+		args = append(args, "--temperature", formatFloat(o.Temperature))
 	}
-	if c.opts.LSDDecodeSteps != 0 {
-		args = append(args, "--lsd-decode-steps", formatInt(c.opts.LSDDecodeSteps))
+	if o.LSDDecodeSteps != 0 {
+		args = append(args, "--lsd-decode-steps", formatInt(o.LSDDecodeSteps))
 	}
-	if c.opts.NoiseClamp != 0 {
-		args = append(args, "--noise-clamp", formatFloat(c.opts.NoiseClamp))
+	if o.NoiseClamp != 0 {
+		args = append(args, "--noise-clamp", formatFloat(o.NoiseClamp))
 	}
-	if c.opts.EOSThreshold != 0 {
-		args = append(args, "--eos-threshold", formatFloat(c.opts.EOSThreshold))
+	if o.EOSThreshold != 0 {
+		args = append(args, "--eos-threshold", formatFloat(o.EOSThreshold))
 	}
-	if c.opts.FramesAfterEOS != 0 {
-		args = append(args, "--frames-after-eos", formatInt(c.opts.FramesAfterEOS))
+	if o.FramesAfterEOS != 0 {
+		args = append(args, "--frames-after-eos", formatInt(o.FramesAfterEOS))
 	}
-	if c.opts.MaxTokens != 0 {
-		args = append(args, "--max-tokens", formatInt(c.opts.MaxTokens))
+	if o.MaxTokens != 0 {
+		args = append(args, "--max-tokens", formatInt(o.MaxTokens))
 	}
-	if c.opts.Quiet {
+	if o.Quiet {
 		args = append(args, "--quiet")
 	}
 
@@ -0,0 +1,455 @@
+package pockettts
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+)
+
+// Resampler design. The filter is a Kaiser-windowed sinc evaluated at every
+// phase of the rational rate ratio, so conversion is exact for common pairs
+// of sample rates (e.g. 24000→44100 uses 147 phases). Ratios that reduce to
+// more than resampleMaxPhases phases (e.g. 24000→383999) interpolate
+// linearly between resampleMaxPhases evenly spaced phases instead.
+const (
+	resampleZeroCrossings = 32   // filter half-length, in samples at the lower rate
+	resampleRolloff       = 0.9  // cutoff as a fraction of the lower Nyquist frequency
+	resampleKaiserBeta    = 8.6  // ≈ 85 dB stopband attenuation
+	resampleMaxPhases     = 1024 // bounds the filter table to 1025 rows
+	maxConvertChannels    = 8    // upper bound for GenerateOptions.Channels
+	maxConvertSampleRate  = 384000
+)
+
+// needsConversion reports whether opts (which may be nil) asks for a sample
+// rate, channel count or bit depth.
+func (o *GenerateOptions) needsConversion() bool {
+	return o != nil && (o.SampleRate != 0 || o.Channels != 0 || o.BitsPerSample != 0)
+}
+
+// validateConversion checks the target format fields of opts.
+func (o *GenerateOptions) validateConversion() error {
+	if o == nil {
+		return nil
+	}
+	if o.SampleRate > maxConvertSampleRate {
+		return fmt.Errorf("pockettts: unsupported target sample rate %d Hz", o.SampleRate)
+	}
+	if o.Channels > maxConvertChannels {
+		return fmt.Errorf("pockettts: unsupported target channel count %d", o.Channels)
+	}
+	switch o.BitsPerSample {
+	case 0, 8, 16, 24, 32:
+	default:
+		return fmt.Errorf("pockettts: unsupported target bit depth %d (want 8, 16, 24 or 32)", o.BitsPerSample)
+	}
+	return nil
+}
+
+// targetFormat returns the format src is converted to. Fields left zero in
+// opts keep the source value; the output is always integer PCM, so float
+// sources without an explicit BitsPerSample become 16-bit.
+func (o *GenerateOptions) targetFormat(src *wavData) *wavData {
+	dst := &wavData{
+		sampleRate:    src.sampleRate,
+		channels:      src.channels,
+		bitsPerSample: src.bitsPerSample,
+		formatTag:     wavFormatPCM,
+	}
+	if src.formatTag != wavFormatPCM {
+		dst.bitsPerSample = 16
+	}
+	if o.SampleRate != 0 {
+		dst.sampleRate = o.SampleRate
+	}
+	if o.Channels != 0 {
+		dst.channels = o.Channels
+	}
+	if o.BitsPerSample != 0 {
+		dst.bitsPerSample = o.BitsPerSample
+	}
+	return dst
+}
+
+// sameFormat reports whether a and b describe identical sample layouts.
+func sameFormat(a, b *wavData) bool {
+	return a.sampleRate == b.sampleRate && a.channels == b.channels &&
+		a.bitsPerSample == b.bitsPerSample && a.formatTag == b.formatTag
+}
+
+// convertResult converts res to the sample rate, channel count and bit depth
+// requested in opts (which may be nil). res is returned unchanged when no
+// conversion is needed.
+func convertResult(res *WAVResult, opts *GenerateOptions) (*WAVResult, error) {
+	if !opts.needsConversion() {
+		return res, nil
+	}
+	src, err := decodeWAVData(res.Data)
+	if err != nil {
+		return nil, err
+	}
+	dst := opts.targetFormat(src)
+	if sameFormat(src, dst) {
+		return res, nil
+	}
+
+	samples, err := src.float32Samples()
+	if err != nil {
+		return nil, err
+	}
+	conv := newAudioConverter(src, dst)
+	pcm := conv.process(samples)
+	pcm = append(pcm, conv.flush()...)
+
+	out := *res
+	out.Data = encodeWAV(dst.sampleRate, dst.channels, dst.bitsPerSample, pcm)
+	out.SampleRate = dst.sampleRate
+	out.Channels = dst.channels
+	out.BitsPerSample = dst.bitsPerSample
+	return &out, nil
+}
+
+// audioConverter converts interleaved samples between sample rates, channel
+// layouts and bit depths. It keeps resampler state between calls so a stream
+// can be converted piece by piece with the same result as converting it
+// whole.
+type audioConverter struct {
+	srcChannels, dstChannels int
+	bitsPerSample            uint16
+	rs                       *resampler // nil if the rates match
+}
+
+func newAudioConverter(src, dst *wavData) *audioConverter {
+	c := &audioConverter{
+		srcChannels:   int(src.channels),
+		dstChannels:   int(dst.channels),
+		bitsPerSample: dst.bitsPerSample,
+	}
+	if src.sampleRate != dst.sampleRate {
+		// Resample at the smaller channel count to do the least work.
+		c.rs = newResampler(src.sampleRate, dst.sampleRate, min(c.srcChannels, c.dstChannels))
+	}
+	return c
+}
+
+// process converts whole frames of interleaved samples in [-1, 1] and returns
+// the PCM bytes that are ready. The resampler holds back a few milliseconds of
+// output until it has seen the input that follows; flush releases it.
+func (c *audioConverter) process(in []float32) []byte {
+	x := in
+	if c.dstChannels < c.srcChannels {
+		x = remix(x, c.srcChannels, c.dstChannels)
+	}
+	if c.rs != nil {
+		x = c.rs.process(x)
+	}
+	return c.finish(x)
+}
+
+// flush returns the output still held by the resampler at the end of input.
+func (c *audioConverter) flush() []byte {
+	if c.rs == nil {
+		return nil
+	}
+	return c.finish(c.rs.flush())
+}
+
+func (c *audioConverter) finish(x []float32) []byte {
+	if c.dstChannels > c.srcChannels {
+		x = remix(x, c.srcChannels, c.dstChannels)
+	}
+	return quantize(x, c.bitsPerSample)
+}
+
+// remix converts interleaved frames from one channel count to another.
+// Downmixing to mono averages all channels, upmixing from mono duplicates the
+// channel, and any other layout change maps output channel i to input
+// channel i mod from.
+func remix(x []float32, from, to int) []float32 {
+	frames := len(x) / from
+	out := make([]float32, frames*to)
+	for f := 0; f < frames; f++ {
+		in := x[f*from : (f+1)*from]
+		o := out[f*to : (f+1)*to]
+		if to == 1 {
+			var sum float32
+			for _, v := range in {
+				sum += v
+			}
+			o[0] = sum / float32(from)
+			continue
+		}
+		for i := range o {
+			o[i] = in[i%from]
+		}
+	}
+	return out
+}
+
+// quantize encodes samples in [-1, 1] as little-endian integer PCM, rounding
+// to the nearest step and clipping out-of-range values.
+func quantize(x []float32, bitsPerSample uint16) []byte {
+	bytesPer := int(bitsPerSample) / 8
+	out := make([]byte, len(x)*bytesPer)
+	scale := math.Ldexp(1, int(bitsPerSample)-1)
+	for i, v := range x {
+		s := int64(math.Round(float64(v) * scale))
+		s = max(min(s, int64(scale)-1), -int64(scale))
+		if bitsPerSample == 8 {
+			out[i] = byte(s + 128) // 8-bit WAV is unsigned
+			continue
+		}
+		for b := 0; b < bytesPer; b++ {
+			out[i*bytesPer+b] = byte(s >> (8 * b))
+		}
+	}
+	return out
+}
+
+// resampler is a stateful polyphase sample-rate converter for interleaved
+// float samples.
+//
+// Output frame j lies at input time t = j·down/up. It is the sum of the
+// 2·half input frames around t weighted by the filter phase for the
+// fractional part of t; input before the start and after the end of the
+// stream counts as silence.
+type resampler struct {
+	*resampleFilter
+	channels int
+	phase    []float32 // interpolated taps; used if interp is set
+
+	buf   []float32 // pending input frames; frame 0 has input index base
+	base  int64
+	next  int64 // index of the next output frame
+	total int64 // input frames received
+}
+
+// resampleFilter is the filter table of one rate pair. It is read-only and
+// shared by all resamplers for that pair.
+type resampleFilter struct {
+	up, down int         // output rate / input rate, reduced
+	half     int         // filter taps on each side of t
+	taps     [][]float32 // [phase][2*half]
+	interp   bool        // taps[i] is phase i/resampleMaxPhases, not i/up
+}
+
+// resampleFilters caches the filter of each (from, to) rate pair, since a
+// table takes far longer to compute than a typical stream takes to convert.
+var resampleFilters sync.Map // [2]uint32 → *resampleFilter
+
+func newResampler(from, to uint32, channels int) *resampler {
+	key := [2]uint32{from, to}
+	f, ok := resampleFilters.Load(key)
+	if !ok {
+		f, _ = resampleFilters.LoadOrStore(key, newResampleFilter(from, to))
+	}
+	filter := f.(*resampleFilter)
+	r := &resampler{
+		resampleFilter: filter,
+		channels:       channels,
+		buf:            make([]float32, filter.half*channels), // silence before the start
+		base:           -int64(filter.half),
+	}
+	if filter.interp {
+		r.phase = make([]float32, 2*filter.half)
+	}
+	return r
+}
+
+func newResampleFilter(from, to uint32) *resampleFilter {
+	g := gcd(int(from), int(to))
+	up, down := int(to)/g, int(from)/g
+
+	// The cutoff is relative to the lower of the two Nyquist frequencies.
+	scale := math.Min(1, float64(up)/float64(down))
+	cutoff := resampleRolloff * scale
+	half := int(math.Ceil(resampleZeroCrossings / scale))
+
+	// phases is the number of distinct fractional positions in the table;
+	// an interpolated table also holds phase 1, to interpolate towards.
+	phases, rows := up, up
+	interp := up > resampleMaxPhases
+	if interp {
+		phases, rows = resampleMaxPhases, resampleMaxPhases+1
+	}
+
+	taps := make([][]float32, rows)
+	i0Beta := besselI0(resampleKaiserBeta)
+	for p := range taps {
+		frac := float64(p) / float64(phases)
+		row := make([]float64, 2*half)
+		var sum float64
+		for k := range row {
+			d := frac + float64(half-1-k) // t - n
+			r := d / float64(half)
+			if r <= -1 || r >= 1 {
+				continue
+			}
+			w := besselI0(resampleKaiserBeta*math.Sqrt(1-r*r)) / i0Beta
+			row[k] = cutoff * sinc(cutoff*d) * w
+			sum += row[k]
+		}
+		// Normalise each phase to unity gain at DC.
+		taps[p] = make([]float32, 2*half)
+		for k, v := range row {
+			taps[p][k] = float32(v / sum)
+		}
+	}
+
+	return &resampleFilter{up: up, down: down, half: half, taps: taps, interp: interp}
+}
+
+// tapsAt returns the filter for fractional position p/up.
+func (r *resampler) tapsAt(p int64) []float32 {
+	if !r.interp {
+		return r.taps[p]
+	}
+	x := float64(p) * resampleMaxPhases / float64(r.up)
+	i := int(x)
+	t := float32(x - float64(i))
+	a, b := r.taps[i], r.taps[i+1]
+	for k := range r.phase {
+		r.phase[k] = a[k] + t*(b[k]-a[k])
+	}
+	return r.phase
+}
+
+// process appends input frames and returns every output frame whose filter
+// window is now complete.
+func (r *resampler) process(in []float32) []float32 {
+	r.buf = append(r.buf, in...)
+	r.total += int64(len(in) / r.channels)
+	return r.drain(r.base + int64(len(r.buf)/r.channels))
+}
+
+// flush pads the input with silence and returns the remaining output frames,
+// so that the output has ceil(total·up/down) frames in all.
+func (r *resampler) flush() []float32 {
+	r.buf = append(r.buf, make([]float32, r.half*r.channels)...)
+	end := (r.total*int64(r.up) + int64(r.down) - 1) / int64(r.down)
+	return r.drainTo(r.base+int64(len(r.buf)/r.channels), end)
+}
+
+func (r *resampler) drain(avail int64) []float32 {
+	return r.drainTo(avail, math.MaxInt64)
+}
+
+// drainTo produces output frames up to (excluding) frame end while the input
+// frames they need are below avail, then discards input no longer needed.
+func (r *resampler) drainTo(avail, end int64) []float32 {
+	var out []float32
+	ch := r.channels
+	for ; r.next < end; r.next++ {
+		pos := r.next * int64(r.down)
+		fl := pos / int64(r.up)
+		if fl+int64(r.half) >= avail {
+			break
+		}
+		taps := r.tapsAt(pos % int64(r.up))
+		start := int(fl-int64(r.half)+1-r.base) * ch
+		for c := 0; c < ch; c++ {
+			var acc float32
+			for k, w := range taps {
+				acc += w * r.buf[start+k*ch+c]
+			}
+			out = append(out, acc)
+		}
+	}
+
+	// Keep the frames from the first one the next output will need.
+	keep := (r.next*int64(r.down))/int64(r.up) - int64(r.half) + 1
+	if drop := keep - r.base; drop > 0 {
+		drop = min(drop, int64(len(r.buf)/ch))
+		r.buf = append(r.buf[:0], r.buf[int(drop)*ch:]...)
+		r.base += drop
+	}
+	return out
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+// besselI0 is the zeroth-order modified Bessel function of the first kind,
+// evaluated by its power series.
+func besselI0(x float64) float64 {
+	sum, term := 1.0, 1.0
+	for k := 1; term > 1e-12*sum; k++ {
+		f := x / (2 * float64(k))
+		term *= f * f
+		sum += term
+	}
+	return sum
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// convertReader converts a stream of PCM frames in format src with conv.
+type convertReader struct {
+	r    io.Reader
+	src  *wavData
+	conv *audioConverter
+
+	buf     []byte // raw input, possibly ending in a partial frame
+	out     []byte // converted bytes not yet returned
+	readErr error  // sticky error from r, returned once out is drained
+}
+
+func newConvertReader(r io.Reader, src, dst *wavData) *convertReader {
+	return &convertReader{r: r, src: src, conv: newAudioConverter(src, dst)}
+}
+
+func (c *convertReader) Read(p []byte) (int, error) {
+	for len(c.out) == 0 {
+		if c.readErr != nil {
+			return 0, c.readErr
+		}
+		c.fill()
+	}
+	n := copy(p, c.out)
+	c.out = c.out[n:]
+	return n, nil
+}
+
+// fill reads the next piece of input and converts its whole frames.
+func (c *convertReader) fill() {
+	var chunk [32 * 1024]byte
+	n, err := c.r.Read(chunk[:])
+	c.buf = append(c.buf, chunk[:n]...)
+
+	ba := c.src.blockAlign()
+	whole := len(c.buf) / ba * ba
+	if whole > 0 {
+		w := *c.src
+		w.pcm = c.buf[:whole]
+		samples, ferr := w.float32Samples()
+		if ferr != nil {
+			c.readErr = ferr
+			return
+		}
+		c.out = append(c.out, c.conv.process(samples)...)
+		c.buf = append(c.buf[:0], c.buf[whole:]...)
+	}
+
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			// Like decodeWAVData, tolerate the RIFF pad byte of odd-sized 8-bit data.
+			if len(c.buf) > 0 && !(c.src.bitsPerSample == 8 && len(c.buf) == 1) {
+				c.readErr = fmt.Errorf("%w: data ends in %d bytes of a %d-byte frame",
+					ErrMalformedWAV, len(c.buf), ba)
+				return
+			}
+			c.out = append(c.out, c.conv.flush()...)
+		}
+		c.readErr = err
+	}
+}
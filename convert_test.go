@@ -0,0 +1,260 @@
+package pockettts
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// sine returns n samples of a sine wave of frequency f at sampleRate.
+func sine(n int, f, amp float64, sampleRate uint32) []float32 {
+	out := make([]float32, n)
+	for i := range out {
+		out[i] = float32(amp * math.Sin(2*math.Pi*f*float64(i)/float64(sampleRate)))
+	}
+	return out
+}
+
+func resampleAll(from, to uint32, in []float32) []float32 {
+	r := newResampler(from, to, 1)
+	return append(r.process(in), r.flush()...)
+}
+
+// ---------------------------------------------------------------------------
+// Resampler
+// ---------------------------------------------------------------------------
+
+func TestResampler_Sine(t *testing.T) {
+	const n = 12000 // 0.5 s at 24 kHz
+	in := sine(n, 1000, 0.5, 24000)
+	for _, to := range []uint32{8000, 16000, 44100, 48000} {
+		out := resampleAll(24000, to, in)
+		if want := (n*int(to) + 23999) / 24000; len(out) != want {
+			t.Errorf("%d Hz: got %d frames, want %d", to, len(out), want)
+		}
+		ref := sine(len(out), 1000, 0.5, to)
+		// Skip the edges, where the filter sees the silence around the input.
+		edge := int(to) / 50
+		var maxErr float64
+		for i := edge; i < len(out)-edge; i++ {
+			maxErr = math.Max(maxErr, math.Abs(float64(out[i]-ref[i])))
+		}
+		if maxErr > 1e-3 {
+			t.Errorf("%d Hz: max error %.5f against ideal sine", to, maxErr)
+		}
+	}
+}
+
+func TestResampler_RejectsAliases(t *testing.T) {
+	// 10 kHz is above the 4 kHz Nyquist frequency of 8 kHz output; naive
+	// decimation would fold it down to 2 kHz at full amplitude.
+	out := resampleAll(24000, 8000, sine(24000, 10000, 0.5, 24000))
+	var sum float64
+	for _, v := range out[400 : len(out)-400] {
+		sum += float64(v) * float64(v)
+	}
+	if rms := math.Sqrt(sum / float64(len(out)-800)); rms > 1e-3 {
+		t.Errorf("aliased energy too high: RMS %.5f", rms)
+	}
+}
+
+func TestResampler_ChunkedMatchesWhole(t *testing.T) {
+	in := sine(5000, 440, 0.8, 24000)
+	for i := range in {
+		in[i] += float32(i%7) * 0.01
+	}
+	want := resampleAll(24000, 44100, in)
+
+	r := newResampler(24000, 44100, 1)
+	var got []float32
+	for pos, size := 0, 1; pos < len(in); size = size*3%977 + 1 {
+		end := min(pos+size, len(in))
+		got = append(got, r.process(in[pos:end])...)
+		pos = end
+	}
+	got = append(got, r.flush()...)
+
+	if len(got) != len(want) {
+		t.Fatalf("chunked: %d frames, whole: %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("frame %d differs: %v vs %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestResampler_CoprimeRate(t *testing.T) {
+	// 24000 and 383999 share no factor: an exact table would need 383999
+	// phases, so the filter interpolates between resampleMaxPhases instead.
+	const n, to = 2400, 383999
+	r := newResampler(24000, to, 1)
+	if !r.interp || len(r.taps) != resampleMaxPhases+1 {
+		t.Fatalf("filter has %d phases (interp %v), want %d interpolated", len(r.taps), r.interp, resampleMaxPhases+1)
+	}
+	out := append(r.process(sine(n, 1000, 0.5, 24000)), r.flush()...)
+	if want := (n*to + 23999) / 24000; len(out) != want {
+		t.Errorf("got %d frames, want %d", len(out), want)
+	}
+	ref := sine(len(out), 1000, 0.5, to)
+	edge := to / 50
+	var maxErr float64
+	for i := edge; i < len(out)-edge; i++ {
+		maxErr = math.Max(maxErr, math.Abs(float64(out[i]-ref[i])))
+	}
+	if maxErr > 1e-3 {
+		t.Errorf("max error %.5f against ideal sine", maxErr)
+	}
+}
+
+func TestResampler_SharesFilter(t *testing.T) {
+	a, b := newResampler(24000, 44100, 1), newResampler(24000, 44100, 2)
+	if a.resampleFilter != b.resampleFilter {
+		t.Error("resamplers for the same rates built separate filter tables")
+	}
+	if c := newResampler(44100, 24000, 1); c.resampleFilter == a.resampleFilter {
+		t.Error("resamplers for different rates share a filter table")
+	}
+}
+
+// ---------------------------------------------------------------------------
+// convertResult
+// ---------------------------------------------------------------------------
+
+func TestConvertResult_RateChannelsDepth(t *testing.T) {
+	samples := make([]int16, 2400)
+	for i := range samples {
+		samples[i] = int16(8000 * math.Sin(float64(i)/10))
+	}
+	res := &WAVResult{Data: encodeWAV(24000, 1, 16, int16PCM(samples...)), SampleRate: 24000, Channels: 1, BitsPerSample: 16}
+
+	out, err := convertResult(res, &GenerateOptions{SampleRate: 48000, Channels: 2, BitsPerSample: 24})
+	if err != nil {
+		t.Fatalf("convertResult: %v", err)
+	}
+	if out.SampleRate != 48000 || out.Channels != 2 || out.BitsPerSample != 24 {
+		t.Fatalf("format: %d Hz %d ch %d-bit", out.SampleRate, out.Channels, out.BitsPerSample)
+	}
+	sr, ch, bps, err := parseWAVHeader(out.Data)
+	if err != nil || sr != 48000 || ch != 2 || bps != 24 {
+		t.Fatalf("header: %d %d %d %v", sr, ch, bps, err)
+	}
+	frames, err := out.Frames()
+	if err != nil || frames != 4800 {
+		t.Errorf("Frames: got %d, %v; want 4800", frames, err)
+	}
+	f, _ := out.Float32Samples()
+	for i := 0; i < len(f); i += 2 {
+		if f[i] != f[i+1] {
+			t.Fatalf("frame %d: channels differ after mono upmix", i/2)
+		}
+	}
+}
+
+func TestConvertResult_DownmixAndQuantize(t *testing.T) {
+	res := &WAVResult{Data: encodeWAV(16000, 2, 16, int16PCM(16384, 0, -32768, -32768, 32767, 32767))}
+	out, err := convertResult(res, &GenerateOptions{Channels: 1, BitsPerSample: 8})
+	if err != nil {
+		t.Fatalf("convertResult: %v", err)
+	}
+	pcm, _ := out.PCM()
+	if want := []byte{128 + 32, 0, 255}; !bytes.Equal(pcm, want) {
+		t.Errorf("got %v, want %v", pcm, want)
+	}
+	if out.SampleRate != 16000 || out.Channels != 1 || out.BitsPerSample != 8 {
+		t.Errorf("format: %+v", out)
+	}
+}
+
+func TestConvertResult_NoOp(t *testing.T) {
+	res := &WAVResult{Data: encodeWAV(24000, 1, 16, int16PCM(1, 2, 3))}
+	for _, opts := range []*GenerateOptions{nil, {}, {SampleRate: 24000, Channels: 1, BitsPerSample: 16}} {
+		out, err := convertResult(res, opts)
+		if err != nil || out != res {
+			t.Errorf("opts %+v: expected unchanged result, got %v", opts, err)
+		}
+	}
+}
+
+func TestConvert_InvalidTarget(t *testing.T) {
+	exe := fakeExecutable(t, "echo should-not-run >&2; exit 1")
+	c := NewClient(Options{ExecutablePath: exe})
+	_, err := c.Synthesize(context.Background(), "Hello", &GenerateOptions{BitsPerSample: 12})
+	if err == nil || !strings.Contains(err.Error(), "bit depth 12") {
+		t.Errorf("expected bit depth error, got %v", err)
+	}
+	_, err = c.GenerateStream(context.Background(), "Hello", &GenerateOptions{Channels: 99})
+	if err == nil || !strings.Contains(err.Error(), "channel count 99") {
+		t.Errorf("expected channel count error, got %v", err)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Backends
+// ---------------------------------------------------------------------------
+
+func TestConvert_TelephonyPipeline(t *testing.T) {
+	// 8 kHz μ-law, as sent to a SIP trunk: resampling happens before encoding.
+	fs := newFakeServer(http.StatusOK, http.StatusOK, encodeWAV(24000, 1, 16, make([]byte, 2*2400)))
+	defer fs.ts.Close()
+
+	res, err := serverClientFor(fs.ts).Synthesize(context.Background(), "Hello",
+		&GenerateOptions{SampleRate: 8000, Format: FormatMuLaw})
+	if err != nil {
+		t.Fatalf("Synthesize: %v", err)
+	}
+	if len(res.Data) != 800 || res.SampleRate != 8000 {
+		t.Errorf("got %d bytes at %d Hz, want 800 at 8000 Hz", len(res.Data), res.SampleRate)
+	}
+	if res.MIMEType() != "audio/PCMU;rate=8000;channels=1" {
+		t.Errorf("MIMEType: %q", res.MIMEType())
+	}
+}
+
+func TestClient_GenerateStreamConverted(t *testing.T) {
+	samples := make([]int16, 30000) // several reads' worth
+	for i := range samples {
+		samples[i] = int16(10000 * math.Sin(float64(i)/7))
+	}
+	wav := encodeWAV(24000, 1, 16, int16PCM(samples...))
+	exe := fakeExecutable(t, "cat >/dev/null; cat "+writeTempFile(t, "out.wav", wav))
+	opts := &GenerateOptions{SampleRate: 16000, Channels: 2}
+
+	c := NewClient(Options{ExecutablePath: exe})
+	s, err := c.GenerateStream(context.Background(), "Hello", opts)
+	if err != nil {
+		t.Fatalf("GenerateStream: %v", err)
+	}
+	defer s.Close()
+	if s.SampleRate != 16000 || s.Channels != 2 || s.BitsPerSample != 16 {
+		t.Errorf("stream format: %d Hz %d ch %d-bit", s.SampleRate, s.Channels, s.BitsPerSample)
+	}
+	got, err := io.ReadAll(s)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+
+	whole, err := convertResult(&WAVResult{Data: wav}, opts)
+	if err != nil {
+		t.Fatalf("convertResult: %v", err)
+	}
+	want, _ := whole.PCM()
+	if !bytes.Equal(got, want) {
+		t.Errorf("streamed conversion (%d bytes) differs from whole-file conversion (%d bytes)", len(got), len(want))
+	}
+}
+
+func TestConvertReader_PartialFrameAtEOF(t *testing.T) {
+	src := &wavData{sampleRate: 24000, channels: 2, bitsPerSample: 16, formatTag: 1}
+	dst := &wavData{sampleRate: 24000, channels: 1, bitsPerSample: 16, formatTag: 1}
+	// One whole stereo frame, then half of the next.
+	r := newConvertReader(bytes.NewReader(int16PCM(100, 200, 300)), src, dst)
+	if _, err := io.ReadAll(r); !errors.Is(err, ErrMalformedWAV) {
+		t.Errorf("err = %v, want ErrMalformedWAV", err)
+	}
+}
@@ -0,0 +1,279 @@
+package pockettts
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// AudioFormat selects the encoding of generated audio. pocket-tts always
+// produces WAV; other formats are encoded in Go after generation.
+type AudioFormat string
+
+const (
+	// FormatWAV is the RIFF/WAV file produced by pocket-tts (the default).
+	FormatWAV AudioFormat = "wav"
+
+	// FormatPCM is headerless little-endian signed 16-bit PCM (s16le).
+	FormatPCM AudioFormat = "pcm"
+
+	// FormatMuLaw is headerless G.711 μ-law, one byte per sample.
+	FormatMuLaw AudioFormat = "mulaw"
+
+	// FormatALaw is headerless G.711 A-law, one byte per sample.
+	FormatALaw AudioFormat = "alaw"
+
+	// FormatFLAC is a native FLAC stream (lossless, pure-Go encoder).
+	FormatFLAC AudioFormat = "flac"
+
+	// FormatOpus is Opus in an Ogg container. The default encoder runs ffmpeg.
+	FormatOpus AudioFormat = "opus"
+
+	// FormatMP3 is MPEG-1 Layer III. The default encoder runs ffmpeg.
+	FormatMP3 AudioFormat = "mp3"
+)
+
+// MIMEType returns the media type for audio in format f.
+func (f AudioFormat) MIMEType() string {
+	switch f {
+	case "", FormatWAV:
+		return "audio/wav"
+	case FormatPCM:
+		return "audio/pcm"
+	case FormatMuLaw:
+		return "audio/PCMU"
+	case FormatALaw:
+		return "audio/PCMA"
+	case FormatFLAC:
+		return "audio/flac"
+	case FormatOpus:
+		return "audio/ogg; codecs=opus"
+	case FormatMP3:
+		return "audio/mpeg"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// Encoder converts generated WAV audio into another format. Implementations
+// must be safe for concurrent use.
+type Encoder interface {
+	Encode(ctx context.Context, audio *WAVResult) ([]byte, error)
+}
+
+// EncoderFunc adapts an ordinary function to the Encoder interface.
+type EncoderFunc func(ctx context.Context, audio *WAVResult) ([]byte, error)
+
+// Encode calls f(ctx, audio).
+func (f EncoderFunc) Encode(ctx context.Context, audio *WAVResult) ([]byte, error) {
+	return f(ctx, audio)
+}
+
+// FFmpegEncoder encodes audio by piping the WAV through ffmpeg. It is the
+// default encoder for FormatOpus and FormatMP3, which have no pure-Go encoder.
+type FFmpegEncoder struct {
+	// Format is the target format.
+	Format AudioFormat
+
+	// ExecutablePath overrides the default "ffmpeg" binary name/path.
+	ExecutablePath string
+
+	// Args are extra output options inserted before the output format, e.g.
+	// []string{"-b:a", "32k"}.
+	Args []string
+
+	// LogWriter receives stderr output from ffmpeg.
+	// If nil, stderr is discarded.
+	LogWriter io.Writer
+}
+
+// Encode implements Encoder.
+func (e *FFmpegEncoder) Encode(ctx context.Context, audio *WAVResult) ([]byte, error) {
+	var codec []string
+	switch e.Format {
+	case FormatOpus:
+		codec = []string{"-c:a", "libopus", "-f", "ogg"}
+	case FormatMP3:
+		codec = []string{"-c:a", "libmp3lame", "-f", "mp3"}
+	case FormatFLAC:
+		codec = []string{"-c:a", "flac", "-f", "flac"}
+	default:
+		return nil, fmt.Errorf("pockettts: ffmpeg encoder does not support format %q", e.Format)
+	}
+
+	exe := e.ExecutablePath
+	if exe == "" {
+		exe = "ffmpeg"
+	}
+	args := []string{"-hide_banner", "-loglevel", "error", "-f", "wav", "-i", "pipe:0"}
+	args = append(args, e.Args...)
+	args = append(args, codec...)
+	args = append(args, "pipe:1")
+
+	r := &runner{executablePath: exe, logWriter: e.LogWriter}
+	res, err := r.run(ctx, args, audio.Data)
+	if err != nil {
+		return nil, fmt.Errorf("pockettts: ffmpeg %s encode: %w", e.Format, err)
+	}
+	return res.stdout, nil
+}
+
+// encoderFor returns the encoder used for format when the caller did not
+// supply one, or nil for FormatWAV (no encoding needed).
+func encoderFor(format AudioFormat) (Encoder, error) {
+	switch format {
+	case "", FormatWAV:
+		return nil, nil
+	case FormatPCM:
+		return EncoderFunc(encodePCM), nil
+	case FormatMuLaw:
+		return EncoderFunc(encodeMuLaw), nil
+	case FormatALaw:
+		return EncoderFunc(encodeALaw), nil
+	case FormatFLAC:
+		return EncoderFunc(encodeFLAC), nil
+	case FormatOpus, FormatMP3:
+		return &FFmpegEncoder{Format: format}, nil
+	default:
+		return nil, fmt.Errorf("pockettts: unknown output format %q", format)
+	}
+}
+
+// encoder returns the encoder selected by opts, or nil if the result should
+// stay WAV. It fails for an unknown Format so callers can reject the request
+// before generating audio.
+func (o *GenerateOptions) encoder() (Encoder, error) {
+	if o == nil {
+		return nil, nil
+	}
+	if o.Encoder != nil {
+		return o.Encoder, nil
+	}
+	return encoderFor(o.Format)
+}
+
+// finishResult applies the output options of opts (which may be nil) to a
+// freshly generated WAV: sample format conversion first, then encoding.
+func finishResult(ctx context.Context, res *WAVResult, opts *GenerateOptions) (*WAVResult, error) {
+	res, err := convertResult(res, opts)
+	if err != nil {
+		return nil, err
+	}
+	return encodeResult(ctx, res, opts)
+}
+
+// encodeResult converts res to the output format requested in opts (which may
+// be nil). WAV results are returned unchanged. The result's SampleRate,
+// Channels and BitsPerSample keep describing the audio that was encoded.
+func encodeResult(ctx context.Context, res *WAVResult, opts *GenerateOptions) (*WAVResult, error) {
+	enc, err := opts.encoder()
+	if err != nil || enc == nil {
+		return res, err
+	}
+
+	data, err := enc.Encode(ctx, res)
+	if err != nil {
+		return nil, err
+	}
+	out := *res
+	out.Data = data
+	out.Format = opts.Format
+	return &out, nil
+}
+
+// encodePCM returns the audio as headerless s16le PCM.
+func encodePCM(_ context.Context, audio *WAVResult) ([]byte, error) {
+	samples, err := audio.Int16Samples()
+	if err != nil {
+		return nil, err
+	}
+	return int16ToBytes(samples), nil
+}
+
+// encodeMuLaw returns the audio as headerless G.711 μ-law.
+func encodeMuLaw(_ context.Context, audio *WAVResult) ([]byte, error) {
+	samples, err := audio.Int16Samples()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(samples))
+	for i, s := range samples {
+		out[i] = linearToMuLaw(s)
+	}
+	return out, nil
+}
+
+// encodeALaw returns the audio as headerless G.711 A-law.
+func encodeALaw(_ context.Context, audio *WAVResult) ([]byte, error) {
+	samples, err := audio.Int16Samples()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(samples))
+	for i, s := range samples {
+		out[i] = linearToALaw(s)
+	}
+	return out, nil
+}
+
+// int16ToBytes encodes samples as little-endian 16-bit PCM.
+func int16ToBytes(samples []int16) []byte {
+	out := make([]byte, 2*len(samples))
+	for i, s := range samples {
+		binary.LittleEndian.PutUint16(out[2*i:], uint16(s))
+	}
+	return out
+}
+
+// linearToMuLaw converts a 16-bit sample to G.711 μ-law (ITU-T G.711, with
+// the usual bias of 0x84 and clipping at 32635).
+func linearToMuLaw(s int16) byte {
+	const (
+		bias = 0x84
+		clip = 32635
+	)
+	v := int(s)
+	sign := 0
+	if v < 0 {
+		v = -v
+		sign = 0x80
+	}
+	if v > clip {
+		v = clip
+	}
+	v += bias
+
+	exp := 7
+	for mask := 0x4000; v&mask == 0 && exp > 0; mask >>= 1 {
+		exp--
+	}
+	mantissa := (v >> (exp + 3)) & 0x0F
+	return ^byte(sign | exp<<4 | mantissa)
+}
+
+// linearToALaw converts a 16-bit sample to G.711 A-law.
+func linearToALaw(s int16) byte {
+	v := int(s) >> 3 // A-law operates on 13-bit magnitudes
+	sign := 0x80
+	if v < 0 {
+		v = -v - 1
+		sign = 0
+	}
+
+	var out int
+	if v < 32 {
+		out = v >> 1
+	} else {
+		exp := 1
+		for t := v >> 5; t > 1 && exp < 7; t >>= 1 {
+			exp++
+		}
+		if v >= 4096 {
+			out = 0x7F // clip
+		} else {
+			out = exp<<4 | (v>>exp)&0x0F
+		}
+	}
+	return byte(out|sign) ^ 0x55
+}
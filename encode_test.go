@@ -0,0 +1,547 @@
+package pockettts
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"net/http"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// ---------------------------------------------------------------------------
+// Headerless formats
+// ---------------------------------------------------------------------------
+
+func TestEncode_PCM(t *testing.T) {
+	pcm := int16PCM(0, 1, -1, 32767, -32768)
+	res, err := encodeResult(context.Background(),
+		&WAVResult{Data: encodeWAV(24000, 1, 16, pcm), SampleRate: 24000, Channels: 1, BitsPerSample: 16},
+		&GenerateOptions{Format: FormatPCM})
+	if err != nil {
+		t.Fatalf("encodeResult: %v", err)
+	}
+	if !bytes.Equal(res.Data, pcm) {
+		t.Errorf("PCM: got %v, want %v", res.Data, pcm)
+	}
+	if got := res.MIMEType(); got != "audio/pcm;rate=24000;channels=1" {
+		t.Errorf("MIMEType: got %q", got)
+	}
+}
+
+func TestEncode_G711(t *testing.T) {
+	cases := []struct {
+		in          int16
+		mulaw, alaw byte
+	}{
+		{0, 0xFF, 0xD5},
+		{-1, 0x7F, 0x55},
+		{math.MaxInt16, 0x80, 0xAA},
+		{math.MinInt16, 0x00, 0x2A},
+		{1000, 0xCE, 0xFA},
+		{-1000, 0x4E, 0x7A},
+	}
+	for _, tc := range cases {
+		if got := linearToMuLaw(tc.in); got != tc.mulaw {
+			t.Errorf("μ-law(%d): got %#02x, want %#02x", tc.in, got, tc.mulaw)
+		}
+		if got := linearToALaw(tc.in); got != tc.alaw {
+			t.Errorf("A-law(%d): got %#02x, want %#02x", tc.in, got, tc.alaw)
+		}
+	}
+
+	wav := &WAVResult{Data: encodeWAV(8000, 1, 16, int16PCM(0, 1000, -1000)), SampleRate: 8000, Channels: 1}
+	res, err := encodeResult(context.Background(), wav, &GenerateOptions{Format: FormatMuLaw})
+	if err != nil {
+		t.Fatalf("encodeResult: %v", err)
+	}
+	if !bytes.Equal(res.Data, []byte{0xFF, 0xCE, 0x4E}) {
+		t.Errorf("μ-law data: got %x", res.Data)
+	}
+	if got := res.MIMEType(); got != "audio/PCMU;rate=8000;channels=1" {
+		t.Errorf("MIMEType: got %q", got)
+	}
+}
+
+func TestEncode_WAVUnchanged(t *testing.T) {
+	wav := &WAVResult{Data: encodeWAV(24000, 1, 16, int16PCM(1, 2))}
+	for _, opts := range []*GenerateOptions{nil, {}, {Format: FormatWAV}} {
+		res, err := encodeResult(context.Background(), wav, opts)
+		if err != nil || res != wav {
+			t.Errorf("opts %+v: expected unchanged result, got %v, %v", opts, res, err)
+		}
+	}
+	if got := wav.MIMEType(); got != "audio/wav" {
+		t.Errorf("MIMEType: got %q", got)
+	}
+}
+
+func TestEncode_UnknownFormat(t *testing.T) {
+	exe := fakeExecutable(t, "echo should-not-run >&2; exit 1")
+	c := NewClient(Options{ExecutablePath: exe})
+	_, err := c.Synthesize(context.Background(), "Hello", &GenerateOptions{Format: "aiff"})
+	if err == nil || !strings.Contains(err.Error(), `unknown output format "aiff"`) {
+		t.Errorf("expected unknown format error, got %v", err)
+	}
+}
+
+func TestEncode_CustomEncoder(t *testing.T) {
+	var seen int
+	enc := EncoderFunc(func(_ context.Context, audio *WAVResult) ([]byte, error) {
+		seen = len(audio.Data)
+		return []byte("ID3fake"), nil
+	})
+	wav := &WAVResult{Data: encodeWAV(24000, 1, 16, int16PCM(1, 2))}
+	res, err := encodeResult(context.Background(), wav, &GenerateOptions{Format: FormatMP3, Encoder: enc})
+	if err != nil {
+		t.Fatalf("encodeResult: %v", err)
+	}
+	if seen != len(wav.Data) || string(res.Data) != "ID3fake" {
+		t.Errorf("custom encoder not used: seen=%d data=%q", seen, res.Data)
+	}
+	if res.MIMEType() != "audio/mpeg" || res.Format != FormatMP3 {
+		t.Errorf("format: %q %q", res.Format, res.MIMEType())
+	}
+
+	failing := EncoderFunc(func(context.Context, *WAVResult) ([]byte, error) {
+		return nil, errors.New("codec exploded")
+	})
+	if _, err := encodeResult(context.Background(), wav, &GenerateOptions{Encoder: failing}); err == nil {
+		t.Error("expected encoder error")
+	}
+}
+
+func TestAudioFormat_MIMEType(t *testing.T) {
+	want := map[AudioFormat]string{
+		"":          "audio/wav",
+		FormatWAV:   "audio/wav",
+		FormatFLAC:  "audio/flac",
+		FormatOpus:  "audio/ogg; codecs=opus",
+		FormatMP3:   "audio/mpeg",
+		FormatALaw:  "audio/PCMA",
+		FormatMuLaw: "audio/PCMU",
+	}
+	for f, mime := range want {
+		if got := f.MIMEType(); got != mime {
+			t.Errorf("%q: got %q, want %q", f, got, mime)
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+// ffmpeg encoder
+// ---------------------------------------------------------------------------
+
+func TestFFmpegEncoder_Args(t *testing.T) {
+	// The fake ffmpeg echoes its arguments, then the byte count of stdin.
+	exe := fakeExecutable(t, `echo "$@"; wc -c | tr -d ' '`)
+	wav := &WAVResult{Data: encodeWAV(24000, 1, 16, int16PCM(1, 2, 3))}
+
+	enc := &FFmpegEncoder{Format: FormatOpus, ExecutablePath: exe, Args: []string{"-b:a", "24k"}}
+	out, err := enc.Encode(context.Background(), wav)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	want := fmt.Sprintf("-hide_banner -loglevel error -f wav -i pipe:0 -b:a 24k -c:a libopus -f ogg pipe:1\n%d\n", len(wav.Data))
+	if string(out) != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+}
+
+func TestFFmpegEncoder_Failure(t *testing.T) {
+	exe := fakeExecutable(t, "cat >/dev/null; echo 'Unknown encoder libmp3lame' >&2; exit 1")
+	wav := &WAVResult{Data: encodeWAV(24000, 1, 16, int16PCM(1))}
+	_, err := (&FFmpegEncoder{Format: FormatMP3, ExecutablePath: exe}).Encode(context.Background(), wav)
+	var exitErr *ErrNonZeroExit
+	if !errors.As(err, &exitErr) || !strings.Contains(exitErr.Stderr, "libmp3lame") {
+		t.Errorf("expected ErrNonZeroExit with stderr, got %v", err)
+	}
+
+	_, err = (&FFmpegEncoder{Format: FormatMP3, ExecutablePath: "/nonexistent/ffmpeg"}).Encode(context.Background(), wav)
+	var nf *ErrExecutableNotFound
+	if !errors.As(err, &nf) {
+		t.Errorf("expected ErrExecutableNotFound, got %v", err)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// End-to-end through the backends
+// ---------------------------------------------------------------------------
+
+func TestClient_SynthesizeFLAC(t *testing.T) {
+	wavPath := writeTempFile(t, "out.wav", encodeWAV(24000, 1, 16, int16PCM(5, 6, 7, 8)))
+	exe := fakeExecutable(t, "cat >/dev/null; cat "+wavPath)
+
+	c := NewClient(Options{ExecutablePath: exe})
+	res, err := c.Synthesize(context.Background(), "Hello", &GenerateOptions{Format: FormatFLAC})
+	if err != nil {
+		t.Fatalf("Synthesize: %v", err)
+	}
+	if !bytes.HasPrefix(res.Data, []byte("fLaC")) {
+		t.Fatalf("expected FLAC stream, got %q", res.Data[:4])
+	}
+	if res.SampleRate != 24000 || res.Channels != 1 || res.MIMEType() != "audio/flac" {
+		t.Errorf("unexpected metadata: %+v %q", res, res.MIMEType())
+	}
+}
+
+func TestServerClient_SynthesizeALaw(t *testing.T) {
+	fs := newFakeServer(http.StatusOK, http.StatusOK, encodeWAV(24000, 1, 16, int16PCM(0, -1)))
+	defer fs.ts.Close()
+
+	res, err := serverClientFor(fs.ts).Synthesize(context.Background(), "Hello", &GenerateOptions{Format: FormatALaw})
+	if err != nil {
+		t.Fatalf("Synthesize: %v", err)
+	}
+	if !bytes.Equal(res.Data, []byte{0xD5, 0x55}) {
+		t.Errorf("A-law data: got %x", res.Data)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// FLAC round trip
+// ---------------------------------------------------------------------------
+
+func TestEncodeFLAC_RoundTrip(t *testing.T) {
+	// A decaying tone with noise, long enough for several frames and a short
+	// final block, plus a silent stretch that should become CONSTANT subframes.
+	const n = 3*flacBlockSize + 123
+	samples := make([]int16, 2*n)
+	seed := uint32(1)
+	for i := 0; i < n; i++ {
+		seed = seed*1664525 + 1013904223
+		noise := float64(int32(seed>>16)%200) - 100
+		v := 12000*math.Sin(float64(i)*0.07)*math.Exp(-float64(i)/8000) + noise
+		if i >= flacBlockSize && i < 2*flacBlockSize {
+			v = 0
+		}
+		samples[2*i] = int16(v)
+		samples[2*i+1] = int16(-v / 2)
+	}
+	samples[1] = math.MinInt16 // extreme values must survive
+	samples[3] = math.MaxInt16
+
+	wav := encodeWAV(24000, 2, 16, int16PCM(samples...))
+	out, err := encodeFLAC(context.Background(), &WAVResult{Data: wav})
+	if err != nil {
+		t.Fatalf("encodeFLAC: %v", err)
+	}
+	if len(out) >= len(wav) {
+		t.Errorf("FLAC (%d bytes) is not smaller than WAV (%d bytes)", len(out), len(wav))
+	}
+
+	got, info := decodeFLACForTest(t, out)
+	if info.sampleRate != 24000 || info.channels != 2 || info.bps != 16 || info.total != n {
+		t.Fatalf("STREAMINFO: %+v", info)
+	}
+	if len(got) != len(samples) {
+		t.Fatalf("decoded %d samples, want %d", len(got), len(samples))
+	}
+	for i := range samples {
+		if got[i] != int32(samples[i]) {
+			t.Fatalf("sample %d: got %d, want %d", i, got[i], samples[i])
+		}
+	}
+	if sum := flacMD5(got, 16); sum != info.md5 {
+		t.Error("STREAMINFO MD5 does not match decoded samples")
+	}
+}
+
+func TestEncodeFLAC_BitDepths(t *testing.T) {
+	pcm8 := []byte{0, 128, 255, 127, 129, 130}
+	pcm24 := []byte{0xFF, 0xFF, 0x7F, 0x00, 0x00, 0x80, 0x01, 0x00, 0x00, 0x10, 0x20, 0x30}
+	cases := []struct {
+		name string
+		bps  uint16
+		pcm  []byte
+		want []int32
+	}{
+		{"8-bit", 8, pcm8, []int32{-128, 0, 127, -1, 1, 2}},
+		{"24-bit", 24, pcm24, []int32{8388607, -8388608, 1, 0x302010}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := encodeFLAC(context.Background(), &WAVResult{Data: encodeWAV(16000, 1, tc.bps, tc.pcm)})
+			if err != nil {
+				t.Fatalf("encodeFLAC: %v", err)
+			}
+			got, info := decodeFLACForTest(t, out)
+			if info.bps != tc.bps {
+				t.Errorf("bps: got %d, want %d", info.bps, tc.bps)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestEncodeFLAC_Empty(t *testing.T) {
+	out, err := encodeFLAC(context.Background(), &WAVResult{Data: encodeWAV(24000, 1, 16, nil)})
+	if err != nil {
+		t.Fatalf("encodeFLAC: %v", err)
+	}
+	got, info := decodeFLACForTest(t, out)
+	if len(got) != 0 || info.total != 0 {
+		t.Errorf("expected empty stream, got %d samples", len(got))
+	}
+}
+
+// TestEncodeFLAC_Golden pins the encoder output for a small stereo signal to
+// bytes derived by hand from the FLAC format specification, so that the
+// tests do not rely on decodeFLACForTest alone.
+func TestEncodeFLAC_Golden(t *testing.T) {
+	// Left is best predicted by FIXED order 2 (residual 0 0 1 -2 3 -4, one
+	// partition, Rice parameter 1); right is CONSTANT -5.
+	left := []int16{0, 10, 20, 30, 41, 50, 62, 70}
+	var pcm []int16
+	for _, v := range left {
+		pcm = append(pcm, v, -5)
+	}
+	out, err := encodeFLAC(context.Background(), &WAVResult{Data: encodeWAV(16000, 2, 16, int16PCM(pcm...))})
+	if err != nil {
+		t.Fatalf("encodeFLAC: %v", err)
+	}
+
+	want := bytes.Join([][]byte{
+		[]byte("fLaC"),
+		{0x80, 0x00, 0x00, 0x22},             // last block, STREAMINFO, length 34
+		{0x10, 0x00, 0x10, 0x00},             // min/max block size 4096
+		{0x00, 0x00, 0x15, 0x00, 0x00, 0x15}, // min/max frame size 21
+		// 16000 Hz (20 bits), 2 channels (3), 16 bits (5), 8 samples (36)
+		{0x03, 0xe8, 0x02, 0xf0, 0x00, 0x00, 0x00, 0x08},
+		// MD5 of the interleaved little-endian samples
+		{0x7c, 0x32, 0x36, 0x97, 0x03, 0xbb, 0xd3, 0x4d, 0x0c, 0x3f, 0x20, 0x61, 0xf0, 0x59, 0x3d, 0x43},
+		// Frame header: sync, fixed blocking; 8-bit block size, 16 kHz;
+		// 2 independent channels, 16 bits; frame 0; block size-1 = 7; CRC-8
+		{0xff, 0xf8, 0x65, 0x18, 0x00, 0x07, 0x42},
+		// Subframes, not byte aligned. Left: FIXED order 2, warm-up 0 and
+		// 10, Rice partition order 0, parameter 1, residual 0 0 1 -2 3 -4.
+		// Right: CONSTANT -5. Zero padding to the byte boundary.
+		{0x14, 0x00, 0x00, 0x00, 0x0a, 0x00, 0x69, 0x31, 0x0c, 0x03, 0xff, 0xec},
+		{0x3f, 0x6a}, // CRC-16
+	}, nil)
+	if !bytes.Equal(out, want) {
+		t.Errorf("FLAC stream mismatch\n got %x\nwant %x", out, want)
+	}
+}
+
+// TestEncodeFLAC_ReferenceDecoder decodes the encoder output with the flac
+// command-line tool or ffmpeg, whichever is installed.
+func TestEncodeFLAC_ReferenceDecoder(t *testing.T) {
+	pcm := make([]int16, 2*flacBlockSize+777)
+	for i := range pcm {
+		pcm[i] = int16(8000*math.Sin(float64(i)/9) + float64((i*7919)%301-150))
+	}
+	out, err := encodeFLAC(context.Background(), &WAVResult{Data: encodeWAV(24000, 1, 16, int16PCM(pcm...))})
+	if err != nil {
+		t.Fatalf("encodeFLAC: %v", err)
+	}
+	in := writeTempFile(t, "in.flac", out)
+
+	var cmd *exec.Cmd
+	switch {
+	case lookPath("flac"):
+		cmd = exec.Command("flac", "--decode", "--silent", "--stdout", "--force-raw-format",
+			"--endian=little", "--sign=signed", in)
+	case lookPath("ffmpeg"):
+		cmd = exec.Command("ffmpeg", "-v", "error", "-i", in, "-f", "s16le", "-")
+	default:
+		t.Skip("neither flac nor ffmpeg found on PATH")
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	got, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("%s: %v\n%s", cmd.Path, err, stderr.String())
+	}
+	if want := int16PCM(pcm...); !bytes.Equal(got, want) {
+		t.Errorf("reference decoder output differs (%d bytes, want %d)", len(got), len(want))
+	}
+}
+
+func lookPath(name string) bool {
+	_, err := exec.LookPath(name)
+	return err == nil
+}
+
+func TestBitWriter_UTF8(t *testing.T) {
+	cases := map[uint64][]byte{
+		0x00:    {0x00},
+		0x7F:    {0x7F},
+		0x80:    {0xC2, 0x80},
+		0x7FF:   {0xDF, 0xBF},
+		0x800:   {0xE0, 0xA0, 0x80},
+		0x10000: {0xF0, 0x90, 0x80, 0x80},
+	}
+	for v, want := range cases {
+		var bw bitWriter
+		bw.writeUTF8(v)
+		if !bytes.Equal(bw.buf, want) {
+			t.Errorf("%#x: got %x, want %x", v, bw.buf, want)
+		}
+	}
+}
+
+// flacInfo holds the STREAMINFO fields checked by the tests.
+type flacInfo struct {
+	sampleRate    uint32
+	channels, bps uint16
+	total         uint64
+	md5           [16]byte
+}
+
+// decodeFLACForTest is a minimal FLAC decoder covering what encodeFLAC emits
+// (independent channels; CONSTANT, VERBATIM and FIXED subframes; 4-bit Rice
+// residuals). It verifies frame CRCs and returns interleaved samples.
+func decodeFLACForTest(t *testing.T, data []byte) ([]int32, flacInfo) {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte("fLaC")) {
+		t.Fatalf("missing fLaC marker")
+	}
+	br := &testBitReader{data: data[4:]}
+	if last, typ, size := br.read(1), br.read(7), br.read(24); last != 1 || typ != 0 || size != 34 {
+		t.Fatalf("metadata header: last=%d type=%d size=%d", last, typ, size)
+	}
+	br.read(16) // min block size
+	br.read(16) // max block size
+	br.read(24) // min frame size
+	br.read(24) // max frame size
+	var info flacInfo
+	info.sampleRate = uint32(br.read(20))
+	info.channels = uint16(br.read(3)) + 1
+	info.bps = uint16(br.read(5)) + 1
+	info.total = br.read(36)
+	copy(info.md5[:], br.data[br.pos/8:br.pos/8+16])
+	br.pos += 128
+
+	var out []int32
+	for br.pos/8 < len(br.data) {
+		frameStart := br.pos / 8
+		if sync := br.read(14); sync != 0x3FFE {
+			t.Fatalf("frame at byte %d: bad sync %#x", frameStart, sync)
+		}
+		br.read(2)
+		bsCode := br.read(4)
+		br.read(4) // sample rate
+		chans := int(br.read(4)) + 1
+		br.read(3) // sample size
+		br.read(1)
+		// Frame number, UTF-8 coded.
+		for extra := bits.LeadingZeros8(^byte(br.read(8))) - 1; extra > 0; extra-- {
+			br.read(8)
+		}
+		var blockSize int
+		switch bsCode {
+		case 6:
+			blockSize = int(br.read(8)) + 1
+		case 7:
+			blockSize = int(br.read(16)) + 1
+		default:
+			t.Fatalf("unexpected block size code %d", bsCode)
+		}
+		if crc := byte(br.read(8)); crc != crc8(br.data[frameStart:br.pos/8-1]) {
+			t.Fatalf("frame at byte %d: header CRC mismatch", frameStart)
+		}
+
+		block := make([][]int32, chans)
+		for c := range block {
+			block[c] = decodeSubframeForTest(t, br, blockSize, uint(info.bps))
+		}
+		br.pos = (br.pos + 7) &^ 7
+		want := crc16(br.data[frameStart : br.pos/8])
+		if got := uint16(br.read(16)); got != want {
+			t.Fatalf("frame at byte %d: CRC-16 mismatch", frameStart)
+		}
+		for i := 0; i < blockSize; i++ {
+			for c := range block {
+				out = append(out, block[c][i])
+			}
+		}
+	}
+	return out, info
+}
+
+func decodeSubframeForTest(t *testing.T, br *testBitReader, n int, bps uint) []int32 {
+	t.Helper()
+	header := br.read(8)
+	typ := header >> 1 & 0x3F
+	x := make([]int32, n)
+	switch {
+	case typ == 0:
+		v := br.readSigned(bps)
+		for i := range x {
+			x[i] = v
+		}
+	case typ == 1:
+		for i := range x {
+			x[i] = br.readSigned(bps)
+		}
+	case typ&0x38 == 0x08:
+		order := int(typ & 7)
+		for i := 0; i < order; i++ {
+			x[i] = br.readSigned(bps)
+		}
+		if method := br.read(2); method != 0 {
+			t.Fatalf("unexpected residual coding method %d", method)
+		}
+		partOrder := br.read(4)
+		parts := 1 << partOrder
+		i := order
+		for p := 0; p < parts; p++ {
+			k := uint(br.read(4))
+			count := n >> partOrder
+			if p == 0 {
+				count -= order
+			}
+			for j := 0; j < count; j++ {
+				q := uint64(0)
+				for br.read(1) == 0 {
+					q++
+				}
+				u := q<<k | br.read(k)
+				r := int64(u>>1) ^ -int64(u&1)
+				pred := int64(0)
+				switch order {
+				case 1:
+					pred = int64(x[i-1])
+				case 2:
+					pred = 2*int64(x[i-1]) - int64(x[i-2])
+				case 3:
+					pred = 3*int64(x[i-1]) - 3*int64(x[i-2]) + int64(x[i-3])
+				case 4:
+					pred = 4*int64(x[i-1]) - 6*int64(x[i-2]) + 4*int64(x[i-3]) - int64(x[i-4])
+				}
+				x[i] = int32(pred + r)
+				i++
+			}
+		}
+	default:
+		t.Fatalf("unexpected subframe type %#x", typ)
+	}
+	return x
+}
+
+type testBitReader struct {
+	data []byte
+	pos  int // in bits
+}
+
+func (r *testBitReader) read(n uint) uint64 {
+	var v uint64
+	for ; n > 0; n-- {
+		bit := r.data[r.pos/8] >> (7 - r.pos%8) & 1
+		v = v<<1 | uint64(bit)
+		r.pos++
+	}
+	return v
+}
+
+func (r *testBitReader) readSigned(n uint) int32 {
+	v := r.read(n)
+	return int32(int64(v<<(64-n)) >> (64 - n))
+}
@@ -1,17 +1,47 @@
 package pockettts
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"os/exec"
 	"syscall"
+	"time"
 )
 
 // Sentinel errors you can compare with errors.Is.
 var (
 	// ErrEmptyText is returned when the provided text is empty or whitespace-only.
 	ErrEmptyText = errors.New("pockettts: text must not be empty")
+
+	// ErrMalformedWAV is returned (wrapped with details) by the WAVResult
+	// sample accessors when the audio has a truncated or inconsistent data
+	// chunk or an unsupported sample format.
+	ErrMalformedWAV = errors.New("pockettts: malformed WAV data")
+
+	// ErrNoHealthyServer is returned by ServerPool when every member is out
+	// of rotation.
+	ErrNoHealthyServer = errors.New("pockettts: no healthy server in pool")
+
+	// ErrServerRestarting is returned by a supervised ServerClient for
+	// requests issued while the server is being restarted, unless
+	// ServerOptions.QueueDuringRestart is set.
+	ErrServerRestarting = errors.New("pockettts: server is restarting")
+
+	// ErrServerStopped is returned by a ServerClient for requests issued
+	// after Stop or Shutdown has begun.
+	ErrServerStopped = errors.New("pockettts: server is stopped")
+
+	// ErrRestartBudgetExhausted is returned (wrapped with the last failure)
+	// by a supervised ServerClient once the server has crashed more often
+	// than ServerOptions.MaxRestarts allows. It is not retryable.
+	ErrRestartBudgetExhausted = errors.New("pockettts: server restart budget exhausted")
+
+	// ErrQueueFull is returned by a ServerClient when ServerOptions.MaxQueue
+	// requests are already waiting for a slot.
+	ErrQueueFull = errors.New("pockettts: request queue is full")
 )
 
 // ErrExecutableNotFound is returned when the pocket-tts binary cannot be located.
@@ -23,17 +53,79 @@ func (e *ErrExecutableNotFound) Error() string {
 	return fmt.Sprintf("pockettts: executable not found: %q (install pocket-tts or set ExecutablePath)", e.Executable)
 }
 
-// ErrProcessTimeout is returned when the context deadline is exceeded while
-// waiting for the pocket-tts process.
+// ErrPortInUse is returned by ServerClient.Start when another process
+// already listens on the server's address.
+type ErrPortInUse struct {
+	Addr string
+	Err  error
+}
+
+func (e *ErrPortInUse) Error() string {
+	return fmt.Sprintf("pockettts: address %s already in use (stop the other server or set AutoPort)", e.Addr)
+}
+
+func (e *ErrPortInUse) Unwrap() error { return e.Err }
+
+// ErrCircuitOpen is returned by a CircuitBreaker that is rejecting requests
+// because its backend has been failing. It is retryable once RetryAfter has
+// passed.
+type ErrCircuitOpen struct {
+	// RetryAfter is how long until the breaker next lets a probe through.
+	// Zero means a probe is already in progress.
+	RetryAfter time.Duration
+
+	// LastErr is the failure that last opened the circuit.
+	LastErr error
+}
+
+func (e *ErrCircuitOpen) Error() string {
+	if e.LastErr != nil {
+		return fmt.Sprintf("pockettts: circuit open (retry after %s); last error: %v", e.RetryAfter, e.LastErr)
+	}
+	return fmt.Sprintf("pockettts: circuit open (retry after %s)", e.RetryAfter)
+}
+
+// ErrQueueTimeout is returned when a request's deadline passed before it
+// reached the backend: while it waited for a concurrency slot (including
+// ServerOptions.QueueTimeout), for a restarting server, or for an identical
+// in-flight request of a CachedSynthesizer. It unwraps to
+// context.DeadlineExceeded.
+type ErrQueueTimeout struct {
+	// Waited is how long the request waited.
+	Waited time.Duration
+
+	// Err is the context error that ended the wait.
+	Err error
+}
+
+func (e *ErrQueueTimeout) Error() string {
+	return fmt.Sprintf("pockettts: deadline exceeded after waiting %s for the backend", e.Waited.Round(time.Millisecond))
+}
+
+func (e *ErrQueueTimeout) Unwrap() error { return e.Err }
+
+// ErrProcessTimeout is returned when the context deadline passes while the
+// backend is synthesizing: the pocket-tts process or worker is running, or
+// the server request is outstanding. It unwraps to context.DeadlineExceeded.
 type ErrProcessTimeout struct {
 	Stderr string
+
+	// Elapsed is the time from the start of the synthesis to the deadline.
+	Elapsed time.Duration
+
+	// Err is the context error. Nil means context.DeadlineExceeded.
+	Err error
 }
 
 func (e *ErrProcessTimeout) Error() string {
+	msg := "pockettts: process timed out"
+	if e.Elapsed > 0 {
+		msg += " after " + e.Elapsed.Round(time.Millisecond).String()
+	}
 	if e.Stderr != "" {
-		return fmt.Sprintf("pockettts: process timed out; stderr: %s", e.Stderr)
+		msg += "; stderr: " + e.Stderr
 	}
-	return "pockettts: process timed out"
+	return msg
 }
 
 func (e *ErrProcessTimeout) Is(target error) bool {
@@ -41,37 +133,336 @@ func (e *ErrProcessTimeout) Is(target error) bool {
 	return ok
 }
 
+func (e *ErrProcessTimeout) Unwrap() error {
+	if e.Err != nil {
+		return e.Err
+	}
+	return context.DeadlineExceeded
+}
+
+// ErrCanceled is returned when the caller cancelled the request's context,
+// either before it reached the backend (Queued) or during synthesis. It
+// unwraps to context.Canceled and is not retryable.
+type ErrCanceled struct {
+	// Elapsed is the time from the start of the wait, or of the synthesis,
+	// to the cancellation.
+	Elapsed time.Duration
+
+	// Queued is set if the request was cancelled before it reached the
+	// backend.
+	Queued bool
+
+	// Stderr is the stderr excerpt of a cancelled process, if any.
+	Stderr string
+
+	// Err is the context error.
+	Err error
+}
+
+func (e *ErrCanceled) Error() string {
+	phase := "during synthesis"
+	if e.Queued {
+		phase = "while waiting for the backend"
+	}
+	return fmt.Sprintf("pockettts: request canceled %s after %s", phase, e.Elapsed.Round(time.Millisecond))
+}
+
+func (e *ErrCanceled) Unwrap() error { return e.Err }
+
+// contextError returns the error for a request whose context ended during
+// synthesis, elapsed after the synthesis started.
+func contextError(ctx context.Context, elapsed time.Duration, stderr string) error {
+	err := ctx.Err()
+	if errors.Is(err, context.Canceled) {
+		return &ErrCanceled{Elapsed: elapsed, Stderr: stderr, Err: err}
+	}
+	return &ErrProcessTimeout{Stderr: stderr, Elapsed: elapsed, Err: err}
+}
+
+// waitError returns the error for a request whose wait for the backend was
+// ended after waited by cause, a context error.
+func waitError(cause error, waited time.Duration) error {
+	if errors.Is(cause, context.Canceled) {
+		return &ErrCanceled{Elapsed: waited, Queued: true, Err: cause}
+	}
+	return &ErrQueueTimeout{Waited: waited, Err: cause}
+}
+
 // ErrNonZeroExit is returned when the pocket-tts process exits with a non-zero
-// status code.
+// status code, or when the server answers a request with an error status.
 type ErrNonZeroExit struct {
+	// ExitCode is the process exit code, or the HTTP status if HTTP is set.
 	ExitCode int
-	Stderr   string
+
+	// Stderr is the stderr excerpt of the process, or the server's error
+	// response body.
+	Stderr string
+
+	// HTTP is set when ExitCode is an HTTP status returned by the server
+	// rather than a process exit code.
+	HTTP bool
+
+	// Signal is the signal that terminated the process, if any; ExitCode is
+	// then -1. A SIGKILL the package did not send usually comes from the
+	// kernel's OOM killer.
+	Signal os.Signal
 }
 
 func (e *ErrNonZeroExit) Error() string {
+	if e.HTTP {
+		return fmt.Sprintf("pockettts: server returned status %d; body: %s", e.ExitCode, e.Stderr)
+	}
+	if e.Signal != nil {
+		return fmt.Sprintf("pockettts: process killed by signal %s; stderr: %s", e.Signal, e.Stderr)
+	}
 	return fmt.Sprintf("pockettts: process exited with code %d; stderr: %s", e.ExitCode, e.Stderr)
 }
 
+// cliUsageExitCode is the exit code of the pocket-tts CLI (and Python's
+// argument parsers) for invalid arguments.
+const cliUsageExitCode = 2
+
 // ErrInvalidVoice is returned when the CLI reports that the requested voice is
-// unknown or the voice file cannot be loaded.
+// unknown or the voice file cannot be loaded. It unwraps to the underlying
+// *ErrNonZeroExit when one is available.
 type ErrInvalidVoice struct {
 	Voice string
+
+	// Exit is the failure the voice error was classified from, if any.
+	Exit *ErrNonZeroExit
 }
 
 func (e *ErrInvalidVoice) Error() string {
 	return fmt.Sprintf("pockettts: invalid voice: %q", e.Voice)
 }
 
+func (e *ErrInvalidVoice) Unwrap() error { return unwrapExit(e.Exit) }
+
 // ErrModelDownloadFailed is returned when the CLI appears to fail during model
-// or weight download (detected heuristically from stderr).
+// or weight download (detected heuristically from stderr). It unwraps to the
+// underlying *ErrNonZeroExit when one is available.
 type ErrModelDownloadFailed struct {
 	Stderr string
+
+	// AuthRequired is set when Hugging Face rejected the download because the
+	// model is gated or the token is missing or invalid. Retrying will not
+	// help until HF_TOKEN is fixed.
+	AuthRequired bool
+
+	// Exit is the failure the download error was classified from, if any.
+	Exit *ErrNonZeroExit
 }
 
 func (e *ErrModelDownloadFailed) Error() string {
+	if e.AuthRequired {
+		return fmt.Sprintf("pockettts: model download failed (Hugging Face authentication required); stderr: %s", e.Stderr)
+	}
 	return fmt.Sprintf("pockettts: model download failed; stderr: %s", e.Stderr)
 }
 
+func (e *ErrModelDownloadFailed) Unwrap() error { return unwrapExit(e.Exit) }
+
+// ErrOutOfMemory is returned when pocket-tts ran out of memory (detected
+// heuristically from stderr, or from a SIGKILL). It unwraps to the underlying
+// *ErrNonZeroExit.
+type ErrOutOfMemory struct {
+	Exit *ErrNonZeroExit
+}
+
+func (e *ErrOutOfMemory) Error() string {
+	if e.Exit == nil {
+		return "pockettts: out of memory"
+	}
+	return fmt.Sprintf("pockettts: out of memory; stderr: %s", e.Exit.Stderr)
+}
+
+func (e *ErrOutOfMemory) Unwrap() error { return unwrapExit(e.Exit) }
+
+// ErrMissingDependency is returned when pocket-tts cannot import a required
+// Python module such as torch. It unwraps to the underlying *ErrNonZeroExit.
+type ErrMissingDependency struct {
+	// Module is the Python module that could not be imported, if known.
+	Module string
+
+	Exit *ErrNonZeroExit
+}
+
+func (e *ErrMissingDependency) Error() string {
+	if e.Module != "" {
+		return fmt.Sprintf("pockettts: missing Python dependency %q (reinstall pocket-tts with PyTorch)", e.Module)
+	}
+	return "pockettts: missing Python dependency (reinstall pocket-tts with PyTorch)"
+}
+
+func (e *ErrMissingDependency) Unwrap() error { return unwrapExit(e.Exit) }
+
+// unwrapExit avoids returning a typed nil from Unwrap methods.
+func unwrapExit(e *ErrNonZeroExit) error {
+	if e == nil {
+		return nil
+	}
+	return e
+}
+
+// IsRetryable reports whether err is a transient failure that may succeed
+// when retried later (e.g. a network error during model download or an
+// out-of-memory condition), as opposed to one that needs the request or the
+// installation fixed (e.g. ErrEmptyText, ErrInvalidVoice or
+// ErrMissingDependency). Requests the caller cancelled (ErrCanceled) and
+// requests to a stopped server (ErrServerStopped) are not retryable;
+// timeouts and a full queue (ErrQueueFull, the client-side counterpart of
+// HTTP 429) are.
+//
+// Failures that were not classified more precisely are treated as
+// retryable, except HTTP 4xx responses from the server other than 408 and
+// 429, and CLI usage errors (exit code 2).
+func IsRetryable(err error) bool {
+	var (
+		notFound *ErrExecutableNotFound
+		download *ErrModelDownloadFailed
+		oom      *ErrOutOfMemory
+		dep      *ErrMissingDependency
+		exit     *ErrNonZeroExit
+	)
+	switch {
+	case err == nil:
+		return false
+	case isPermanent(err),
+		errors.Is(err, ErrRestartBudgetExhausted),
+		errors.Is(err, context.Canceled),
+		errors.As(err, &notFound),
+		errors.As(err, &dep):
+		return false
+	case errors.Is(err, ErrQueueFull):
+		return true
+	case errors.As(err, &download):
+		return !download.AuthRequired
+	case errors.As(err, &oom):
+		return true
+	case errors.As(err, &exit):
+		if !exit.HTTP {
+			return exit.ExitCode != cliUsageExitCode
+		}
+		switch {
+		case exit.ExitCode == http.StatusRequestTimeout, exit.ExitCode == http.StatusTooManyRequests:
+			return true
+		default:
+			return exit.ExitCode < 400 || exit.ExitCode >= 500
+		}
+	default:
+		return true
+	}
+}
+
+// isPermanent reports whether err cannot succeed on retry, because the
+// request itself is invalid or the client was stopped. RetryPolicy never
+// retries such errors, whatever its Retryable returns.
+func isPermanent(err error) bool {
+	var voice *ErrInvalidVoice
+	return errors.Is(err, ErrEmptyText) ||
+		errors.Is(err, ErrServerStopped) ||
+		errors.As(err, &voice)
+}
+
+// ErrorClass is a coarse, low-cardinality category of a failure, suitable
+// as a metrics label. See ClassifyError.
+type ErrorClass string
+
+const (
+	// ErrorClassCanceled: the caller cancelled the request (ErrCanceled).
+	ErrorClassCanceled ErrorClass = "canceled"
+
+	// ErrorClassQueueFull: the concurrency queue was full (ErrQueueFull).
+	ErrorClassQueueFull ErrorClass = "queue_full"
+
+	// ErrorClassQueueTimeout: the deadline passed while queued
+	// (ErrQueueTimeout).
+	ErrorClassQueueTimeout ErrorClass = "queue_timeout"
+
+	// ErrorClassTimeout: the deadline passed during synthesis
+	// (ErrProcessTimeout).
+	ErrorClassTimeout ErrorClass = "timeout"
+
+	// ErrorClassUnavailable: the server is stopped, restarting or out of
+	// its restart budget, no pool member is healthy, or a circuit is open.
+	ErrorClassUnavailable ErrorClass = "unavailable"
+
+	// ErrorClassNotFound: the executable is missing (ErrExecutableNotFound).
+	ErrorClassNotFound ErrorClass = "not_found"
+
+	// ErrorClassInvalidRequest: the request needs fixing (ErrEmptyText,
+	// ErrInvalidVoice, or an HTTP 4xx other than 408 and 429).
+	ErrorClassInvalidRequest ErrorClass = "invalid_request"
+
+	// ErrorClassModelDownload: ErrModelDownloadFailed.
+	ErrorClassModelDownload ErrorClass = "model_download"
+
+	// ErrorClassOutOfMemory: ErrOutOfMemory.
+	ErrorClassOutOfMemory ErrorClass = "out_of_memory"
+
+	// ErrorClassMissingDependency: ErrMissingDependency.
+	ErrorClassMissingDependency ErrorClass = "missing_dependency"
+
+	// ErrorClassBackend: any other failed process or error response
+	// (ErrNonZeroExit).
+	ErrorClassBackend ErrorClass = "backend"
+
+	// ErrorClassOther: anything else, e.g. a connection error.
+	ErrorClassOther ErrorClass = "other"
+)
+
+// ClassifyError returns the ErrorClass of err. It returns "" for a nil err.
+func ClassifyError(err error) ErrorClass {
+	var (
+		canceled     *ErrCanceled
+		queueTimeout *ErrQueueTimeout
+		timeout      *ErrProcessTimeout
+		notFound     *ErrExecutableNotFound
+		voice        *ErrInvalidVoice
+		download     *ErrModelDownloadFailed
+		oom          *ErrOutOfMemory
+		dep          *ErrMissingDependency
+		open         *ErrCircuitOpen
+		exit         *ErrNonZeroExit
+	)
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &canceled), errors.Is(err, context.Canceled):
+		return ErrorClassCanceled
+	case errors.Is(err, ErrQueueFull):
+		return ErrorClassQueueFull
+	case errors.As(err, &queueTimeout):
+		return ErrorClassQueueTimeout
+	case errors.As(err, &timeout), errors.Is(err, context.DeadlineExceeded):
+		return ErrorClassTimeout
+	case errors.Is(err, ErrServerStopped),
+		errors.Is(err, ErrServerRestarting),
+		errors.Is(err, ErrRestartBudgetExhausted),
+		errors.Is(err, ErrNoHealthyServer),
+		errors.As(err, &open):
+		return ErrorClassUnavailable
+	case errors.As(err, &notFound):
+		return ErrorClassNotFound
+	case errors.Is(err, ErrEmptyText), errors.As(err, &voice):
+		return ErrorClassInvalidRequest
+	case errors.As(err, &download):
+		return ErrorClassModelDownload
+	case errors.As(err, &oom):
+		return ErrorClassOutOfMemory
+	case errors.As(err, &dep):
+		return ErrorClassMissingDependency
+	case errors.As(err, &exit):
+		if exit.HTTP && exit.ExitCode >= 400 && exit.ExitCode < 500 &&
+			exit.ExitCode != http.StatusRequestTimeout && exit.ExitCode != http.StatusTooManyRequests {
+			return ErrorClassInvalidRequest
+		}
+		return ErrorClassBackend
+	default:
+		return ErrorClassOther
+	}
+}
+
 // isNotFound reports whether err indicates the executable was not found.
 func isNotFound(err error) bool {
 	// exec.LookPath failure (no absolute path given)
@@ -25,6 +25,7 @@ func exportVoice(ctx context.Context, audioPath, exportPath string, opts *Export
 	r := &runner{
 		executablePath: opts.ExecutablePath,
 		logWriter:      opts.LogWriter,
+		voice:          audioPath, // an unreadable prompt surfaces as ErrInvalidVoice
 	}
 
 	_, err := r.run(ctx, args, nil)
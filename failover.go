@@ -0,0 +1,176 @@
+package pockettts
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// FailoverOptions configures a Failover.
+type FailoverOptions struct {
+	// Server is the preferred backend, typically a *ServerClient or a
+	// *ServerPool. Required. Failover does not start it.
+	Server Synthesizer
+
+	// CLI configures the fallback Client. Its Concurrency caps the number of
+	// fallback subprocesses independently of the server; zero or negative
+	// means 1, since every fallback request loads the model. Voice, Config,
+	// ExecutablePath and Observer default to those of Server if it is a
+	// *ServerClient or *ServerPool, so that both backends speak alike.
+	CLI Options
+
+	// HealthInterval is the time between health checks of Server. A server
+	// that failed a request gets requests again once a check passes.
+	// Defaults to 5 seconds.
+	HealthInterval time.Duration
+}
+
+func (o *FailoverOptions) healthInterval() time.Duration {
+	if o.HealthInterval > 0 {
+		return o.HealthInterval
+	}
+	return 5 * time.Second
+}
+
+// Failover sends requests to a server backend while it is healthy and falls
+// back to a CLI Client when it is not, trading the CLI's cold start for
+// availability.
+//
+// A request goes to the server unless the server failed its last health
+// check or request. If the server fails a request for a reason that is not
+// the request's fault (see CircuitBreakerOptions.IsFailure) — it is down,
+// restarting, or answers 5xx — the request is retried on the CLI and the
+// server is skipped until a health check passes again. Requests turned away
+// by the ServerClient's queue (ErrQueueFull, *ErrQueueTimeout) also go to
+// the CLI, but leave the server in rotation. The CLI receives the
+// same GenerateOptions; voice URLs and files are passed as --voice.
+// Stats.Backend reports which backend served each request.
+//
+// Create with NewFailover and Close when done.
+type Failover struct {
+	server Synthesizer
+	cli    *Client
+	opts   FailoverOptions
+
+	mu      sync.Mutex
+	healthy bool
+
+	stop func()
+	done chan struct{}
+}
+
+// NewFailover returns a Failover over opts.Server and a new CLI Client, and
+// starts its health checks.
+func NewFailover(opts FailoverOptions) *Failover {
+	cli := opts.CLI
+	if cli.Concurrency <= 0 {
+		cli.Concurrency = 1
+	}
+	var so *ServerOptions
+	switch s := opts.Server.(type) {
+	case *ServerClient:
+		so = &s.opts
+	case *ServerPool:
+		so = &s.opts.Server
+	}
+	if so != nil {
+		if cli.Voice == "" {
+			cli.Voice = so.Voice
+		}
+		if cli.Config == "" {
+			cli.Config = so.Config
+		}
+		if cli.ExecutablePath == "" {
+			cli.ExecutablePath = so.ExecutablePath
+		}
+		if cli.Observer == nil {
+			cli.Observer = so.Observer
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	f := &Failover{
+		server:  opts.Server,
+		cli:     NewClient(cli),
+		opts:    opts,
+		healthy: true,
+		stop:    cancel,
+		done:    make(chan struct{}),
+	}
+	go f.watch(ctx)
+	return f
+}
+
+// Synthesize implements Synthesizer.
+func (f *Failover) Synthesize(ctx context.Context, text string, opts *GenerateOptions) (*WAVResult, error) {
+	if !f.ServerHealthy() {
+		return f.cli.Synthesize(ctx, text, opts)
+	}
+	res, err := f.server.Synthesize(ctx, text, opts)
+	var queueTimeout *ErrQueueTimeout
+	switch {
+	case err == nil, ctx.Err() != nil, !isBackendFailure(err):
+		return res, err
+	case errors.Is(err, ErrQueueFull), errors.As(err, &queueTimeout):
+		// The server is busy, not broken: spill over without taking it out
+		// of rotation.
+	default:
+		f.setServerHealthy(false)
+	}
+	return f.cli.Synthesize(ctx, text, opts)
+}
+
+// Health implements Synthesizer. It returns nil if either backend is
+// usable, and the server's error otherwise.
+func (f *Failover) Health(ctx context.Context) error {
+	err := f.server.Health(ctx)
+	if err == nil {
+		return nil
+	}
+	if f.cli.Health(ctx) == nil {
+		return nil
+	}
+	return err
+}
+
+// Close stops the health checks and closes both backends.
+func (f *Failover) Close() error {
+	f.stop()
+	<-f.done
+	return errors.Join(f.server.Close(), f.cli.Close())
+}
+
+// ServerHealthy reports whether requests are currently routed to the server.
+func (f *Failover) ServerHealthy() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.healthy
+}
+
+func (f *Failover) setServerHealthy(healthy bool) {
+	f.mu.Lock()
+	f.healthy = healthy
+	f.mu.Unlock()
+}
+
+// watch checks the server's health every HealthInterval until ctx is done.
+func (f *Failover) watch(ctx context.Context) {
+	defer close(f.done)
+	ticker := time.NewTicker(f.opts.healthInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		hctx, cancel := context.WithTimeout(ctx, f.opts.healthInterval())
+		err := f.server.Health(hctx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		f.setServerHealthy(err == nil)
+	}
+}
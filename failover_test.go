@@ -0,0 +1,204 @@
+package pockettts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// failoverServer is a stand-in pocket-tts server whose /tts status and
+// /health status can be changed while it runs.
+type failoverServer struct {
+	ts        *httptest.Server
+	ttsStatus atomic.Int32
+	down      atomic.Bool // /health fails
+	ttsCalls  atomic.Int32
+}
+
+func newFailoverServer(t *testing.T) *failoverServer {
+	t.Helper()
+	wav := append(makeWAVHeader(24000, 1, 16), make([]byte, 10)...)
+	fs := &failoverServer{}
+	fs.ttsStatus.Store(http.StatusOK)
+	fs.ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			if fs.down.Load() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+			return
+		}
+		fs.ttsCalls.Add(1)
+		if status := int(fs.ttsStatus.Load()); status != http.StatusOK {
+			http.Error(w, "server error", status)
+			return
+		}
+		_, _ = w.Write(wav)
+	}))
+	t.Cleanup(fs.ts.Close)
+	return fs
+}
+
+// failoverCLI returns a fake pocket-tts that writes WAV output and appends
+// its arguments to the returned log file.
+func failoverCLI(t *testing.T) (exe, argLog string) {
+	t.Helper()
+	wav := writeTempFile(t, "cli.wav", append(makeWAVHeader(16000, 1, 16), make([]byte, 10)...))
+	argLog = filepath.Join(t.TempDir(), "args")
+	exe = fakeExecutable(t, fmt.Sprintf(`cat >/dev/null; echo "$@" >> %q; cat %q`, argLog, wav))
+	return exe, argLog
+}
+
+func readLog(path string) string {
+	b, _ := os.ReadFile(path)
+	return string(b)
+}
+
+func TestFailover_UsesServerWhileHealthy(t *testing.T) {
+	fs := newFailoverServer(t)
+	exe, argLog := failoverCLI(t)
+	f := NewFailover(FailoverOptions{
+		Server: NewServerClient(ServerOptions{BaseURL: fs.ts.URL}),
+		CLI:    Options{ExecutablePath: exe},
+	})
+	defer f.Close()
+
+	res, err := f.Synthesize(context.Background(), "hello", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Stats.Backend != ModeServer {
+		t.Errorf("Backend = %q, want %q", res.Stats.Backend, ModeServer)
+	}
+	if got := readLog(argLog); got != "" {
+		t.Errorf("CLI ran with %q", got)
+	}
+}
+
+func TestFailover_FallsBackAndRecovers(t *testing.T) {
+	fs := newFailoverServer(t)
+	exe, _ := failoverCLI(t)
+	f := NewFailover(FailoverOptions{
+		Server:         NewServerClient(ServerOptions{BaseURL: fs.ts.URL}),
+		CLI:            Options{ExecutablePath: exe},
+		HealthInterval: 20 * time.Millisecond,
+	})
+	defer f.Close()
+	ctx := context.Background()
+
+	fs.ttsStatus.Store(http.StatusInternalServerError)
+	fs.down.Store(true)
+	res, err := f.Synthesize(ctx, "hello", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Stats.Backend != ModeCLI || res.SampleRate != 16000 {
+		t.Errorf("Backend = %q, rate %d; want the CLI's result", res.Stats.Backend, res.SampleRate)
+	}
+
+	// While the server is unhealthy, requests skip it.
+	calls := fs.ttsCalls.Load()
+	if _, err := f.Synthesize(ctx, "hello", nil); err != nil {
+		t.Fatal(err)
+	}
+	if fs.ttsCalls.Load() != calls {
+		t.Error("request was sent to the unhealthy server")
+	}
+
+	fs.ttsStatus.Store(http.StatusOK)
+	fs.down.Store(false)
+	waitFor(t, "server back in rotation", f.ServerHealthy)
+	res, err = f.Synthesize(ctx, "hello", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Stats.Backend != ModeServer {
+		t.Errorf("Backend = %q after recovery, want %q", res.Stats.Backend, ModeServer)
+	}
+}
+
+func TestFailover_RequestErrorsDoNotFallBack(t *testing.T) {
+	fs := newFailoverServer(t)
+	exe, argLog := failoverCLI(t)
+	f := NewFailover(FailoverOptions{
+		Server: NewServerClient(ServerOptions{BaseURL: fs.ts.URL}),
+		CLI:    Options{ExecutablePath: exe},
+	})
+	defer f.Close()
+
+	fs.ttsStatus.Store(http.StatusBadRequest)
+	_, err := f.Synthesize(context.Background(), "hello", nil)
+	var exit *ErrNonZeroExit
+	if !errors.As(err, &exit) || exit.ExitCode != http.StatusBadRequest {
+		t.Fatalf("err = %v, want the server's 400", err)
+	}
+	if got := readLog(argLog); got != "" {
+		t.Errorf("CLI ran with %q", got)
+	}
+	if !f.ServerHealthy() {
+		t.Error("a 400 took the server out of rotation")
+	}
+}
+
+func TestFailover_TranslatesServerDefaults(t *testing.T) {
+	fs := newFailoverServer(t)
+	exe, argLog := failoverCLI(t)
+	f := NewFailover(FailoverOptions{
+		Server: NewServerClient(ServerOptions{BaseURL: fs.ts.URL, Voice: "marius", ExecutablePath: exe}),
+	})
+	defer f.Close()
+
+	fs.ttsStatus.Store(http.StatusBadGateway)
+	if _, err := f.Synthesize(context.Background(), "hello", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Synthesize(context.Background(), "hello", &GenerateOptions{VoiceURL: "hf://kyutai/voices/x.wav"}); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(readLog(argLog)), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], "--voice marius") ||
+		!strings.Contains(lines[1], "--voice hf://kyutai/voices/x.wav") {
+		t.Errorf("CLI args = %q", lines)
+	}
+	if f.cli.slots.limit != 1 {
+		t.Errorf("CLI concurrency = %d, want 1", f.cli.slots.limit)
+	}
+}
+
+func TestFailover_QueueFullSpillsWithoutEviction(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/tts" {
+			<-release
+		}
+		_, _ = w.Write(append(makeWAVHeader(24000, 1, 16), 0, 0))
+	}))
+	defer ts.Close()
+
+	exe, _ := failoverCLI(t)
+	sc := NewServerClient(ServerOptions{BaseURL: ts.URL, Concurrency: 1, QueueTimeout: 10 * time.Millisecond})
+	f := NewFailover(FailoverOptions{Server: sc, CLI: Options{ExecutablePath: exe}})
+	defer f.Close()
+	defer close(release) // before Close, which waits for the blocker
+
+	go func() { _, _ = sc.Generate(context.Background(), "blocker", nil) }()
+	waitFor(t, "server busy", func() bool { return sc.slots.active() == 1 })
+
+	res, err := f.Synthesize(context.Background(), "hello", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Stats.Backend != ModeCLI {
+		t.Errorf("Backend = %q, want %q", res.Stats.Backend, ModeCLI)
+	}
+	if !f.ServerHealthy() {
+		t.Error("a busy server was taken out of rotation")
+	}
+}
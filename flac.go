@@ -0,0 +1,446 @@
+package pockettts
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"math"
+	"math/bits"
+)
+
+// FLAC encoder.
+//
+// This is a small, dependency-free encoder that produces a valid native FLAC
+// stream: a STREAMINFO block followed by fixed-size frames. Each channel is
+// coded independently as a CONSTANT, FIXED (orders 0–4, Rice-coded residual
+// with partition-order search) or VERBATIM subframe, whichever is smallest.
+// It does not use LPC or inter-channel decorrelation, so it compresses less
+// than the reference encoder, but speech at 24 kHz still shrinks to roughly
+// half the size of the WAV.
+
+const (
+	flacBlockSize         = 4096
+	flacMaxFixedOrder     = 4
+	flacMaxPartitionOrder = 8
+	flacMaxRiceParam      = 14 // 15 is the escape code in the 4-bit Rice method
+)
+
+// encodeFLAC encodes the audio as a FLAC stream. 8-, 16- and 24-bit integer
+// PCM is stored at its original depth; other sample formats are converted to
+// 16-bit first.
+func encodeFLAC(_ context.Context, audio *WAVResult) ([]byte, error) {
+	w, err := decodeWAVData(audio.Data)
+	if err != nil {
+		return nil, err
+	}
+	samples, bps, err := flacSamples(w)
+	if err != nil {
+		return nil, err
+	}
+
+	channels := int(w.channels)
+	frames := len(samples) / channels
+
+	var out []byte
+	minFrame, maxFrame := 0, 0
+	chanBuf := make([][]int32, channels)
+	for i := range chanBuf {
+		chanBuf[i] = make([]int32, flacBlockSize)
+	}
+	for start, n := 0, 0; start < frames; start += flacBlockSize {
+		size := min(flacBlockSize, frames-start)
+		for c := range chanBuf {
+			for i := 0; i < size; i++ {
+				chanBuf[c][i] = samples[(start+i)*channels+c]
+			}
+		}
+		frame := encodeFLACFrame(n, w.sampleRate, bps, chanBuf, size)
+		if minFrame == 0 || len(frame) < minFrame {
+			minFrame = len(frame)
+		}
+		maxFrame = max(maxFrame, len(frame))
+		out = append(out, frame...)
+		n++
+	}
+
+	header := flacStreamHeader(w.sampleRate, w.channels, bps, uint64(frames),
+		minFrame, maxFrame, flacMD5(samples, bps))
+	return append(header, out...), nil
+}
+
+// flacSamples returns the interleaved samples of w as signed integers and
+// their bit depth.
+func flacSamples(w *wavData) ([]int32, uint16, error) {
+	p := w.pcm
+	if w.formatTag == wavFormatPCM {
+		switch w.bitsPerSample {
+		case 8:
+			out := make([]int32, len(p))
+			for i := range out {
+				out[i] = int32(p[i]) - 128
+			}
+			return out, 8, nil
+		case 16:
+			out := make([]int32, len(p)/2)
+			for i := range out {
+				out[i] = int32(int16(binary.LittleEndian.Uint16(p[2*i:])))
+			}
+			return out, 16, nil
+		case 24:
+			out := make([]int32, len(p)/3)
+			for i := range out {
+				out[i] = int32(p[3*i]) | int32(p[3*i+1])<<8 | int32(int8(p[3*i+2]))<<16
+			}
+			return out, 24, nil
+		}
+	}
+
+	s16, err := w.int16Samples()
+	if err != nil {
+		return nil, 0, err
+	}
+	out := make([]int32, len(s16))
+	for i, v := range s16 {
+		out[i] = int32(v)
+	}
+	return out, 16, nil
+}
+
+// flacStreamHeader returns the "fLaC" marker and the STREAMINFO block.
+func flacStreamHeader(sampleRate uint32, channels, bps uint16, total uint64, minFrame, maxFrame int, sum [16]byte) []byte {
+	var bw bitWriter
+	bw.writeBits(0x664C6143, 32) // "fLaC"
+	bw.writeBits(1, 1)           // last metadata block
+	bw.writeBits(0, 7)           // STREAMINFO
+	bw.writeBits(34, 24)
+	bw.writeBits(flacBlockSize, 16)
+	bw.writeBits(flacBlockSize, 16)
+	bw.writeBits(uint64(minFrame), 24)
+	bw.writeBits(uint64(maxFrame), 24)
+	bw.writeBits(uint64(sampleRate), 20)
+	bw.writeBits(uint64(channels-1), 3)
+	bw.writeBits(uint64(bps-1), 5)
+	bw.writeBits(total, 36)
+	bw.buf = append(bw.buf, sum[:]...)
+	return bw.buf
+}
+
+// flacMD5 is the STREAMINFO signature: the MD5 of the interleaved samples in
+// little-endian byte order at the stream's bit depth.
+func flacMD5(samples []int32, bps uint16) [16]byte {
+	bytesPer := int(bps+7) / 8
+	raw := make([]byte, 0, len(samples)*bytesPer)
+	for _, s := range samples {
+		for b := 0; b < bytesPer; b++ {
+			raw = append(raw, byte(s>>(8*b)))
+		}
+	}
+	return md5.Sum(raw)
+}
+
+// encodeFLACFrame encodes the first size samples of each channel as frame
+// number n.
+func encodeFLACFrame(n int, sampleRate uint32, bps uint16, chans [][]int32, size int) []byte {
+	var bw bitWriter
+	bw.writeBits(0x3FFE, 14) // sync code
+	bw.writeBits(0, 1)       // reserved
+	bw.writeBits(0, 1)       // fixed block size
+
+	bsCode, bsExtra := uint64(7), uint(16)
+	if size <= 256 {
+		bsCode, bsExtra = 6, 8
+	}
+	bw.writeBits(bsCode, 4)
+	bw.writeBits(flacSampleRateCode(sampleRate), 4)
+	bw.writeBits(uint64(len(chans)-1), 4) // independent channels
+	bw.writeBits(flacSampleSizeCode(bps), 3)
+	bw.writeBits(0, 1) // reserved
+	bw.writeUTF8(uint64(n))
+	bw.writeBits(uint64(size-1), bsExtra)
+	bw.writeBits(uint64(crc8(bw.buf)), 8)
+
+	for _, ch := range chans {
+		encodeFLACSubframe(&bw, ch[:size], uint(bps))
+	}
+	bw.flush()
+	crc := crc16(bw.buf)
+	return append(bw.buf, byte(crc>>8), byte(crc))
+}
+
+// encodeFLACSubframe writes the smallest of the CONSTANT, FIXED and VERBATIM
+// encodings of x.
+func encodeFLACSubframe(bw *bitWriter, x []int32, bps uint) {
+	constant := true
+	for _, v := range x[1:] {
+		if v != x[0] {
+			constant = false
+			break
+		}
+	}
+	if constant {
+		bw.writeBits(0, 8) // padding bit, type 000000, no wasted bits
+		bw.writeBits(uint64(x[0]), bps)
+		return
+	}
+
+	order, residual := bestFixedOrder(x)
+	partOrder, params, residualBits := riceParams(residual, len(x), order)
+	verbatimBits := len(x) * int(bps)
+	if int(bps)*order+2+residualBits >= verbatimBits {
+		bw.writeBits(0x02, 8) // type 000001: VERBATIM
+		for _, v := range x {
+			bw.writeBits(uint64(v), bps)
+		}
+		return
+	}
+
+	bw.writeBits(uint64(0x08|order)<<1, 8) // type 001xxx: FIXED
+	for _, v := range x[:order] {
+		bw.writeBits(uint64(v), bps)
+	}
+	bw.writeBits(0, 2) // 4-bit Rice parameters
+	bw.writeBits(uint64(partOrder), 4)
+	partSize := len(x) >> partOrder
+	pos := 0
+	for p, k := range params {
+		n := partSize
+		if p == 0 {
+			n -= order
+		}
+		bw.writeBits(uint64(k), 4)
+		for _, r := range residual[pos : pos+n] {
+			bw.writeRice(zigzag(r), uint(k))
+		}
+		pos += n
+	}
+}
+
+// bestFixedOrder returns the fixed-predictor order with the smallest total
+// absolute residual, and that residual.
+func bestFixedOrder(x []int32) (int, []int64) {
+	best, bestSum := 0, uint64(math.MaxUint64)
+	var bestRes []int64
+	for order := 0; order <= flacMaxFixedOrder && order < len(x); order++ {
+		res := fixedResidual(x, order)
+		var sum uint64
+		for _, r := range res {
+			if r < 0 {
+				sum += uint64(-r)
+			} else {
+				sum += uint64(r)
+			}
+		}
+		if sum < bestSum {
+			best, bestSum, bestRes = order, sum, res
+		}
+	}
+	return best, bestRes
+}
+
+// fixedResidual applies the FLAC fixed predictor of the given order.
+func fixedResidual(x []int32, order int) []int64 {
+	res := make([]int64, len(x)-order)
+	for i := order; i < len(x); i++ {
+		v := int64(x[i])
+		switch order {
+		case 1:
+			v -= int64(x[i-1])
+		case 2:
+			v -= 2*int64(x[i-1]) - int64(x[i-2])
+		case 3:
+			v -= 3*int64(x[i-1]) - 3*int64(x[i-2]) + int64(x[i-3])
+		case 4:
+			v -= 4*int64(x[i-1]) - 6*int64(x[i-2]) + 4*int64(x[i-3]) - int64(x[i-4])
+		}
+		res[i-order] = v
+	}
+	return res
+}
+
+// riceParams picks the partition order and per-partition Rice parameters that
+// minimise the coded size of residual, returning the size in bits (including
+// the partition headers).
+func riceParams(residual []int64, blockSize, predOrder int) (int, []int, int) {
+	bestOrder, bestBits := 0, math.MaxInt
+	var bestParams []int
+	for po := 0; po <= flacMaxPartitionOrder; po++ {
+		parts := 1 << po
+		if blockSize%parts != 0 || blockSize>>po <= predOrder {
+			break
+		}
+		partSize := blockSize >> po
+		total := 4 // partition order
+		params := make([]int, parts)
+		pos := 0
+		for p := range params {
+			n := partSize
+			if p == 0 {
+				n -= predOrder
+			}
+			k, b := bestRiceParam(residual[pos : pos+n])
+			params[p] = k
+			total += 4 + b
+			pos += n
+		}
+		if total < bestBits {
+			bestOrder, bestBits, bestParams = po, total, params
+		}
+	}
+	return bestOrder, bestParams, bestBits
+}
+
+// bestRiceParam returns the Rice parameter that codes residual in the fewest
+// bits, and that number of bits.
+func bestRiceParam(residual []int64) (int, int) {
+	var sum uint64
+	for _, r := range residual {
+		sum += zigzag(r)
+	}
+	bestK, bestBits := 0, math.MaxInt
+	for k := 0; k <= flacMaxRiceParam; k++ {
+		b := len(residual)*(k+1) + int(sum>>k)
+		if b < bestBits {
+			bestK, bestBits = k, b
+		}
+	}
+	// sum>>k underestimates the unary part; compute it exactly for the winner.
+	exact := len(residual) * (bestK + 1)
+	for _, r := range residual {
+		exact += int(zigzag(r) >> bestK)
+	}
+	return bestK, exact
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+func flacSampleRateCode(sr uint32) uint64 {
+	switch sr {
+	case 88200:
+		return 1
+	case 176400:
+		return 2
+	case 192000:
+		return 3
+	case 8000:
+		return 4
+	case 16000:
+		return 5
+	case 22050:
+		return 6
+	case 24000:
+		return 7
+	case 32000:
+		return 8
+	case 44100:
+		return 9
+	case 48000:
+		return 10
+	case 96000:
+		return 11
+	default:
+		return 0 // take it from STREAMINFO
+	}
+}
+
+func flacSampleSizeCode(bps uint16) uint64 {
+	switch bps {
+	case 8:
+		return 1
+	case 16:
+		return 4
+	case 24:
+		return 6
+	default:
+		return 0 // take it from STREAMINFO
+	}
+}
+
+// bitWriter packs values MSB-first.
+type bitWriter struct {
+	buf   []byte
+	acc   uint64
+	nbits uint
+}
+
+// writeBits appends the low n bits of v (n <= 64).
+func (w *bitWriter) writeBits(v uint64, n uint) {
+	for n > 32 {
+		n -= 32
+		w.writeBits(v>>n, 32)
+	}
+	if n == 0 {
+		return
+	}
+	w.acc = w.acc<<n | v&(1<<n-1)
+	w.nbits += n
+	for w.nbits >= 8 {
+		w.nbits -= 8
+		w.buf = append(w.buf, byte(w.acc>>w.nbits))
+	}
+}
+
+// writeRice appends u as a Rice code with parameter k: the quotient in unary
+// (zeros terminated by a one) followed by the k low bits.
+func (w *bitWriter) writeRice(u uint64, k uint) {
+	for q := u >> k; q > 0; {
+		n := min(q, 32)
+		w.writeBits(0, uint(n))
+		q -= n
+	}
+	w.writeBits(1, 1)
+	w.writeBits(u, k)
+}
+
+// writeUTF8 appends v in FLAC's extended UTF-8 coding.
+func (w *bitWriter) writeUTF8(v uint64) {
+	if v < 0x80 {
+		w.writeBits(v, 8)
+		return
+	}
+	n := (bits.Len64(v) - 2) / 5 // continuation bytes
+	w.writeBits(uint64(0xFF00>>(n+1))&0xFF|v>>(6*n), 8)
+	for i := n - 1; i >= 0; i-- {
+		w.writeBits(0x80|(v>>(6*i))&0x3F, 8)
+	}
+}
+
+// flush pads the final partial byte with zero bits.
+func (w *bitWriter) flush() {
+	if w.nbits > 0 {
+		w.writeBits(0, 8-w.nbits)
+	}
+}
+
+// crc8 is FLAC's frame-header CRC (polynomial x^8 + x^2 + x + 1).
+func crc8(data []byte) byte {
+	var crc byte
+	for _, b := range data {
+		crc ^= b
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// crc16 is FLAC's frame CRC (polynomial x^16 + x^15 + x^2 + 1).
+func crc16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x8005
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
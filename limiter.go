@@ -0,0 +1,188 @@
+package pockettts
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Priority orders requests waiting for a concurrency slot. Higher
+// priorities are admitted first; within a priority, requests are admitted in
+// arrival order.
+type Priority int
+
+const (
+	// PriorityBatch is for background work that can wait.
+	PriorityBatch Priority = -1
+
+	// PriorityNormal is the default priority.
+	PriorityNormal Priority = 0
+
+	// PriorityInteractive is for requests a user is waiting on.
+	PriorityInteractive Priority = 1
+)
+
+// String returns "batch", "normal", "interactive", or the number for other
+// values.
+func (p Priority) String() string {
+	switch p {
+	case PriorityBatch:
+		return "batch"
+	case PriorityNormal:
+		return "normal"
+	case PriorityInteractive:
+		return "interactive"
+	default:
+		return strconv.Itoa(int(p))
+	}
+}
+
+// limiter bounds the number of requests in flight and queues the rest by
+// priority, optionally bounding the queue's length and each request's wait.
+// A waiting request gains one priority level per aging interval, so that
+// low priorities are not starved.
+type limiter struct {
+	limit    int           // maximum in flight; zero or negative is unlimited
+	maxQueue int           // maximum waiting; zero or negative is unlimited
+	timeout  time.Duration // maximum wait; zero is unlimited
+	aging    time.Duration // zero means 10s; negative disables aging
+
+	mu       sync.Mutex
+	inFlight int
+	waiters  []*limiterWaiter // in arrival order
+}
+
+// limiterWaiter is a queued request. ready is closed when it is handed a
+// slot.
+type limiterWaiter struct {
+	prio     Priority
+	enqueued time.Time
+	ready    chan struct{}
+}
+
+// effective returns w's priority after aging at time now.
+func (l *limiter) effective(w *limiterWaiter, now time.Time) int {
+	aging := l.aging
+	switch {
+	case aging < 0:
+		return int(w.prio)
+	case aging == 0:
+		aging = 10 * time.Second
+	}
+	return int(w.prio) + int(now.Sub(w.enqueued)/aging)
+}
+
+// acquire waits for a slot and returns a func that releases it, together
+// with the time spent waiting. It fails with ErrQueueFull if the queue is
+// full, with *ErrQueueTimeout if the timeout or ctx's deadline ends the
+// wait, and with *ErrCanceled if ctx is cancelled.
+func (l *limiter) acquire(ctx context.Context, prio Priority) (release func(), waited time.Duration, err error) {
+	if l == nil || l.limit <= 0 {
+		return func() {}, 0, nil
+	}
+
+	l.mu.Lock()
+	if l.inFlight < l.limit && len(l.waiters) == 0 {
+		l.inFlight++
+		l.mu.Unlock()
+		return l.releaseFunc(), 0, nil
+	}
+	if l.maxQueue > 0 && len(l.waiters) >= l.maxQueue {
+		l.mu.Unlock()
+		return nil, 0, ErrQueueFull
+	}
+	start := time.Now()
+	w := &limiterWaiter{prio: prio, enqueued: start, ready: make(chan struct{})}
+	l.waiters = append(l.waiters, w)
+	l.mu.Unlock()
+
+	var expired <-chan time.Time
+	if l.timeout > 0 {
+		t := time.NewTimer(l.timeout)
+		defer t.Stop()
+		expired = t.C
+	}
+	var cause error
+	select {
+	case <-w.ready:
+		return l.releaseFunc(), time.Since(start), nil
+	case <-ctx.Done():
+		cause = ctx.Err()
+	case <-expired:
+		cause = context.DeadlineExceeded
+	}
+
+	l.mu.Lock()
+	if !l.remove(w) {
+		// Handed a slot just as the wait ended; pass it on.
+		l.mu.Unlock()
+		l.release()
+	} else {
+		l.mu.Unlock()
+	}
+	return nil, 0, waitError(cause, time.Since(start))
+}
+
+// releaseFunc returns a func that releases one slot, once.
+func (l *limiter) releaseFunc() func() {
+	var once sync.Once
+	return func() { once.Do(l.release) }
+}
+
+// release hands the slot to the waiter with the highest effective priority
+// (the earliest among equals), or frees it.
+func (l *limiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.waiters) == 0 {
+		l.inFlight--
+		return
+	}
+	now := time.Now()
+	best := 0
+	for i := 1; i < len(l.waiters); i++ {
+		if l.effective(l.waiters[i], now) > l.effective(l.waiters[best], now) {
+			best = i
+		}
+	}
+	w := l.waiters[best]
+	l.waiters = append(l.waiters[:best], l.waiters[best+1:]...)
+	close(w.ready)
+}
+
+// remove takes w out of the queue and reports whether it was still waiting.
+// l.mu must be held.
+func (l *limiter) remove(w *limiterWaiter) bool {
+	for i, x := range l.waiters {
+		if x == w {
+			l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// queued returns the number of waiting requests.
+func (l *limiter) queued() int {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.waiters)
+}
+
+// depth returns the number of waiting requests by priority.
+func (l *limiter) depth() map[Priority]int {
+	depth := make(map[Priority]int)
+	if l == nil {
+		return depth
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, w := range l.waiters {
+		depth[w.prio]++
+	}
+	return depth
+}
@@ -0,0 +1,274 @@
+package pockettts
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// active returns the number of slots in use.
+func (l *limiter) active() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inFlight
+}
+
+func TestLimiter_FIFO(t *testing.T) {
+	l := &limiter{limit: 1}
+	release, _, err := l.acquire(context.Background(), PriorityNormal)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mu    sync.Mutex
+		order []int
+		wg    sync.WaitGroup
+	)
+	for i := range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r, waited, err := l.acquire(context.Background(), PriorityNormal)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if waited <= 0 {
+				t.Errorf("waiter %d: waited = %s, want > 0", i, waited)
+			}
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+			r()
+		}()
+		waitFor(t, "waiter queued", func() bool { return l.queued() == i+1 })
+	}
+	release()
+	wg.Wait()
+	if len(order) != 3 || order[0] != 0 || order[1] != 1 || order[2] != 2 {
+		t.Errorf("admission order = %v, want [0 1 2]", order)
+	}
+	if n := l.active(); n != 0 {
+		t.Errorf("inFlight = %d after all releases, want 0", n)
+	}
+}
+
+func TestLimiter_QueueFull(t *testing.T) {
+	l := &limiter{limit: 1, maxQueue: 1}
+	release, _, _ := l.acquire(context.Background(), PriorityNormal)
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _, _, _ = l.acquire(ctx, PriorityNormal) }()
+	waitFor(t, "waiter queued", func() bool { return l.queued() == 1 })
+
+	if _, _, err := l.acquire(context.Background(), PriorityNormal); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("err = %v, want ErrQueueFull", err)
+	}
+}
+
+func TestLimiter_QueueTimeout(t *testing.T) {
+	l := &limiter{limit: 1, timeout: 30 * time.Millisecond}
+	release, _, _ := l.acquire(context.Background(), PriorityNormal)
+	defer release()
+
+	_, _, err := l.acquire(context.Background(), PriorityNormal)
+	var qt *ErrQueueTimeout
+	if !errors.As(err, &qt) || qt.Waited < 30*time.Millisecond {
+		t.Fatalf("err = %v, want *ErrQueueTimeout after 30ms", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("ErrQueueTimeout does not unwrap to context.DeadlineExceeded")
+	}
+	var pt *ErrProcessTimeout
+	if errors.As(err, &pt) {
+		t.Error("queue timeout is reported as ErrProcessTimeout")
+	}
+	if n := l.queued(); n != 0 {
+		t.Errorf("queued = %d after timeout, want 0", n)
+	}
+}
+
+func TestLimiter_CancelledWaiterDoesNotLeakSlot(t *testing.T) {
+	l := &limiter{limit: 1}
+	for range 200 {
+		release, _, _ := l.acquire(context.Background(), PriorityNormal)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan func())
+		go func() {
+			r, _, err := l.acquire(ctx, PriorityNormal)
+			if err != nil {
+				r = nil
+			}
+			done <- r
+		}()
+		waitFor(t, "waiter queued", func() bool { return l.queued() == 1 })
+		go cancel()
+		release()
+		if r := <-done; r != nil {
+			r()
+		}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.inFlight != 0 || len(l.waiters) != 0 {
+		t.Errorf("inFlight = %d, waiters = %d; want 0, 0", l.inFlight, len(l.waiters))
+	}
+}
+
+func TestServerClient_ConcurrencyLimit(t *testing.T) {
+	wav := append(makeWAVHeader(24000, 1, 16), make([]byte, 10)...)
+	var inFlight, maxSeen atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			m := maxSeen.Load()
+			if n <= m || maxSeen.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		_, _ = w.Write(wav)
+	}))
+	defer ts.Close()
+
+	sc := NewServerClient(ServerOptions{BaseURL: ts.URL, Concurrency: 2})
+	var (
+		wg     sync.WaitGroup
+		queued atomic.Int32
+	)
+	for range 6 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := sc.Generate(context.Background(), "hello", nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if res.Stats.QueueWait > 0 {
+				queued.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if m := maxSeen.Load(); m != 2 {
+		t.Errorf("server saw %d concurrent requests, want 2", m)
+	}
+	if queued.Load() == 0 {
+		t.Error("no request reported QueueWait")
+	}
+}
+
+func TestServerClient_QueueTimeout(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		_, _ = w.Write(append(makeWAVHeader(24000, 1, 16), 0, 0))
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	sc := NewServerClient(ServerOptions{BaseURL: ts.URL, Concurrency: 1, QueueTimeout: 30 * time.Millisecond})
+	go func() { _, _ = sc.Generate(context.Background(), "blocker", nil) }()
+	waitFor(t, "first request in flight", func() bool { return sc.slots.active() == 1 })
+
+	_, err := sc.Generate(context.Background(), "hello", nil)
+	var qt *ErrQueueTimeout
+	if !errors.As(err, &qt) {
+		t.Fatalf("err = %v, want *ErrQueueTimeout", err)
+	}
+}
+
+// queueInOrder queues one waiter per priority on l, in the given order, and
+// returns a channel that receives each priority as it is admitted.
+func queueInOrder(t *testing.T, l *limiter, prios ...Priority) <-chan Priority {
+	t.Helper()
+	queued := l.queued()
+	admitted := make(chan Priority, len(prios))
+	for i, p := range prios {
+		go func() {
+			release, _, err := l.acquire(context.Background(), p)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			admitted <- p
+			release()
+		}()
+		waitFor(t, "waiter queued", func() bool { return l.queued() == queued+i+1 })
+	}
+	return admitted
+}
+
+func TestLimiter_Priority(t *testing.T) {
+	l := &limiter{limit: 1, aging: -1}
+	release, _, _ := l.acquire(context.Background(), PriorityNormal)
+	admitted := queueInOrder(t, l, PriorityBatch, PriorityNormal, PriorityInteractive, PriorityBatch, PriorityInteractive)
+
+	depth := l.depth()
+	if depth[PriorityBatch] != 2 || depth[PriorityNormal] != 1 || depth[PriorityInteractive] != 2 {
+		t.Errorf("depth = %v, want 2 batch, 1 normal, 2 interactive", depth)
+	}
+
+	release()
+	var got []Priority
+	for range 5 {
+		got = append(got, <-admitted)
+	}
+	want := []Priority{PriorityInteractive, PriorityInteractive, PriorityNormal, PriorityBatch, PriorityBatch}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("admission order = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestLimiter_AgingPreventsStarvation(t *testing.T) {
+	l := &limiter{limit: 1, aging: 20 * time.Millisecond}
+	release, _, _ := l.acquire(context.Background(), PriorityNormal)
+	admitted := queueInOrder(t, l, PriorityBatch)
+	time.Sleep(50 * time.Millisecond) // batch has aged two levels: now above interactive
+	admitted2 := queueInOrder(t, l, PriorityInteractive)
+
+	release()
+	if p := <-admitted; p != PriorityBatch {
+		t.Fatalf("first admitted %s, want the aged batch request", p)
+	}
+	<-admitted2
+}
+
+func TestClient_PriorityQueue(t *testing.T) {
+	wav := writeTempFile(t, "out.wav", append(makeWAVHeader(24000, 1, 16), 0, 0))
+	exe := fakeExecutable(t, "cat >/dev/null; cat "+wav)
+	c := NewClient(Options{ExecutablePath: exe, Concurrency: 1, QueueAging: -1})
+
+	hold, _, _ := c.acquire(context.Background(), PriorityNormal)
+	order := make(chan Priority, 2)
+	for _, p := range []Priority{PriorityBatch, PriorityInteractive} {
+		n := c.Queued()
+		go func() {
+			if _, err := c.Synthesize(context.Background(), "hi", &GenerateOptions{Priority: p}); err != nil {
+				t.Error(err)
+			}
+			order <- p
+		}()
+		waitFor(t, "request queued", func() bool { return c.Queued() == n+1 })
+	}
+	if d := c.QueueDepth(); d[PriorityBatch] != 1 || d[PriorityInteractive] != 1 {
+		t.Errorf("QueueDepth = %v", d)
+	}
+	hold()
+	if first := <-order; first != PriorityInteractive {
+		t.Errorf("first completed %s, want interactive", first)
+	}
+	<-order
+}
@@ -0,0 +1,350 @@
+package pockettts
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// LongFormOptions controls GenerateLongForm.
+type LongFormOptions struct {
+	// MaxChunkChars is the maximum length of a chunk in bytes. Sentences are
+	// packed into chunks up to this size; longer sentences are split at
+	// clause boundaries or whitespace. Defaults to 400.
+	MaxChunkChars int
+
+	// Parallelism is the maximum number of chunks synthesized concurrently.
+	// The backend's own limits (e.g. Options.Concurrency) still apply.
+	// Defaults to 1.
+	Parallelism int
+
+	// Gap is the silence inserted between consecutive chunks.
+	// Zero means chunks are joined back to back.
+	Gap time.Duration
+
+	// Generate is passed to the backend for every chunk. May be nil.
+	// Chunks are always synthesized as WAV in the backend's format; the
+	// sample format conversion and encoding options of Generate are applied
+	// to the stitched result.
+	Generate *GenerateOptions
+}
+
+func (o *LongFormOptions) maxChunkChars() int {
+	if o.MaxChunkChars <= 0 {
+		return 400
+	}
+	return o.MaxChunkChars
+}
+
+func (o *LongFormOptions) parallelism() int {
+	if o.Parallelism <= 0 {
+		return 1
+	}
+	return o.Parallelism
+}
+
+// ChunkResult describes one synthesized chunk of a long-form generation.
+type ChunkResult struct {
+	// Index is the position of the chunk in the input text.
+	Index int
+
+	// Text is the chunk text sent to the backend.
+	Text string
+
+	// Offset is where the chunk's audio starts in the stitched output.
+	Offset time.Duration
+
+	// AudioDuration is the playback length of the chunk's audio.
+	AudioDuration time.Duration
+
+	// Stats holds the backend's observability data for this chunk.
+	Stats GenerationStats
+}
+
+// LongFormResult is the output of GenerateLongForm.
+type LongFormResult struct {
+	// Audio is the stitched WAV. Audio.Stats.Duration is the wall-clock time
+	// of the whole long-form call.
+	Audio *WAVResult
+
+	// Chunks lists the chunks in playback order.
+	Chunks []ChunkResult
+}
+
+// GenerateLongForm splits text into sentence and paragraph chunks (see
+// SplitText), synthesizes them through s with bounded parallelism, and
+// stitches the results into a single WAV with opts.Gap of silence between
+// chunks. opts may be nil.
+//
+// The first chunk failure cancels the remaining chunks and is returned.
+// Returns ErrEmptyText if text is empty or whitespace-only.
+func GenerateLongForm(ctx context.Context, s Synthesizer, text string, opts *LongFormOptions) (*LongFormResult, error) {
+	if opts == nil {
+		opts = &LongFormOptions{}
+	}
+	chunks := SplitText(text, opts.maxChunkChars())
+	if len(chunks) == 0 {
+		return nil, ErrEmptyText
+	}
+	if err := opts.Generate.validate(); err != nil {
+		return nil, err
+	}
+	chunkOpts := opts.Generate
+	if chunkOpts != nil {
+		o := *chunkOpts
+		o.SampleRate, o.Channels, o.BitsPerSample = 0, 0, 0
+		o.Format, o.Encoder = "", nil
+		chunkOpts = &o
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	start := time.Now()
+	results := make([]*WAVResult, len(chunks))
+	sem := make(chan struct{}, opts.parallelism())
+	var (
+		wg       sync.WaitGroup
+		failOnce sync.Once
+		firstErr error
+	)
+
+	for i, chunk := range chunks {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(i int, chunk string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			res, err := s.Synthesize(ctx, chunk, chunkOpts)
+			if err != nil {
+				failOnce.Do(func() {
+					firstErr = fmt.Errorf("pockettts: long-form chunk %d: %w", i, err)
+					cancel()
+				})
+				return
+			}
+			results[i] = res
+		}(i, chunk)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	out, err := stitchChunks(chunks, results, opts.Gap, time.Since(start))
+	if err != nil {
+		return nil, err
+	}
+	if out.Audio, err = finishResult(ctx, out.Audio, opts.Generate); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// stitchChunks concatenates the PCM data of results in order, separated by gap
+// of silence, and records per-chunk offsets.
+func stitchChunks(chunks []string, results []*WAVResult, gap time.Duration, elapsed time.Duration) (*LongFormResult, error) {
+	first := results[0]
+	sr, ch, bps := first.SampleRate, first.Channels, first.BitsPerSample
+	gapPCM := silence(gap, sr, ch, bps)
+
+	var pcm []byte
+	out := &LongFormResult{Chunks: make([]ChunkResult, len(results))}
+	for i, res := range results {
+		if res.SampleRate != sr || res.Channels != ch || res.BitsPerSample != bps {
+			return nil, fmt.Errorf("pockettts: long-form chunk %d has format %d Hz/%d ch/%d-bit, want %d Hz/%d ch/%d-bit",
+				i, res.SampleRate, res.Channels, res.BitsPerSample, sr, ch, bps)
+		}
+		data, err := res.PCM()
+		if err != nil {
+			return nil, fmt.Errorf("pockettts: long-form chunk %d: %w", i, err)
+		}
+		if i > 0 {
+			pcm = append(pcm, gapPCM...)
+		}
+		out.Chunks[i] = ChunkResult{
+			Index:         i,
+			Text:          chunks[i],
+			Offset:        pcmDuration(len(pcm), sr, ch, bps),
+			AudioDuration: pcmDuration(len(data), sr, ch, bps),
+			Stats:         res.Stats,
+		}
+		pcm = append(pcm, data...)
+	}
+
+	out.Audio = &WAVResult{
+		Data:          encodeWAV(sr, ch, bps, pcm),
+		SampleRate:    sr,
+		Channels:      ch,
+		BitsPerSample: bps,
+		Stats:         GenerationStats{Duration: elapsed},
+	}
+	return out, nil
+}
+
+// paragraphBreak matches one or more blank lines.
+var paragraphBreak = regexp.MustCompile(`\n[ \t]*\n\s*`)
+
+// abbreviations are lower-cased words that end in a period without ending a
+// sentence.
+var abbreviations = map[string]bool{
+	"mr": true, "mrs": true, "ms": true, "dr": true, "prof": true, "sr": true,
+	"jr": true, "st": true, "mt": true, "vs": true, "etc": true, "e.g": true,
+	"i.e": true, "cf": true, "approx": true, "no": true, "nr": true, "fig": true,
+	"inc": true, "ltd": true, "co": true, "corp": true, "dept": true, "est": true,
+	"jan": true, "feb": true, "mar": true, "apr": true, "jun": true, "jul": true,
+	"aug": true, "sep": true, "sept": true, "oct": true, "nov": true, "dec": true,
+	"u.s": true, "u.k": true, "a.m": true, "p.m": true, "ca": true, "z.b": true,
+	"bzw": true, "usw": true, "vgl": true, "d.h": true,
+}
+
+// SplitText splits text into chunks of at most maxChars bytes for long-form
+// synthesis. Paragraphs (separated by blank lines) always start a new chunk;
+// within a paragraph, whole sentences are packed greedily. Sentence detection
+// does not break after common abbreviations ("Dr.", "e.g."), single-letter
+// initials, decimal numbers ("3.14") or before a lower-case continuation, and
+// keeps closing quotes and brackets with their sentence. A sentence longer
+// than maxChars is split at the last clause boundary (",;:") or space that
+// fits. Whitespace-only input yields no chunks.
+func SplitText(text string, maxChars int) []string {
+	if maxChars <= 0 {
+		maxChars = 400
+	}
+	var chunks []string
+	for _, para := range paragraphBreak.Split(text, -1) {
+		para = strings.Join(strings.Fields(para), " ")
+		if para == "" {
+			continue
+		}
+		var cur string
+		for _, sent := range splitSentences(para) {
+			for _, piece := range splitLong(sent, maxChars) {
+				switch {
+				case cur == "":
+					cur = piece
+				case len(cur)+1+len(piece) <= maxChars:
+					cur += " " + piece
+				default:
+					chunks = append(chunks, cur)
+					cur = piece
+				}
+			}
+		}
+		if cur != "" {
+			chunks = append(chunks, cur)
+		}
+	}
+	return chunks
+}
+
+// splitSentences splits a single-line paragraph into sentences.
+func splitSentences(para string) []string {
+	var out []string
+	start := 0
+	for i := 0; i < len(para); {
+		r, size := utf8.DecodeRuneInString(para[i:])
+		if !isSentenceTerminator(r) {
+			i += size
+			continue
+		}
+
+		// Absorb runs of terminators ("?!", "...") and closing quotes/brackets.
+		end := i + size
+		for end < len(para) {
+			r2, s2 := utf8.DecodeRuneInString(para[end:])
+			if !isSentenceTerminator(r2) && !isClosingPunct(r2) {
+				break
+			}
+			end += s2
+		}
+
+		if end < len(para) && para[end] != ' ' {
+			i = end // e.g. "3.14", "e.g.", "example.com"
+			continue
+		}
+		if r == '.' && end-i == size && !endsSentence(para[start:i], para[end:]) {
+			i = end
+			continue
+		}
+
+		out = append(out, strings.TrimSpace(para[start:end]))
+		start = end
+		i = end
+	}
+	if rest := strings.TrimSpace(para[start:]); rest != "" {
+		out = append(out, rest)
+	}
+	return out
+}
+
+// endsSentence decides whether a single period between before and after ends
+// a sentence.
+func endsSentence(before, after string) bool {
+	word := before
+	if i := strings.LastIndexAny(before, " \"'(“‘"); i >= 0 {
+		word = before[i+1:]
+	}
+	if abbreviations[strings.ToLower(word)] {
+		return false
+	}
+	// Single-letter initials such as "J. R. R. Tolkien".
+	if r, n := utf8.DecodeRuneInString(word); n == len(word) && unicode.IsUpper(r) {
+		return false
+	}
+	// A lower-case continuation means the period was not a full stop.
+	next, _ := utf8.DecodeRuneInString(strings.TrimLeft(after, " "))
+	return !unicode.IsLower(next)
+}
+
+func isSentenceTerminator(r rune) bool {
+	return r == '.' || r == '!' || r == '?' || r == '…'
+}
+
+func isClosingPunct(r rune) bool {
+	switch r {
+	case '"', '\'', '”', '’', '»', ')', ']':
+		return true
+	}
+	return false
+}
+
+// splitLong splits s into pieces of at most maxChars bytes, preferring clause
+// boundaries, then spaces, and finally hard rune boundaries.
+func splitLong(s string, maxChars int) []string {
+	var out []string
+	for len(s) > maxChars {
+		cut := strings.LastIndexAny(s[:maxChars], ",;:")
+		if cut > 0 {
+			cut++ // keep the punctuation with the left piece
+		} else if cut = strings.LastIndexByte(s[:maxChars], ' '); cut <= 0 {
+			cut = maxChars
+			for cut > 0 && !utf8.RuneStart(s[cut]) {
+				cut--
+			}
+			if cut == 0 { // maxChars is shorter than the first rune
+				_, cut = utf8.DecodeRuneInString(s)
+			}
+		}
+		out = append(out, strings.TrimSpace(s[:cut]))
+		s = strings.TrimSpace(s[cut:])
+	}
+	if s != "" {
+		out = append(out, s)
+	}
+	return out
+}
@@ -0,0 +1,251 @@
+package pockettts
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// ---------------------------------------------------------------------------
+// SplitText
+// ---------------------------------------------------------------------------
+
+func TestSplitText_Sentences(t *testing.T) {
+	cases := []struct {
+		name string
+		in   string
+		want []string
+	}{
+		{
+			"simple",
+			"Hello there. How are you? Fine!",
+			[]string{"Hello there.", "How are you?", "Fine!"},
+		},
+		{
+			"abbreviations",
+			"Dr. Smith met Mr. Jones, e.g. at noon. Then they left.",
+			[]string{"Dr. Smith met Mr. Jones, e.g. at noon.", "Then they left."},
+		},
+		{
+			"numbers",
+			"Pi is 3.14 roughly. It costs $1.50 today.",
+			[]string{"Pi is 3.14 roughly.", "It costs $1.50 today."},
+		},
+		{
+			"quotes",
+			`She said "Stop." Then she left.`,
+			[]string{`She said "Stop."`, "Then she left."},
+		},
+		{
+			"initials",
+			"J. R. R. Tolkien wrote books. They sold well.",
+			[]string{"J. R. R. Tolkien wrote books.", "They sold well."},
+		},
+		{
+			"lowercase continuation",
+			"It was approx. five metres. Done.",
+			[]string{"It was approx. five metres.", "Done."},
+		},
+		{
+			"ellipsis and multiple terminators",
+			"Wait... What?! Really.",
+			[]string{"Wait...", "What?!", "Really."},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := splitSentences(tc.in)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %q\nwant %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSplitText_PacksAndRespectsParagraphs(t *testing.T) {
+	text := "One. Two. Three.\n\nFour.  Five."
+	got := SplitText(text, 11)
+	want := []string{"One. Two.", "Three.", "Four. Five."}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSplitText_LongSentence(t *testing.T) {
+	text := "alpha beta gamma, delta epsilon zeta eta theta iota kappa"
+	got := SplitText(text, 20)
+	for _, c := range got {
+		if len(c) > 20 {
+			t.Errorf("chunk %q exceeds 20 bytes", c)
+		}
+	}
+	if got[0] != "alpha beta gamma," {
+		t.Errorf("expected split at clause boundary, got %q", got)
+	}
+	if strings.Join(got, " ") != text {
+		t.Errorf("chunks do not reassemble the input: %q", got)
+	}
+}
+
+func TestSplitText_MultiByteRunes(t *testing.T) {
+	done := make(chan []string, 1)
+	go func() { done <- SplitText("éééé", 1) }()
+	select {
+	case got := <-done:
+		want := []string{"é", "é", "é", "é"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("SplitText did not return for maxChars shorter than a rune")
+	}
+
+	for _, c := range SplitText("日本語のテキスト", 4) {
+		if !utf8.ValidString(c) || c == "" {
+			t.Errorf("invalid chunk %q", c)
+		}
+	}
+}
+
+func TestSplitText_Empty(t *testing.T) {
+	if got := SplitText(" \n\n\t ", 100); len(got) != 0 {
+		t.Errorf("expected no chunks, got %q", got)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// GenerateLongForm
+// ---------------------------------------------------------------------------
+
+// fakeSynth returns a WAV whose PCM length is proportional to the text length
+// and whose sample values encode the text length.
+type fakeSynth struct {
+	mu       sync.Mutex
+	inFlight int32
+	maxSeen  int32
+	failOn   string
+	delay    time.Duration
+}
+
+func (f *fakeSynth) Synthesize(ctx context.Context, text string, opts *GenerateOptions) (*WAVResult, error) {
+	n := atomic.AddInt32(&f.inFlight, 1)
+	defer atomic.AddInt32(&f.inFlight, -1)
+	f.mu.Lock()
+	if n > f.maxSeen {
+		f.maxSeen = n
+	}
+	f.mu.Unlock()
+
+	select {
+	case <-time.After(f.delay):
+	case <-ctx.Done():
+		return nil, &ErrProcessTimeout{}
+	}
+	if text == f.failOn {
+		return nil, &ErrNonZeroExit{ExitCode: 1, Stderr: "boom"}
+	}
+	pcm := make([]byte, 2*len(text))
+	for i := range pcm {
+		pcm[i] = byte(len(text))
+	}
+	return &WAVResult{
+		Data:          encodeWAV(1000, 1, 16, pcm),
+		SampleRate:    1000,
+		Channels:      1,
+		BitsPerSample: 16,
+		Stats:         GenerationStats{Duration: f.delay},
+	}, nil
+}
+
+func (f *fakeSynth) Health(context.Context) error { return nil }
+func (f *fakeSynth) Close() error                 { return nil }
+
+func TestGenerateLongForm_Stitches(t *testing.T) {
+	s := &fakeSynth{delay: 5 * time.Millisecond}
+	res, err := GenerateLongForm(context.Background(), s, "Hello. World!", &LongFormOptions{
+		MaxChunkChars: 8,
+		Parallelism:   2,
+		Gap:           10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("GenerateLongForm: %v", err)
+	}
+	if len(res.Chunks) != 2 {
+		t.Fatalf("expected 2 chunks, got %d", len(res.Chunks))
+	}
+
+	// "Hello." = 6 chars → 12 bytes → 6 frames → 6ms at 1000 Hz; gap 10ms.
+	if res.Chunks[0].Offset != 0 || res.Chunks[0].AudioDuration != 6*time.Millisecond {
+		t.Errorf("chunk 0: %+v", res.Chunks[0])
+	}
+	if res.Chunks[1].Offset != 16*time.Millisecond {
+		t.Errorf("chunk 1 offset: got %s, want 16ms", res.Chunks[1].Offset)
+	}
+	if res.Chunks[1].Text != "World!" {
+		t.Errorf("chunk 1 text: %q", res.Chunks[1].Text)
+	}
+
+	pcm, err := res.Audio.PCM()
+	if err != nil {
+		t.Fatalf("PCM: %v", err)
+	}
+	if want := 12 + 20 + 12; len(pcm) != want {
+		t.Fatalf("stitched PCM: got %d bytes, want %d", len(pcm), want)
+	}
+	if pcm[0] != 6 || pcm[12] != 0 || pcm[32] != 6 {
+		t.Errorf("unexpected stitched content: %v", pcm)
+	}
+	if res.Audio.SampleRate != 1000 || res.Audio.Channels != 1 || res.Audio.BitsPerSample != 16 {
+		t.Errorf("format: %+v", res.Audio)
+	}
+}
+
+func TestGenerateLongForm_BoundedParallelism(t *testing.T) {
+	s := &fakeSynth{delay: 20 * time.Millisecond}
+	text := strings.Repeat("Sentence here. ", 8)
+	if _, err := GenerateLongForm(context.Background(), s, text, &LongFormOptions{
+		MaxChunkChars: 15,
+		Parallelism:   3,
+	}); err != nil {
+		t.Fatalf("GenerateLongForm: %v", err)
+	}
+	if s.maxSeen > 3 {
+		t.Errorf("parallelism exceeded: %d concurrent calls", s.maxSeen)
+	}
+}
+
+func TestGenerateLongForm_ChunkError(t *testing.T) {
+	s := &fakeSynth{failOn: "Bad."}
+	_, err := GenerateLongForm(context.Background(), s, "Good. Bad. Good.", &LongFormOptions{MaxChunkChars: 5})
+	var exitErr *ErrNonZeroExit
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("expected ErrNonZeroExit, got %T: %v", err, err)
+	}
+}
+
+func TestGenerateLongForm_EmptyText(t *testing.T) {
+	_, err := GenerateLongForm(context.Background(), &fakeSynth{}, "  ", nil)
+	if !errors.Is(err, ErrEmptyText) {
+		t.Errorf("expected ErrEmptyText, got %v", err)
+	}
+}
+
+func TestGenerateLongForm_EncodesStitchedAudio(t *testing.T) {
+	s := &fakeSynth{}
+	res, err := GenerateLongForm(context.Background(), s, "Hello. World!", &LongFormOptions{
+		MaxChunkChars: 8,
+		Generate:      &GenerateOptions{Format: FormatPCM},
+	})
+	if err != nil {
+		t.Fatalf("GenerateLongForm: %v", err)
+	}
+	if res.Audio.Format != FormatPCM || len(res.Audio.Data) != 24 {
+		t.Errorf("expected 24 bytes of raw PCM, got %q with %d bytes", res.Audio.Format, len(res.Audio.Data))
+	}
+}
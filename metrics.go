@@ -0,0 +1,248 @@
+package pockettts
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// MetricsOptions configures a MetricsCollector.
+type MetricsOptions struct {
+	// Namespace prefixes every metric name. Defaults to "pockettts".
+	Namespace string
+
+	// LatencyBuckets are the upper bounds, in seconds, of the latency
+	// histograms (queue wait, time to first byte, duration). Defaults to
+	// 0.01s up to 120s.
+	LatencyBuckets []float64
+
+	// RTFBuckets are the upper bounds of the real-time factor histogram.
+	// Defaults to 0.1 up to 10.
+	RTFBuckets []float64
+}
+
+func (o *MetricsOptions) namespace() string {
+	if o.Namespace != "" {
+		return o.Namespace
+	}
+	return "pockettts"
+}
+
+func (o *MetricsOptions) latencyBuckets() []float64 {
+	if len(o.LatencyBuckets) > 0 {
+		return o.LatencyBuckets
+	}
+	return []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+}
+
+func (o *MetricsOptions) rtfBuckets() []float64 {
+	if len(o.RTFBuckets) > 0 {
+		return o.RTFBuckets
+	}
+	return []float64{0.1, 0.25, 0.5, 0.75, 1, 1.5, 2, 5, 10}
+}
+
+// MetricsCollector is an Observer that aggregates requests into counters
+// and histograms per backend, and serves them in the Prometheus text
+// exposition format as an http.Handler. It needs no Prometheus client
+// library:
+//
+//	m := pockettts.NewMetricsCollector(pockettts.MetricsOptions{})
+//	sc := pockettts.NewServerClient(pockettts.ServerOptions{Observer: m})
+//	http.Handle("/metrics", m)
+//
+// One collector may observe several backends. Create with
+// NewMetricsCollector.
+type MetricsCollector struct {
+	opts MetricsOptions
+
+	mu       sync.Mutex
+	backends map[Mode]*backendMetrics
+}
+
+// backendMetrics holds the metrics of one backend.
+type backendMetrics struct {
+	started      uint64
+	inFlight     int64
+	completed    uint64
+	failed       map[ErrorClass]uint64
+	audioSeconds float64
+	bytes        uint64
+
+	queueWait *histogram
+	ttfb      *histogram
+	duration  *histogram
+	rtf       *histogram
+}
+
+// NewMetricsCollector returns an empty MetricsCollector.
+func NewMetricsCollector(opts MetricsOptions) *MetricsCollector {
+	return &MetricsCollector{opts: opts, backends: make(map[Mode]*backendMetrics)}
+}
+
+// backend returns the metrics of backend, creating them on first use.
+// m.mu must be held.
+func (m *MetricsCollector) backend(backend Mode) *backendMetrics {
+	b := m.backends[backend]
+	if b == nil {
+		latency := m.opts.latencyBuckets()
+		b = &backendMetrics{
+			failed:    make(map[ErrorClass]uint64),
+			queueWait: newHistogram(latency),
+			ttfb:      newHistogram(latency),
+			duration:  newHistogram(latency),
+			rtf:       newHistogram(m.opts.rtfBuckets()),
+		}
+		m.backends[backend] = b
+	}
+	return b
+}
+
+// RequestStarted implements Observer.
+func (m *MetricsCollector) RequestStarted(req RequestInfo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b := m.backend(req.Backend)
+	b.started++
+	b.inFlight++
+}
+
+// RequestAdmitted implements Observer.
+func (m *MetricsCollector) RequestAdmitted(req RequestInfo, queueWait time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.backend(req.Backend).queueWait.observe(queueWait.Seconds())
+}
+
+// RequestCompleted implements Observer.
+func (m *MetricsCollector) RequestCompleted(req RequestInfo, stats GenerationStats) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b := m.backend(req.Backend)
+	b.inFlight--
+	b.completed++
+	b.audioSeconds += stats.AudioDuration.Seconds()
+	b.bytes += uint64(stats.Bytes)
+	b.ttfb.observe(stats.TTFB.Seconds())
+	b.duration.observe(stats.Duration.Seconds())
+	if stats.RealTimeFactor > 0 {
+		b.rtf.observe(stats.RealTimeFactor)
+	}
+}
+
+// RequestFailed implements Observer.
+func (m *MetricsCollector) RequestFailed(req RequestInfo, class ErrorClass, _ error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b := m.backend(req.Backend)
+	b.inFlight--
+	b.failed[class]++
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format.
+func (m *MetricsCollector) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	m.write(bw)
+	_ = bw.Flush()
+}
+
+// write renders all metrics, sorted by backend for a stable output.
+func (m *MetricsCollector) write(w *bufio.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	modes := make([]Mode, 0, len(m.backends))
+	for mode := range m.backends {
+		modes = append(modes, mode)
+	}
+	slices.Sort(modes)
+	ns := m.opts.namespace()
+
+	family := func(name, typ, help string, each func(name string, mode Mode, b *backendMetrics)) {
+		name = ns + "_" + name
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+		for _, mode := range modes {
+			each(name, mode, m.backends[mode])
+		}
+	}
+	value := func(name, labels string, v float64) {
+		fmt.Fprintf(w, "%s{%s} %s\n", name, labels, formatFloat(v))
+	}
+
+	family("requests_started_total", "counter", "Generation attempts started.", func(name string, mode Mode, b *backendMetrics) {
+		value(name, backendLabel(mode), float64(b.started))
+	})
+	family("requests_in_flight", "gauge", "Generation attempts queued or running.", func(name string, mode Mode, b *backendMetrics) {
+		value(name, backendLabel(mode), float64(b.inFlight))
+	})
+	family("requests_completed_total", "counter", "Generation attempts that produced audio.", func(name string, mode Mode, b *backendMetrics) {
+		value(name, backendLabel(mode), float64(b.completed))
+	})
+	family("requests_failed_total", "counter", "Generation attempts that failed, by error class.", func(name string, mode Mode, b *backendMetrics) {
+		classes := make([]ErrorClass, 0, len(b.failed))
+		for class := range b.failed {
+			classes = append(classes, class)
+		}
+		slices.Sort(classes)
+		for _, class := range classes {
+			value(name, backendLabel(mode)+`,class="`+string(class)+`"`, float64(b.failed[class]))
+		}
+	})
+	family("audio_seconds_total", "counter", "Seconds of audio generated.", func(name string, mode Mode, b *backendMetrics) {
+		value(name, backendLabel(mode), b.audioSeconds)
+	})
+	family("audio_bytes_total", "counter", "Bytes of WAV audio generated.", func(name string, mode Mode, b *backendMetrics) {
+		value(name, backendLabel(mode), float64(b.bytes))
+	})
+	family("queue_wait_seconds", "histogram", "Time admitted attempts waited for a concurrency slot.", func(name string, mode Mode, b *backendMetrics) {
+		b.queueWait.write(w, name, backendLabel(mode))
+	})
+	family("ttfb_seconds", "histogram", "Time from sending a request to the first byte of audio.", func(name string, mode Mode, b *backendMetrics) {
+		b.ttfb.write(w, name, backendLabel(mode))
+	})
+	family("duration_seconds", "histogram", "Time from sending a request to the full audio, excluding queue wait.", func(name string, mode Mode, b *backendMetrics) {
+		b.duration.write(w, name, backendLabel(mode))
+	})
+	family("real_time_factor", "histogram", "Generation time per second of audio.", func(name string, mode Mode, b *backendMetrics) {
+		b.rtf.write(w, name, backendLabel(mode))
+	})
+}
+
+func backendLabel(mode Mode) string {
+	return `backend="` + string(mode) + `"`
+}
+
+// histogram is a cumulative Prometheus histogram.
+type histogram struct {
+	bounds []float64 // ascending upper bounds, excluding +Inf
+	counts []uint64  // per bound, not cumulative; the last is for +Inf
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	bounds = slices.Sorted(slices.Values(bounds))
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(v float64) {
+	i, _ := slices.BinarySearch(h.bounds, v)
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) write(w *bufio.Writer, name, labels string) {
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{%s,le=%q} %d\n", name, labels, formatFloat(bound), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+	fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.count)
+}
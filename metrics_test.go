@@ -0,0 +1,219 @@
+package pockettts
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingObserver logs Observer calls as "event backend[ detail]".
+type recordingObserver struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *recordingObserver) add(format string, args ...any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, fmt.Sprintf(format, args...))
+}
+
+func (r *recordingObserver) RequestStarted(req RequestInfo) {
+	r.add("started %s %s", req.Backend, req.Priority)
+}
+
+func (r *recordingObserver) RequestAdmitted(req RequestInfo, _ time.Duration) {
+	r.add("admitted %s", req.Backend)
+}
+
+func (r *recordingObserver) RequestCompleted(req RequestInfo, stats GenerationStats) {
+	r.add("completed %s %d", req.Backend, stats.Bytes)
+}
+
+func (r *recordingObserver) RequestFailed(req RequestInfo, class ErrorClass, _ error) {
+	r.add("failed %s %s", req.Backend, class)
+}
+
+func (r *recordingObserver) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return strings.Join(r.events, "; ")
+}
+
+func TestObserver_Client(t *testing.T) {
+	wav := writeTempFile(t, "out.wav", append(makeWAVHeader(24000, 1, 16), 0, 0))
+	exe := fakeExecutable(t, `cat >/dev/null; case "$*" in *bob*) echo "unknown voice 'bob'" >&2; exit 1;; esac; cat `+wav)
+	var obs recordingObserver
+	c := NewClient(Options{ExecutablePath: exe, Observer: &obs})
+
+	if _, err := c.Synthesize(context.Background(), "hello", &GenerateOptions{Priority: PriorityInteractive}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Synthesize(context.Background(), "hello", &GenerateOptions{Voice: "bob"}); err == nil {
+		t.Fatal("expected the unknown voice to fail")
+	}
+	_, _ = c.Synthesize(context.Background(), " ", nil) // rejected before it starts
+
+	want := "started cli interactive; admitted cli; completed cli 46; " +
+		"started cli normal; admitted cli; failed cli invalid_request"
+	if got := obs.String(); got != want {
+		t.Errorf("events:\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestObserver_QueueTimeoutNotAdmitted(t *testing.T) {
+	var obs recordingObserver
+	c := newClient(&Options{Concurrency: 1, Observer: &obs})
+	_, _, _ = c.slots.acquire(context.Background(), PriorityNormal)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, _ = c.Generate(ctx, "hello")
+	if got, want := obs.String(), "started cli normal; failed cli queue_timeout"; got != want {
+		t.Errorf("events = %s, want %s", got, want)
+	}
+}
+
+func TestObserver_ServerClient(t *testing.T) {
+	fs := newFakeServer(http.StatusOK, http.StatusInternalServerError, []byte("boom"))
+	defer fs.ts.Close()
+	var obs recordingObserver
+	sc := NewServerClient(ServerOptions{BaseURL: fs.ts.URL, Observer: &obs})
+
+	_, _ = sc.Generate(context.Background(), "hello", &ServerGenerateOptions{Priority: PriorityBatch})
+	if got, want := obs.String(), "started server batch; admitted server; failed server backend"; got != want {
+		t.Errorf("events = %s, want %s", got, want)
+	}
+}
+
+func TestMetricsCollector(t *testing.T) {
+	m := NewMetricsCollector(MetricsOptions{LatencyBuckets: []float64{1, 0.1}})
+	cli := RequestInfo{Backend: ModeCLI}
+	server := RequestInfo{Backend: ModeServer}
+
+	m.RequestStarted(cli)
+	m.RequestAdmitted(cli, 50*time.Millisecond)
+	m.RequestCompleted(cli, GenerationStats{
+		Duration: 500 * time.Millisecond, TTFB: 200 * time.Millisecond,
+		AudioDuration: time.Second, RealTimeFactor: 0.5, Bytes: 48044,
+	})
+	m.RequestStarted(server)
+	m.RequestFailed(server, ErrorClassQueueTimeout, nil)
+	m.RequestStarted(server) // still running
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	out := string(body)
+
+	for _, want := range []string{
+		"# TYPE pockettts_requests_started_total counter\n" +
+			`pockettts_requests_started_total{backend="cli"} 1` + "\n" +
+			`pockettts_requests_started_total{backend="server"} 2` + "\n",
+		`pockettts_requests_in_flight{backend="cli"} 0`,
+		`pockettts_requests_in_flight{backend="server"} 1`,
+		`pockettts_requests_completed_total{backend="cli"} 1`,
+		`pockettts_requests_failed_total{backend="server",class="queue_timeout"} 1`,
+		`pockettts_audio_seconds_total{backend="cli"} 1`,
+		`pockettts_audio_bytes_total{backend="cli"} 48044`,
+		"# TYPE pockettts_duration_seconds histogram\n" +
+			`pockettts_duration_seconds_bucket{backend="cli",le="0.1"} 0` + "\n" +
+			`pockettts_duration_seconds_bucket{backend="cli",le="1"} 1` + "\n" +
+			`pockettts_duration_seconds_bucket{backend="cli",le="+Inf"} 1` + "\n" +
+			`pockettts_duration_seconds_sum{backend="cli"} 0.5` + "\n" +
+			`pockettts_duration_seconds_count{backend="cli"} 1` + "\n",
+		`pockettts_queue_wait_seconds_bucket{backend="cli",le="0.1"} 1`,
+		`pockettts_ttfb_seconds_sum{backend="cli"} 0.2`,
+		`pockettts_real_time_factor_bucket{backend="cli",le="0.5"} 1`,
+		`pockettts_real_time_factor_count{backend="server"} 0`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output lacks %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestMetricsCollector_Namespace(t *testing.T) {
+	m := NewMetricsCollector(MetricsOptions{Namespace: "tts"})
+	m.RequestStarted(RequestInfo{Backend: ModeWorker})
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), `tts_requests_started_total{backend="worker"} 1`) {
+		t.Errorf("output:\n%s", rec.Body.String())
+	}
+}
+
+func TestObserver_ClientStream(t *testing.T) {
+	wav := writeTempFile(t, "out.wav", append(makeWAVHeader(24000, 1, 16), 0, 0, 0, 0))
+	exe := fakeExecutable(t, `cat >/dev/null; cat `+wav+`; case "$*" in *bob*) exec sleep 10;; esac`)
+	var obs recordingObserver
+	c := NewClient(Options{ExecutablePath: exe, Observer: &obs})
+
+	stream, err := c.GenerateStream(context.Background(), "hello", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(stream); err != nil {
+		t.Fatal(err)
+	}
+	stream.Close()
+
+	stream, err = c.GenerateStream(context.Background(), "hello", &GenerateOptions{Voice: "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Close() // before EOF
+
+	want := "started cli normal; admitted cli; completed cli 48; " +
+		"started cli normal; admitted cli; failed cli canceled"
+	if got := obs.String(); got != want {
+		t.Errorf("events:\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestObserver_ServerStream(t *testing.T) {
+	fs := newFakeServer(http.StatusOK, http.StatusOK, append(makeWAVHeader(24000, 1, 16), 0, 0))
+	defer fs.ts.Close()
+	var obs recordingObserver
+	sc := NewServerClient(ServerOptions{BaseURL: fs.ts.URL, Observer: &obs})
+
+	stream, err := sc.GenerateStream(context.Background(), "hello", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(stream); err != nil {
+		t.Fatal(err)
+	}
+	stream.Close()
+	if got, want := obs.String(), "started server normal; admitted server; completed server 46"; got != want {
+		t.Errorf("events = %s, want %s", got, want)
+	}
+}
+
+func TestObserver_ServerPoolURLs(t *testing.T) {
+	fs := newFakeServer(http.StatusOK, http.StatusOK, append(makeWAVHeader(24000, 1, 16), 0, 0))
+	defer fs.ts.Close()
+	var obs recordingObserver
+	p := startPool(t, ServerPoolOptions{URLs: []string{fs.ts.URL}, Server: ServerOptions{Observer: &obs}, HealthInterval: time.Hour})
+
+	if _, err := p.Generate(context.Background(), "hello", nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := obs.String(), "started server normal; admitted server; completed server 46"; got != want {
+		t.Errorf("events = %s, want %s", got, want)
+	}
+}
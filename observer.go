@@ -0,0 +1,85 @@
+package pockettts
+
+import "time"
+
+// Observer receives the lifecycle of generation requests, e.g. to export
+// metrics (see MetricsCollector). Set it in Options.Observer (CLI and worker
+// mode) or ServerOptions.Observer.
+//
+// Each attempt of a request is observed separately: RequestStarted, then
+// RequestAdmitted once it holds a concurrency slot, then either
+// RequestCompleted or RequestFailed. An attempt that fails while queued is
+// not admitted. Requests rejected before they start (e.g. ErrEmptyText) are
+// not observed. A stream (GenerateStream) completes when it has been read to
+// the end; one closed before that fails with ErrorClassCanceled.
+//
+// The methods are called synchronously on the request's goroutine, possibly
+// concurrently; they must be safe for concurrent use and must not block.
+type Observer interface {
+	// RequestStarted is called when an attempt begins, before it queues for
+	// a concurrency slot.
+	RequestStarted(req RequestInfo)
+
+	// RequestAdmitted is called when the attempt got its slot, after
+	// waiting queueWait.
+	RequestAdmitted(req RequestInfo, queueWait time.Duration)
+
+	// RequestCompleted is called when the attempt produced audio.
+	RequestCompleted(req RequestInfo, stats GenerationStats)
+
+	// RequestFailed is called when the attempt failed with err, of the given
+	// class (see ClassifyError).
+	RequestFailed(req RequestInfo, class ErrorClass, err error)
+}
+
+// RequestInfo identifies an observed request attempt.
+type RequestInfo struct {
+	// Backend is ModeCLI, ModeWorker or ModeServer.
+	Backend Mode
+
+	// Priority is the request's queue priority.
+	Priority Priority
+}
+
+// observation reports one attempt to an Observer. A nil *observation, for
+// a nil Observer, ignores all calls.
+type observation struct {
+	obs Observer
+	req RequestInfo
+}
+
+// observe reports the start of an attempt to obs, which may be nil.
+func observe(obs Observer, backend Mode, prio Priority) *observation {
+	if obs == nil {
+		return nil
+	}
+	o := &observation{obs: obs, req: RequestInfo{Backend: backend, Priority: prio}}
+	obs.RequestStarted(o.req)
+	return o
+}
+
+func (o *observation) admitted(queueWait time.Duration) {
+	if o != nil {
+		o.obs.RequestAdmitted(o.req, queueWait)
+	}
+}
+
+// done reports the outcome of the attempt.
+func (o *observation) done(res *WAVResult, err error) {
+	var stats GenerationStats
+	if res != nil {
+		stats = res.Stats
+	}
+	o.finished(stats, err)
+}
+
+// finished reports the outcome of the attempt: stats if err is nil.
+func (o *observation) finished(stats GenerationStats, err error) {
+	switch {
+	case o == nil:
+	case err != nil:
+		o.obs.RequestFailed(o.req, ClassifyError(err), err)
+	default:
+		o.obs.RequestCompleted(o.req, stats)
+	}
+}
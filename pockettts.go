@@ -14,6 +14,7 @@ package pockettts
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"time"
 )
@@ -66,22 +67,120 @@ type Options struct {
 	// Concurrency is the maximum number of concurrent pocket-tts subprocesses
 	// allowed by a Client. Zero or negative means unlimited.
 	// Each subprocess loads the model into memory, so keep this low on
-	// memory-constrained machines.
+	// memory-constrained machines. Requests beyond the limit wait in a queue
+	// ordered by GenerateOptions.Priority.
 	Concurrency int
+
+	// QueueAging raises a waiting request's priority by one level for each
+	// QueueAging it has waited, so that batch requests are not starved by a
+	// steady stream of interactive ones. Zero means 10 seconds; negative
+	// disables aging.
+	QueueAging time.Duration
+
+	// Retry retries failed generations (see RetryPolicy). The zero value
+	// disables retries.
+	Retry RetryPolicy
+
+	// Observer, if set, is notified of each generation attempt (see
+	// Observer).
+	Observer Observer
 }
 
 // GenerationStats holds observability data for a single TTS call.
 type GenerationStats struct {
 	// Duration is the wall-clock time from sending the request until the
-	// full WAV was received.
+	// full WAV was received. It excludes QueueWait.
 	Duration time.Duration
+
+	// QueueWait is the time the request waited for a concurrency slot
+	// before it was sent.
+	QueueWait time.Duration
+
+	// SpawnTime is the part of Duration spent starting a process: the
+	// pocket-tts subprocess in ModeCLI, or a new worker (including its model
+	// load) in ModeWorker. It is zero for ModeServer and for requests served
+	// by an already running worker.
+	SpawnTime time.Duration
+
+	// TTFB is the time from sending the request until the first byte of
+	// audio arrived. A worker sends its audio in one piece, so for
+	// ModeWorker it is the time until that piece began.
+	TTFB time.Duration
+
+	// SynthesisTime is Duration minus SpawnTime: the time the backend spent
+	// generating the audio. In ModeCLI it includes loading the model.
+	SynthesisTime time.Duration
+
+	// AudioDuration is the playback length of the generated audio.
+	AudioDuration time.Duration
+
+	// RealTimeFactor is Duration divided by AudioDuration: the wall-clock
+	// seconds spent per second of audio. Below 1 is faster than real time.
+	// Zero if AudioDuration is zero.
+	RealTimeFactor float64
+
+	// Bytes is the size of the WAV the backend produced, before any
+	// GenerateOptions.Format encoding.
+	Bytes int
+
+	// ExitCode is the exit status of the pocket-tts process in ModeCLI, or
+	// the HTTP status of the response in ModeServer.
+	ExitCode int
+
+	// CacheHit is set when a CachedSynthesizer served the result from its
+	// cache; Duration then measures the lookup.
+	CacheHit bool
+
+	// Attempts is the number of attempts the backend made, including the
+	// successful one. It exceeds 1 only when Retry retried a failure.
+	Attempts int
+
+	// Backend is the kind of backend that generated the audio: ModeCLI,
+	// ModeWorker or ModeServer. A Failover reports the backend it routed
+	// the request to.
+	Backend Mode
+}
+
+// measure sets the stats derived from the backend's WAV output: Bytes,
+// SynthesisTime, AudioDuration and RealTimeFactor. Duration and SpawnTime
+// must already be set.
+func (s *GenerationStats) measure(wavBytes []byte) {
+	s.Bytes = len(wavBytes)
+	if w, err := decodeWAVData(wavBytes); err == nil {
+		s.AudioDuration = pcmDuration(len(w.pcm), w.sampleRate, w.channels, w.bitsPerSample)
+	}
+	s.derive()
+}
+
+// derive sets SynthesisTime and RealTimeFactor from Duration, SpawnTime and
+// AudioDuration.
+func (s *GenerationStats) derive() {
+	s.SynthesisTime = s.Duration - s.SpawnTime
+	if s.AudioDuration > 0 {
+		s.RealTimeFactor = s.Duration.Seconds() / s.AudioDuration.Seconds()
+	}
+}
+
+// log writes the summary line of a generation to w, if w is non-nil.
+func (s *GenerationStats) log(w io.Writer) {
+	if w == nil {
+		return
+	}
+	ms := func(d time.Duration) time.Duration { return d.Round(time.Millisecond) }
+	fmt.Fprintf(w, "pockettts: generated %d bytes in %s (mode=%s, queue=%s, spawn=%s, ttfb=%s, synthesis=%s, audio=%s, rtf=%.2f, exit=%d)\n",
+		s.Bytes, ms(s.Duration), s.Backend, ms(s.QueueWait), ms(s.SpawnTime), ms(s.TTFB),
+		ms(s.SynthesisTime), ms(s.AudioDuration), s.RealTimeFactor, s.ExitCode)
 }
 
 // WAVResult holds the generated audio together with basic metadata.
 type WAVResult struct {
-	// Data contains the raw WAV file bytes (including RIFF header).
+	// Data contains the raw WAV file bytes (including RIFF header), or the
+	// encoded audio if GenerateOptions.Format selected another format.
 	Data []byte
 
+	// Format is the encoding of Data. Empty means FormatWAV.
+	Format AudioFormat
+
 	// SampleRate is parsed from the WAV header. Pocket-tts produces 24000 Hz.
 	SampleRate uint32
 
@@ -95,6 +194,69 @@ type WAVResult struct {
 	Stats GenerationStats
 }
 
+// MIMEType returns the media type of Data. Headerless formats carry their
+// sample rate and channel count as parameters, e.g. "audio/pcm;rate=24000;channels=1".
+func (r *WAVResult) MIMEType() string {
+	mime := r.Format.MIMEType()
+	switch r.Format {
+	case FormatPCM, FormatMuLaw, FormatALaw:
+		mime = fmt.Sprintf("%s;rate=%d;channels=%d", mime, r.SampleRate, r.Channels)
+	}
+	return mime
+}
+
+// PCM returns the contents of the WAV data chunk: interleaved little-endian
+// samples without the header. The returned slice aliases Data.
+//
+// Returns an error wrapping ErrMalformedWAV if the data chunk is truncated or
+// does not hold a whole number of frames.
+func (r *WAVResult) PCM() ([]byte, error) {
+	w, err := decodeWAVData(r.Data)
+	if err != nil {
+		return nil, err
+	}
+	return w.pcm, nil
+}
+
+// Frames returns the number of sample frames (samples per channel).
+func (r *WAVResult) Frames() (int, error) {
+	w, err := decodeWAVData(r.Data)
+	if err != nil {
+		return 0, err
+	}
+	return len(w.pcm) / w.blockAlign(), nil
+}
+
+// AudioDuration returns the playback length of the audio. It is not to be
+// confused with Stats.Duration, the wall-clock time spent generating it.
+func (r *WAVResult) AudioDuration() (time.Duration, error) {
+	w, err := decodeWAVData(r.Data)
+	if err != nil {
+		return 0, err
+	}
+	return pcmDuration(len(w.pcm), w.sampleRate, w.channels, w.bitsPerSample), nil
+}
+
+// Int16Samples decodes the audio into interleaved 16-bit samples. Other
+// sample formats are converted (and clipped where necessary).
+func (r *WAVResult) Int16Samples() ([]int16, error) {
+	w, err := decodeWAVData(r.Data)
+	if err != nil {
+		return nil, err
+	}
+	return w.int16Samples()
+}
+
+// Float32Samples decodes the audio into interleaved samples normalized to
+// [-1, 1].
+func (r *WAVResult) Float32Samples() ([]float32, error) {
+	w, err := decodeWAVData(r.Data)
+	if err != nil {
+		return nil, err
+	}
+	return w.float32Samples()
+}
+
 // Generate calls `pocket-tts generate --text - --output-path -` with the
 // provided text and returns the resulting WAV audio.
 //
@@ -103,20 +265,21 @@ type WAVResult struct {
 //
 // Returns ErrEmptyText if text is empty or whitespace-only.
 // Returns ErrExecutableNotFound if the pocket-tts binary cannot be located.
-// Returns ErrProcessTimeout if the context deadline is exceeded.
+// Returns ErrQueueTimeout or ErrProcessTimeout if the context deadline is
+// exceeded before or during synthesis, and ErrCanceled if ctx is cancelled.
 func Generate(ctx context.Context, text string, opts *Options) (*WAVResult, error) {
 	if opts == nil {
 		opts = &Options{}
 	}
 	c := newClient(opts)
-	return c.generate(ctx, text)
+	return c.generate(ctx, text, nil)
 }
 
 // Client wraps shared configuration so you can reuse it across calls.
 // Use NewClient instead of constructing directly.
 type Client struct {
-	opts Options
-	sem  chan struct{} // nil means unlimited
+	opts  Options
+	slots *limiter // bounds subprocesses per Options.Concurrency
 }
 
 // NewClient creates a reusable client with the given options.
@@ -126,9 +289,33 @@ func NewClient(opts Options) *Client {
 }
 
 // Generate is the same as the package-level Generate but uses the Client's
-// shared options (merged with per-call opts when you extend this later).
+// shared options. Use GenerateWithOptions to override them per call.
 func (c *Client) Generate(ctx context.Context, text string) (*WAVResult, error) {
-	return c.generate(ctx, text)
+	return c.generate(ctx, text, nil)
+}
+
+// GenerateWithOptions is like Generate, but non-zero fields in opts override
+// the Client's Options for this call only. The Client's Concurrency limit
+// still applies. opts may be nil.
+func (c *Client) GenerateWithOptions(ctx context.Context, text string, opts *GenerateOptions) (*WAVResult, error) {
+	return c.generate(ctx, text, opts)
+}
+
+// Synthesize implements Synthesizer; it is equivalent to GenerateWithOptions.
+func (c *Client) Synthesize(ctx context.Context, text string, opts *GenerateOptions) (*WAVResult, error) {
+	return c.generate(ctx, text, opts)
+}
+
+// Health implements Synthesizer by checking that the pocket-tts executable is
+// resolvable (see Preflight).
+func (c *Client) Health(ctx context.Context) error {
+	return preflight(c.opts.ExecutablePath)
+}
+
+// Close implements Synthesizer. A Client holds no long-lived resources, so
+// Close is a no-op.
+func (c *Client) Close() error {
+	return nil
 }
 
 // Preflight checks that the pocket-tts executable is resolvable.
@@ -54,7 +54,7 @@ func TestParseWAVHeader_NotWAV(t *testing.T) {
 
 func TestBuildArgs_Defaults(t *testing.T) {
 	c := newClient(&Options{})
-	args := c.buildArgs()
+	args := c.buildArgs(nil)
 	mustContain(t, args, "generate")
 	mustContain(t, args, "--text")
 	mustContain(t, args, "-")
@@ -73,7 +73,7 @@ func TestBuildArgs_AllFlags(t *testing.T) {
 		MaxTokens:      512,
 		Quiet:          true,
 	})
-	args := c.buildArgs()
+	args := c.buildArgs(nil)
 	pairMustExist(t, args, "--voice", "mimi")
 	pairMustExist(t, args, "--config", "/tmp/cfg.json")
 	pairMustExist(t, args, "--temperature", "0.8")
@@ -87,7 +87,7 @@ func TestBuildArgs_AllFlags(t *testing.T) {
 
 func TestBuildArgs_ZeroValuesOmitted(t *testing.T) {
 	c := newClient(&Options{})
-	args := c.buildArgs()
+	args := c.buildArgs(nil)
 	for _, flag := range []string{
 		"--voice", "--config", "--temperature", "--lsd-decode-steps",
 		"--noise-clamp", "--eos-threshold", "--frames-after-eos",
@@ -101,6 +101,34 @@ func TestBuildArgs_ZeroValuesOmitted(t *testing.T) {
 	}
 }
 
+func TestBuildArgs_PerCallOverrides(t *testing.T) {
+	c := newClient(&Options{
+		Voice:       "alba",
+		Config:      "/tmp/cfg.json",
+		Temperature: 0.7,
+		MaxTokens:   256,
+		Quiet:       true,
+	})
+	args := c.buildArgs(&GenerateOptions{
+		Voice:          "marius",
+		Temperature:    0.3,
+		LSDDecodeSteps: 8,
+		MaxTokens:      1024,
+	})
+	pairMustExist(t, args, "--voice", "marius")
+	pairMustExist(t, args, "--temperature", "0.3")
+	pairMustExist(t, args, "--lsd-decode-steps", "8")
+	pairMustExist(t, args, "--max-tokens", "1024")
+	// Fields not overridden keep the client's values.
+	pairMustExist(t, args, "--config", "/tmp/cfg.json")
+	mustContain(t, args, "--quiet")
+
+	// The client's own options must not be mutated by an override.
+	if c.opts.Voice != "alba" || c.opts.Temperature != 0.7 || c.opts.MaxTokens != 256 {
+		t.Errorf("client options mutated: %+v", c.opts)
+	}
+}
+
 // ---------------------------------------------------------------------------
 // Input validation
 // ---------------------------------------------------------------------------
@@ -146,10 +174,10 @@ func TestPreflight_Found(t *testing.T) {
 // ---------------------------------------------------------------------------
 
 func TestConcurrencyLimiter_ContextCancelled(t *testing.T) {
-	// Fill the semaphore completely, then check that a new call respects ctx cancellation.
+	// Fill the limiter completely, then check that a new call respects ctx cancellation.
 	c := newClient(&Options{Concurrency: 1})
 	// Hold the slot manually.
-	c.sem <- struct{}{}
+	_, _, _ = c.slots.acquire(context.Background(), PriorityNormal)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
 	defer cancel()
@@ -158,10 +186,27 @@ func TestConcurrencyLimiter_ContextCancelled(t *testing.T) {
 	if err == nil {
 		t.Fatal("expected error when concurrency slot is full and ctx times out")
 	}
-	// Must be a timeout error
-	var tErr *ErrProcessTimeout
-	if !errors.As(err, &tErr) {
-		t.Errorf("expected ErrProcessTimeout, got %T: %v", err, tErr)
+	// Must be a queue timeout, not a synthesis timeout
+	var qErr *ErrQueueTimeout
+	if !errors.As(err, &qErr) {
+		t.Errorf("expected ErrQueueTimeout, got %T: %v", err, err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("ErrQueueTimeout does not unwrap to context.DeadlineExceeded")
+	}
+}
+
+func TestConcurrencyLimiter_AppliesToPerCallOptions(t *testing.T) {
+	c := newClient(&Options{Concurrency: 1})
+	_, _, _ = c.slots.acquire(context.Background(), PriorityNormal)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := c.GenerateWithOptions(ctx, "hello", &GenerateOptions{Voice: "marius"})
+	var qErr *ErrQueueTimeout
+	if !errors.As(err, &qErr) {
+		t.Errorf("expected ErrQueueTimeout, got %T: %v", err, err)
 	}
 }
 
@@ -225,7 +270,55 @@ func TestRunner_Timeout(t *testing.T) {
 	}
 	var tErr *ErrProcessTimeout
 	if !errors.As(runErr, &tErr) {
-		t.Errorf("expected ErrProcessTimeout, got %T: %v", runErr, runErr)
+		t.Fatalf("expected ErrProcessTimeout, got %T: %v", runErr, runErr)
+	}
+	if !errors.Is(runErr, context.DeadlineExceeded) {
+		t.Error("ErrProcessTimeout does not unwrap to context.DeadlineExceeded")
+	}
+	if tErr.Elapsed < 100*time.Millisecond {
+		t.Errorf("Elapsed = %s, want >= 100ms", tErr.Elapsed)
+	}
+}
+
+func TestRunner_Cancel(t *testing.T) {
+	sleepPath, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skip("'sleep' not found on PATH")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	r := &runner{executablePath: sleepPath}
+	_, runErr := r.run(ctx, []string{"10"}, nil)
+	var cErr *ErrCanceled
+	if !errors.As(runErr, &cErr) {
+		t.Fatalf("expected ErrCanceled, got %T: %v", runErr, runErr)
+	}
+	if !errors.Is(runErr, context.Canceled) || errors.Is(runErr, context.DeadlineExceeded) {
+		t.Errorf("ErrCanceled unwraps to %v, want context.Canceled", cErr.Err)
+	}
+	if cErr.Queued || cErr.Elapsed < 50*time.Millisecond {
+		t.Errorf("ErrCanceled = %+v, want a synthesis cancel after >= 50ms", cErr)
+	}
+	var tErr *ErrProcessTimeout
+	if errors.As(runErr, &tErr) {
+		t.Error("cancellation is reported as ErrProcessTimeout")
+	}
+}
+
+func TestClient_CanceledWhileQueued(t *testing.T) {
+	c := newClient(&Options{Concurrency: 1})
+	_, _, _ = c.slots.acquire(context.Background(), PriorityNormal)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(30*time.Millisecond, cancel)
+	_, err := c.Generate(ctx, "hello")
+	var cErr *ErrCanceled
+	if !errors.As(err, &cErr) || !cErr.Queued || !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected a queued ErrCanceled, got %T: %v", err, err)
+	}
+	if cErr.Elapsed < 30*time.Millisecond {
+		t.Errorf("Elapsed = %s, want >= 30ms", cErr.Elapsed)
 	}
 }
 
@@ -250,6 +343,60 @@ func TestRunner_StderrForwarded(t *testing.T) {
 	}
 }
 
+// ---------------------------------------------------------------------------
+// Generation stats
+// ---------------------------------------------------------------------------
+
+func TestClient_GenerationStats(t *testing.T) {
+	// One second of 24 kHz mono 16-bit audio, written after a delay.
+	wav := append(makeWAVHeader(24000, 1, 16), make([]byte, 48000)...)
+	exe := fakeExecutable(t, "cat >/dev/null; sleep 0.05; cat "+writeTempFile(t, "out.wav", wav))
+	var logBuf bytes.Buffer
+	c := NewClient(Options{ExecutablePath: exe, LogWriter: &logBuf})
+
+	res, err := c.Generate(context.Background(), "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := res.Stats
+	if st.Backend != ModeCLI || st.ExitCode != 0 || st.Bytes != len(wav) {
+		t.Errorf("Backend %q, ExitCode %d, Bytes %d; want cli, 0, %d", st.Backend, st.ExitCode, st.Bytes, len(wav))
+	}
+	if st.AudioDuration != time.Second {
+		t.Errorf("AudioDuration = %s, want 1s", st.AudioDuration)
+	}
+	if st.SpawnTime <= 0 || st.TTFB < 50*time.Millisecond || st.TTFB > st.Duration {
+		t.Errorf("SpawnTime %s, TTFB %s, Duration %s; want 0 < SpawnTime, 50ms <= TTFB <= Duration",
+			st.SpawnTime, st.TTFB, st.Duration)
+	}
+	if st.SynthesisTime != st.Duration-st.SpawnTime {
+		t.Errorf("SynthesisTime = %s, want Duration - SpawnTime", st.SynthesisTime)
+	}
+	if want := st.Duration.Seconds(); st.RealTimeFactor != want {
+		t.Errorf("RealTimeFactor = %v, want %v", st.RealTimeFactor, want)
+	}
+	for _, want := range []string{"generated 48044 bytes", "mode=cli", "audio=1s", "exit=0"} {
+		if !strings.Contains(logBuf.String(), want) {
+			t.Errorf("log line %q lacks %q", logBuf.String(), want)
+		}
+	}
+}
+
+func TestClient_GenerationStats_QueueWait(t *testing.T) {
+	wav := writeTempFile(t, "out.wav", append(makeWAVHeader(24000, 1, 16), 0, 0))
+	c := NewClient(Options{ExecutablePath: fakeExecutable(t, "cat >/dev/null; cat "+wav), Concurrency: 1})
+
+	hold, _, _ := c.acquire(context.Background(), PriorityNormal)
+	time.AfterFunc(30*time.Millisecond, hold)
+	res, err := c.Generate(context.Background(), "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Stats.QueueWait < 30*time.Millisecond {
+		t.Errorf("QueueWait = %s, want >= 30ms", res.Stats.QueueWait)
+	}
+}
+
 // ---------------------------------------------------------------------------
 // Golden test: runs only when pocket-tts is available
 // ---------------------------------------------------------------------------
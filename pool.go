@@ -0,0 +1,403 @@
+package pockettts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ServerPoolOptions configures a ServerPool.
+type ServerPoolOptions struct {
+	// Size is the number of managed `pocket-tts serve` processes to launch.
+	// Member i listens on Server.Port+i (default port 8000). Ignored when
+	// URLs is set.
+	Size int
+
+	// Server is the template for managed members. Its Port is the first
+	// member's port, unless AutoPort gives each member a free port. Its HTTP
+	// settings (HTTPClient, Transport, TLSConfig, Header, BeforeRequest),
+	// queueing (Concurrency, MaxQueue, QueueTimeout, QueueAging), Retry and
+	// Observer also apply to the members in URLs, each on its own.
+	Server ServerOptions
+
+	// URLs lists externally managed servers (e.g. "http://tts-1:8000" or
+	// "https://tts.example.com/pocket") to balance across instead of
+	// launching processes. The pool never starts, stops or restarts
+	// external members.
+	URLs []string
+
+	// HealthInterval is the time between health checks of every member.
+	// Defaults to 5 seconds.
+	HealthInterval time.Duration
+
+	// RestartAfter is the number of consecutive failed health checks after
+	// which a managed member is restarted. Defaults to 3; negative disables
+	// restarts. Members with Server.Supervise set are only taken out of
+	// rotation: their own supervisor restarts them within its restart budget.
+	RestartAfter int
+}
+
+func (o *ServerPoolOptions) healthInterval() time.Duration {
+	if o.HealthInterval <= 0 {
+		return 5 * time.Second
+	}
+	return o.HealthInterval
+}
+
+func (o *ServerPoolOptions) restartAfter() int {
+	if o.RestartAfter == 0 {
+		return 3
+	}
+	return o.RestartAfter
+}
+
+// ServerPool balances TTS requests across several pocket-tts servers.
+//
+// Each request goes to the healthy member with the fewest outstanding
+// requests. Members are health-checked every HealthInterval; a member that
+// fails a check, or whose connection fails during a request, is taken out of
+// rotation until a later check succeeds. Managed members that stay unhealthy
+// are restarted.
+//
+// Create with NewServerPool, call Start, and Close when done.
+type ServerPool struct {
+	opts    ServerPoolOptions
+	members []*poolMember
+	next    atomic.Uint64 // rotates the tie-break between idle members
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// poolMember is one server of a ServerPool.
+type poolMember struct {
+	client  *ServerClient
+	url     string // external members only; see baseURL
+	managed bool
+
+	inFlight   atomic.Int64
+	healthy    atomic.Bool
+	restarting atomic.Bool
+	requests   atomic.Int64
+	failures   atomic.Int64
+	restarts   atomic.Int64
+	badChecks  int        // consecutive failed health checks; health loop only
+	lifecycle  sync.Mutex // serializes Start, restart and Stop
+}
+
+// baseURL returns the member's URL. A managed member's port is only known
+// once it has started if Server.AutoPort is set.
+func (m *poolMember) baseURL() string {
+	if m.managed {
+		return m.client.BaseURL()
+	}
+	return m.url
+}
+
+// PoolStats is a snapshot of a ServerPool's members.
+type PoolStats struct {
+	Members []MemberStats
+}
+
+// MemberStats describes one member of a ServerPool.
+type MemberStats struct {
+	// URL is the member's base URL.
+	URL string
+
+	// Managed is true if the pool launched the member's process.
+	Managed bool
+
+	// Healthy reports whether the member is in rotation.
+	Healthy bool
+
+	// InFlight is the number of requests currently sent to the member.
+	InFlight int
+
+	// Requests is the total number of requests sent to the member.
+	Requests int64
+
+	// Failures is the number of those requests that returned an error.
+	Failures int64
+
+	// Restarts is the number of times the pool restarted the member.
+	Restarts int64
+}
+
+// NewServerPool creates a pool from opts. Call Start to launch managed
+// members and begin health checking.
+func NewServerPool(opts ServerPoolOptions) (*ServerPool, error) {
+	p := &ServerPool{opts: opts}
+	if len(opts.URLs) > 0 {
+		for _, raw := range opts.URLs {
+			so, err := serverOptionsForURL(raw, opts.Server)
+			if err != nil {
+				return nil, err
+			}
+			p.members = append(p.members, &poolMember{client: NewServerClient(so), url: raw})
+		}
+		return p, nil
+	}
+
+	if opts.Size <= 0 {
+		return nil, errors.New("pockettts: server pool needs Size > 0 or URLs")
+	}
+	for i := 0; i < opts.Size; i++ {
+		so := opts.Server
+		so.Port = opts.Server.port() + i
+		p.members = append(p.members, &poolMember{client: NewServerClient(so), managed: true})
+	}
+	return p, nil
+}
+
+// serverOptionsForURL converts an external server's base URL into
+// ServerOptions, keeping the HTTP, queueing, retry and Observer settings of
+// tmpl. Process settings do not apply to external servers.
+func serverOptionsForURL(raw string, tmpl ServerOptions) (ServerOptions, error) {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ServerOptions{}, fmt.Errorf("pockettts: invalid server URL %q (want http[s]://host[:port][/prefix])", raw)
+	}
+	return ServerOptions{
+		BaseURL:       raw,
+		HTTPClient:    tmpl.HTTPClient,
+		Transport:     tmpl.Transport,
+		TLSConfig:     tmpl.TLSConfig,
+		Header:        tmpl.Header,
+		BeforeRequest: tmpl.BeforeRequest,
+		Concurrency:   tmpl.Concurrency,
+		MaxQueue:      tmpl.MaxQueue,
+		QueueTimeout:  tmpl.QueueTimeout,
+		QueueAging:    tmpl.QueueAging,
+		Retry:         tmpl.Retry,
+		Observer:      tmpl.Observer,
+	}, nil
+}
+
+// Start launches the managed members in parallel and waits until all are
+// healthy, then starts the background health checks. If any member fails to
+// start, the others are stopped and the errors are returned. External
+// members are checked once; unhealthy ones stay out of rotation until they
+// recover.
+func (p *ServerPool) Start(ctx context.Context) error {
+	errs := make([]error, len(p.members))
+	var wg sync.WaitGroup
+	for i, m := range p.members {
+		wg.Add(1)
+		go func(i int, m *poolMember) {
+			defer wg.Done()
+			if !m.managed {
+				m.healthy.Store(m.client.Health(ctx) == nil)
+				return
+			}
+			m.lifecycle.Lock()
+			defer m.lifecycle.Unlock()
+			if err := m.client.Start(ctx); err != nil {
+				errs[i] = fmt.Errorf("pockettts: pool member %s: %w", m.baseURL(), err)
+				return
+			}
+			m.healthy.Store(true)
+		}(i, m)
+	}
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		p.stopMembers()
+		return err
+	}
+
+	loopCtx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	p.wg.Add(1)
+	go p.healthLoop(loopCtx)
+	return nil
+}
+
+// Close stops health checking and the managed members.
+func (p *ServerPool) Close() error {
+	if p.cancel != nil {
+		p.cancel()
+	}
+	p.wg.Wait()
+	return p.stopMembers()
+}
+
+func (p *ServerPool) stopMembers() error {
+	var errs []error
+	for _, m := range p.members {
+		if !m.managed {
+			continue
+		}
+		m.lifecycle.Lock()
+		errs = append(errs, m.client.Stop())
+		m.lifecycle.Unlock()
+		m.healthy.Store(false)
+	}
+	return errors.Join(errs...)
+}
+
+// Generate sends the request to the least-loaded healthy member.
+func (p *ServerPool) Generate(ctx context.Context, text string, opts *ServerGenerateOptions) (*WAVResult, error) {
+	return p.do(ctx, func(s *ServerClient) (*WAVResult, error) {
+		return s.Generate(ctx, text, opts)
+	})
+}
+
+// Synthesize implements Synthesizer; see ServerClient.Synthesize.
+func (p *ServerPool) Synthesize(ctx context.Context, text string, opts *GenerateOptions) (*WAVResult, error) {
+	return p.do(ctx, func(s *ServerClient) (*WAVResult, error) {
+		return s.Synthesize(ctx, text, opts)
+	})
+}
+
+// Health implements Synthesizer. It returns nil if at least one member is
+// healthy, checking them now rather than relying on the last health check.
+func (p *ServerPool) Health(ctx context.Context) error {
+	var errs []error
+	for _, m := range p.members {
+		err := m.client.Health(ctx)
+		m.healthy.Store(err == nil)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err)
+	}
+	return fmt.Errorf("%w: %w", ErrNoHealthyServer, errors.Join(errs...))
+}
+
+// Stats returns a snapshot of the pool's members.
+func (p *ServerPool) Stats() PoolStats {
+	st := PoolStats{Members: make([]MemberStats, len(p.members))}
+	for i, m := range p.members {
+		st.Members[i] = MemberStats{
+			URL:      m.baseURL(),
+			Managed:  m.managed,
+			Healthy:  m.healthy.Load(),
+			InFlight: int(m.inFlight.Load()),
+			Requests: m.requests.Load(),
+			Failures: m.failures.Load(),
+			Restarts: m.restarts.Load(),
+		}
+	}
+	return st
+}
+
+// synthesisParams resolves opts like the pool's members do, so that a
+// CachedSynthesizer shares entries across them.
+func (p *ServerPool) synthesisParams(opts *GenerateOptions) synthesisParams {
+	return p.members[0].client.synthesisParams(opts)
+}
+
+// do runs fn against the selected member, tracking its load and failures.
+func (p *ServerPool) do(ctx context.Context, fn func(*ServerClient) (*WAVResult, error)) (*WAVResult, error) {
+	m := p.pick()
+	if m == nil {
+		return nil, ErrNoHealthyServer
+	}
+	defer m.inFlight.Add(-1)
+	m.requests.Add(1)
+
+	res, err := fn(m.client)
+	if err != nil && !errors.Is(err, ErrEmptyText) {
+		m.failures.Add(1)
+		// A connection failure means the member is down, not that the
+		// request was bad: take it out of rotation until it recovers.
+		var uerr *url.Error
+		var nerr net.Error
+		if ctx.Err() == nil && (errors.As(err, &uerr) || errors.As(err, &nerr)) {
+			m.healthy.Store(false)
+		}
+	}
+	return res, err
+}
+
+// pick reserves a slot on the healthy member with the fewest outstanding
+// requests, rotating between equally loaded members. It returns nil if no
+// member is healthy.
+func (p *ServerPool) pick() *poolMember {
+	start := int(p.next.Add(1))
+	var best *poolMember
+	var bestLoad int64
+	for i := range p.members {
+		m := p.members[(start+i)%len(p.members)]
+		if !m.healthy.Load() {
+			continue
+		}
+		if load := m.inFlight.Load(); best == nil || load < bestLoad {
+			best, bestLoad = m, load
+		}
+	}
+	if best != nil {
+		best.inFlight.Add(1)
+	}
+	return best
+}
+
+// healthLoop checks every member each HealthInterval until ctx is done.
+func (p *ServerPool) healthLoop(ctx context.Context) {
+	defer p.wg.Done()
+	ticker := time.NewTicker(p.opts.healthInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, m := range p.members {
+			p.check(ctx, m)
+		}
+	}
+}
+
+// check health-checks m and restarts it once it has failed RestartAfter
+// consecutive checks.
+func (p *ServerPool) check(ctx context.Context, m *poolMember) {
+	if m.restarting.Load() {
+		return
+	}
+	checkCtx, cancel := context.WithTimeout(ctx, p.opts.healthInterval())
+	err := m.client.Health(checkCtx)
+	cancel()
+	if ctx.Err() != nil {
+		return
+	}
+	if err == nil {
+		m.badChecks = 0
+		m.healthy.Store(true)
+		return
+	}
+
+	m.healthy.Store(false)
+	m.badChecks++
+	if !m.managed || m.client.opts.Supervise || p.opts.restartAfter() < 0 || m.badChecks < p.opts.restartAfter() {
+		return
+	}
+
+	m.badChecks = 0
+	m.restarting.Store(true)
+	m.restarts.Add(1)
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer m.restarting.Store(false)
+		m.lifecycle.Lock()
+		defer m.lifecycle.Unlock()
+		if ctx.Err() != nil {
+			return
+		}
+		if err := m.client.restart(ctx); err == nil {
+			m.healthy.Store(true)
+		}
+	}()
+}
+
+// restart stops the managed server process, if any, and starts a new one.
+func (s *ServerClient) restart(ctx context.Context) error {
+	_ = s.Stop()
+	return s.Start(ctx)
+}
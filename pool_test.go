@@ -0,0 +1,294 @@
+package pockettts
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// poolTestServer is a fake pocket-tts server whose health can be toggled and
+// whose /tts handler can be held open.
+type poolTestServer struct {
+	ts      *httptest.Server
+	healthy atomic.Bool
+	tts     atomic.Int32
+	hold    chan struct{} // if non-nil, /tts waits for it to close
+}
+
+func newPoolTestServer(t *testing.T, hold chan struct{}) *poolTestServer {
+	t.Helper()
+	s := &poolTestServer{hold: hold}
+	s.healthy.Store(true)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		if !s.healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	mux.HandleFunc("/tts", func(w http.ResponseWriter, r *http.Request) {
+		s.tts.Add(1)
+		if s.hold != nil {
+			<-s.hold
+		}
+		_, _ = w.Write(makeWAVHeader(24000, 1, 16))
+	})
+	s.ts = httptest.NewServer(mux)
+	t.Cleanup(s.ts.Close)
+	return s
+}
+
+func startPool(t *testing.T, opts ServerPoolOptions) *ServerPool {
+	t.Helper()
+	p, err := NewServerPool(opts)
+	if err != nil {
+		t.Fatalf("NewServerPool: %v", err)
+	}
+	for _, m := range p.members {
+		m.client.checkPort = standInServer
+	}
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(func() { _ = p.Close() })
+	return p
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Balancing
+// ---------------------------------------------------------------------------
+
+func TestServerPool_LeastOutstanding(t *testing.T) {
+	hold := make(chan struct{})
+	a, b := newPoolTestServer(t, hold), newPoolTestServer(t, hold)
+	p := startPool(t, ServerPoolOptions{URLs: []string{a.ts.URL, b.ts.URL}, HealthInterval: time.Hour})
+
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := p.Generate(context.Background(), "Hello", nil)
+			done <- err
+		}()
+		waitFor(t, "request to arrive", func() bool { return a.tts.Load()+b.tts.Load() == int32(i+1) })
+	}
+	if a.tts.Load() != 1 || b.tts.Load() != 1 {
+		t.Errorf("expected one request per member, got %d and %d", a.tts.Load(), b.tts.Load())
+	}
+	for _, m := range p.Stats().Members {
+		if m.InFlight != 1 {
+			t.Errorf("%s: in-flight %d, want 1", m.URL, m.InFlight)
+		}
+	}
+
+	close(hold)
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Errorf("Generate: %v", err)
+		}
+	}
+	for _, m := range p.Stats().Members {
+		if m.InFlight != 0 || m.Requests != 1 || m.Failures != 0 {
+			t.Errorf("%s: unexpected stats %+v", m.URL, m)
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Health and rotation
+// ---------------------------------------------------------------------------
+
+func TestServerPool_UnhealthyOutOfRotation(t *testing.T) {
+	a, b := newPoolTestServer(t, nil), newPoolTestServer(t, nil)
+	b.healthy.Store(false)
+	p := startPool(t, ServerPoolOptions{URLs: []string{a.ts.URL, b.ts.URL}, HealthInterval: 10 * time.Millisecond})
+
+	for i := 0; i < 4; i++ {
+		if _, err := p.Synthesize(context.Background(), "Hello", nil); err != nil {
+			t.Fatalf("Synthesize: %v", err)
+		}
+	}
+	if b.tts.Load() != 0 || a.tts.Load() != 4 {
+		t.Errorf("unhealthy member received requests: a=%d b=%d", a.tts.Load(), b.tts.Load())
+	}
+
+	// b recovers and rejoins the rotation.
+	b.healthy.Store(true)
+	waitFor(t, "b to become healthy", func() bool { return p.Stats().Members[1].Healthy })
+	for i := 0; i < 4; i++ {
+		_, _ = p.Synthesize(context.Background(), "Hello", nil)
+	}
+	if b.tts.Load() == 0 {
+		t.Error("recovered member received no requests")
+	}
+}
+
+func TestServerPool_ConnectionFailure(t *testing.T) {
+	a, b := newPoolTestServer(t, nil), newPoolTestServer(t, nil)
+	p := startPool(t, ServerPoolOptions{URLs: []string{a.ts.URL, b.ts.URL}, HealthInterval: time.Hour})
+
+	a.ts.Close()
+	var failures int
+	for i := 0; i < 4; i++ {
+		if _, err := p.Generate(context.Background(), "Hello", nil); err != nil {
+			failures++
+		}
+	}
+	if failures > 1 {
+		t.Errorf("expected at most one failed request before the dead member left rotation, got %d", failures)
+	}
+	st := p.Stats().Members[0]
+	if st.Healthy || st.Failures != int64(failures) {
+		t.Errorf("dead member stats: %+v", st)
+	}
+}
+
+func TestServerPool_NoHealthyServer(t *testing.T) {
+	a := newPoolTestServer(t, nil)
+	a.healthy.Store(false)
+	p := startPool(t, ServerPoolOptions{URLs: []string{a.ts.URL}, HealthInterval: time.Hour})
+
+	if _, err := p.Generate(context.Background(), "Hello", nil); !errors.Is(err, ErrNoHealthyServer) {
+		t.Errorf("expected ErrNoHealthyServer, got %v", err)
+	}
+	if err := p.Health(context.Background()); !errors.Is(err, ErrNoHealthyServer) {
+		t.Errorf("Health: expected ErrNoHealthyServer, got %v", err)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Managed members
+// ---------------------------------------------------------------------------
+
+func TestServerPool_RestartsManagedMember(t *testing.T) {
+	// The "server process" is a sleeping shell; health is answered by a test
+	// server on the member's port.
+	exe := fakeExecutable(t, "exec sleep 30")
+	srv := newPoolTestServer(t, nil)
+	port, _ := strconv.Atoi(srv.ts.URL[strings.LastIndex(srv.ts.URL, ":")+1:])
+
+	p := startPool(t, ServerPoolOptions{
+		Size: 1,
+		Server: ServerOptions{
+			Host:           "127.0.0.1",
+			Port:           port,
+			ExecutablePath: exe,
+			StartupTimeout: 100 * time.Millisecond,
+		},
+		HealthInterval: 10 * time.Millisecond,
+		RestartAfter:   2,
+	})
+	if m := p.Stats().Members[0]; !m.Managed || !m.Healthy {
+		t.Fatalf("member should be managed and healthy: %+v", m)
+	}
+
+	srv.healthy.Store(false)
+	waitFor(t, "a restart", func() bool { return p.Stats().Members[0].Restarts > 0 })
+	srv.healthy.Store(true)
+	waitFor(t, "recovery", func() bool { return p.Stats().Members[0].Healthy })
+}
+
+func TestServerPool_LeavesSupervisedMemberToSupervisor(t *testing.T) {
+	exe := fakeExecutable(t, "exec sleep 30")
+	srv := newPoolTestServer(t, nil)
+	port, _ := strconv.Atoi(srv.ts.URL[strings.LastIndex(srv.ts.URL, ":")+1:])
+
+	var log eventLog
+	p := startPool(t, ServerPoolOptions{
+		Size: 1,
+		Server: ServerOptions{
+			Host:                "127.0.0.1",
+			Port:                port,
+			ExecutablePath:      exe,
+			StartupTimeout:      100 * time.Millisecond,
+			Supervise:           true,
+			HealthCheckInterval: time.Hour,
+			OnEvent:             log.record,
+		},
+		HealthInterval: 10 * time.Millisecond,
+		RestartAfter:   1,
+	})
+
+	srv.healthy.Store(false)
+	waitFor(t, "member to leave rotation", func() bool { return !p.Stats().Members[0].Healthy })
+	time.Sleep(50 * time.Millisecond)
+	if n := p.Stats().Members[0].Restarts; n != 0 {
+		t.Errorf("pool restarted a supervised member %d times", n)
+	}
+	if n := log.count(ServerStopped); n != 0 {
+		t.Errorf("pool stopped a supervised member %d times", n)
+	}
+}
+
+func TestServerPool_StartFailure(t *testing.T) {
+	exe := fakeExecutable(t, "exit 1")
+	p, err := NewServerPool(ServerPoolOptions{
+		Size:   2,
+		Server: ServerOptions{Host: "127.0.0.1", Port: 1, ExecutablePath: exe, StartupTimeout: 50 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Start(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "pool member http://127.0.0.1:2") {
+		t.Errorf("expected start error naming each member, got %v", err)
+	}
+}
+
+func TestNewServerPool_Validation(t *testing.T) {
+	if _, err := NewServerPool(ServerPoolOptions{}); err == nil {
+		t.Error("expected error for empty pool")
+	}
+	if _, err := NewServerPool(ServerPoolOptions{URLs: []string{"tts-1:8000"}}); err == nil {
+		t.Error("expected error for URL without scheme")
+	}
+	ext, err := NewServerPool(ServerPoolOptions{URLs: []string{"https://tts.example.com/pocket"}})
+	if err != nil {
+		t.Fatalf("https URL with path prefix: %v", err)
+	}
+	if u := ext.members[0].client.BaseURL(); u != "https://tts.example.com/pocket" {
+		t.Errorf("external member BaseURL = %s", u)
+	}
+	p, err := NewServerPool(ServerPoolOptions{Size: 3, Server: ServerOptions{Port: 9000}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range p.Stats().Members {
+		if want := "http://localhost:" + strconv.Itoa(9000+i); m.URL != want {
+			t.Errorf("member %d: URL %s, want %s", i, m.URL, want)
+		}
+	}
+}
+
+func TestNewServerPool_URLsKeepQueueAndRetry(t *testing.T) {
+	tmpl := ServerOptions{
+		Concurrency:  2,
+		MaxQueue:     8,
+		QueueTimeout: time.Second,
+		QueueAging:   -1,
+		Retry:        RetryPolicy{MaxAttempts: 3},
+	}
+	p, err := NewServerPool(ServerPoolOptions{URLs: []string{"http://tts-1:8000"}, Server: tmpl})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := p.members[0].client.opts
+	if o.Concurrency != 2 || o.MaxQueue != 8 || o.QueueTimeout != time.Second || o.QueueAging != -1 || o.Retry.MaxAttempts != 3 {
+		t.Errorf("member options = %+v, want the template's queue and retry settings", o)
+	}
+}
@@ -0,0 +1,22 @@
+package pockettts
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// waitExited blocks until the process pid has exited, without reaping it.
+func waitExited(pid int) error {
+	const pPID = 1     // P_PID
+	var info [128]byte // siginfo_t; not inspected
+	for {
+		_, _, errno := syscall.Syscall6(syscall.SYS_WAITID, pPID, uintptr(pid),
+			uintptr(unsafe.Pointer(&info)), syscall.WEXITED|syscall.WNOWAIT, 0, 0)
+		if errno != syscall.EINTR {
+			if errno != 0 {
+				return errno
+			}
+			return nil
+		}
+	}
+}
@@ -0,0 +1,38 @@
+//go:build !unix
+
+package pockettts
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// setProcessGroup is a no-op: process groups are a Unix concept.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// terminateProcessGroup asks p to exit. Platforms without SIGTERM fall back
+// to killing it.
+func terminateProcessGroup(p *os.Process) error {
+	if err := p.Signal(os.Interrupt); err != nil {
+		return p.Kill()
+	}
+	return nil
+}
+
+// killProcessGroup kills p.
+func killProcessGroup(p *os.Process) error {
+	err := p.Kill()
+	if errors.Is(err, os.ErrProcessDone) {
+		return nil
+	}
+	return err
+}
+
+// waitProcess waits for cmd.
+func waitProcess(cmd *exec.Cmd) error {
+	return cmd.Wait()
+}
+
+// exitSignal returns nil: only Unix reports the signal that ended a process.
+func exitSignal(state *os.ProcessState) os.Signal { return nil }
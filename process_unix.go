@@ -0,0 +1,59 @@
+//go:build unix
+
+package pockettts
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup makes cmd the leader of a new process group, so that it
+// can be signalled together with any children it spawns.
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// terminateProcessGroup asks the process group led by p to exit.
+func terminateProcessGroup(p *os.Process) error {
+	return signalGroup(p, syscall.SIGTERM)
+}
+
+// killProcessGroup kills the process group led by p. It succeeds if the
+// group no longer exists.
+func killProcessGroup(p *os.Process) error {
+	return signalGroup(p, syscall.SIGKILL)
+}
+
+// waitProcess waits for cmd, which leads its own process group (see
+// setProcessGroup). Once the leader has exited, but before it is reaped, the
+// children it left behind are killed: until the leader is reaped its PID, and
+// so the group's ID, cannot be reused, so the signal cannot reach an
+// unrelated process group.
+func waitProcess(cmd *exec.Cmd) error {
+	if waitExited(cmd.Process.Pid) == nil {
+		_ = killProcessGroup(cmd.Process)
+	}
+	return cmd.Wait()
+}
+
+// exitSignal returns the signal that terminated the process, or nil if it
+// exited normally.
+func exitSignal(state *os.ProcessState) os.Signal {
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return ws.Signal()
+	}
+	return nil
+}
+
+func signalGroup(p *os.Process, sig syscall.Signal) error {
+	err := syscall.Kill(-p.Pid, sig)
+	if errors.Is(err, syscall.ESRCH) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,11 @@
+//go:build unix && !linux
+
+package pockettts
+
+import "errors"
+
+// waitExited is not implemented outside Linux, so children left behind by a
+// process that exits on its own are not killed there.
+func waitExited(pid int) error {
+	return errors.ErrUnsupported
+}
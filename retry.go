@@ -0,0 +1,137 @@
+package pockettts
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+// RetryPolicy controls how a backend retries a failed synthesis. The zero
+// value disables retries.
+//
+// Only buffered calls (Generate, GenerateWithOptions, Synthesize) are
+// retried; a stream may already have delivered audio when it fails.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first.
+	// Zero or one disables retries.
+	MaxAttempts int
+
+	// InitialBackoff is the wait before the first retry. Zero means 200ms.
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the wait between attempts. Zero means 10s.
+	MaxBackoff time.Duration
+
+	// Multiplier grows the wait after each retry. Values below 1 mean 2.
+	Multiplier float64
+
+	// Jitter is the fraction of each wait that is randomized, so that
+	// clients failing together do not retry in lockstep. Zero means 0.2;
+	// negative disables jitter. Values above 1 are treated as 1.
+	Jitter float64
+
+	// MaxElapsed bounds all attempts and waits together, in addition to the
+	// caller's context. Zero means no bound beyond the context.
+	MaxElapsed time.Duration
+
+	// Retryable decides whether an error is worth another attempt. Nil means
+	// IsRetryable. Errors that cannot succeed on retry (ErrEmptyText,
+	// ErrInvalidVoice, ErrServerStopped, option validation errors) are never
+	// retried, whatever Retryable returns.
+	Retryable func(error) bool
+}
+
+func (p *RetryPolicy) initialBackoff() time.Duration {
+	if p.InitialBackoff > 0 {
+		return p.InitialBackoff
+	}
+	return 200 * time.Millisecond
+}
+
+func (p *RetryPolicy) maxBackoff() time.Duration {
+	if p.MaxBackoff > 0 {
+		return p.MaxBackoff
+	}
+	return 10 * time.Second
+}
+
+func (p *RetryPolicy) multiplier() float64 {
+	if p.Multiplier >= 1 {
+		return p.Multiplier
+	}
+	return 2
+}
+
+func (p *RetryPolicy) jitter() float64 {
+	switch {
+	case p.Jitter < 0:
+		return 0
+	case p.Jitter == 0:
+		return 0.2
+	case p.Jitter > 1:
+		return 1
+	default:
+		return p.Jitter
+	}
+}
+
+// backoff returns the wait after the given failed attempt (1-based).
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	d := float64(p.initialBackoff())
+	for range attempt - 1 {
+		d *= p.multiplier()
+		if d >= float64(p.maxBackoff()) {
+			break
+		}
+	}
+	d = min(d, float64(p.maxBackoff()))
+	d -= rand.Float64() * p.jitter() * d
+	return time.Duration(d)
+}
+
+// retryable reports whether err should be retried under p.
+func (p *RetryPolicy) retryable(err error) bool {
+	switch {
+	case isPermanent(err):
+		return false
+	case p.Retryable != nil:
+		return p.Retryable(err)
+	default:
+		return IsRetryable(err)
+	}
+}
+
+// do calls attempt until it succeeds, fails with an error p does not retry,
+// or the attempts or MaxElapsed run out, and returns the last outcome. It
+// records the number of attempts in the result's Stats. The caller validates
+// its input first: do retries whatever attempt returns.
+func (p *RetryPolicy) do(ctx context.Context, attempt func(ctx context.Context) (*WAVResult, error)) (*WAVResult, error) {
+	if p.MaxElapsed > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.MaxElapsed)
+		defer cancel()
+	}
+
+	for n := 1; ; n++ {
+		res, err := attempt(ctx)
+		if err == nil {
+			res.Stats.Attempts = n
+			return res, nil
+		}
+		if n >= p.MaxAttempts || ctx.Err() != nil || !p.retryable(err) {
+			return nil, err
+		}
+
+		wait := p.backoff(n)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return nil, err // the next attempt could not start in time
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return nil, err
+		}
+	}
+}
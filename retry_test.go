@@ -0,0 +1,204 @@
+package pockettts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Jitter: -1}
+	for _, tc := range []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{5, time.Second},
+		{50, time.Second},
+	} {
+		if got := p.backoff(tc.attempt); got != tc.want {
+			t.Errorf("backoff(%d) = %s, want %s", tc.attempt, got, tc.want)
+		}
+	}
+
+	p.Jitter = 0.5
+	for range 100 {
+		if got := p.backoff(2); got < 100*time.Millisecond || got > 200*time.Millisecond {
+			t.Fatalf("backoff(2) with jitter 0.5 = %s, want within [100ms, 200ms]", got)
+		}
+	}
+}
+
+func TestRetryPolicy_NeverRetriesPermanentErrors(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Millisecond, Retryable: func(error) bool { return true }}
+	for _, permanent := range []error{
+		ErrEmptyText,
+		ErrServerStopped,
+		&ErrInvalidVoice{Voice: "bob", Exit: &ErrNonZeroExit{ExitCode: 1}},
+		fmt.Errorf("wrapped: %w", &ErrInvalidVoice{Voice: "bob"}),
+	} {
+		calls := 0
+		_, err := p.do(context.Background(), func(context.Context) (*WAVResult, error) {
+			calls++
+			return nil, permanent
+		})
+		if err != permanent || calls != 1 {
+			t.Errorf("%v: got %v after %d calls, want it unchanged after 1", permanent, err, calls)
+		}
+	}
+}
+
+func TestRetryPolicy_RetriesUntilSuccess(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 4, InitialBackoff: time.Millisecond}
+	calls := 0
+	res, err := p.do(context.Background(), func(context.Context) (*WAVResult, error) {
+		calls++
+		if calls < 3 {
+			return nil, &ErrNonZeroExit{ExitCode: 1, Stderr: "connection reset"}
+		}
+		return &WAVResult{}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Stats.Attempts != 3 {
+		t.Errorf("Attempts = %d, want 3", res.Stats.Attempts)
+	}
+}
+
+func TestRetryPolicy_GivesUpAfterMaxAttempts(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+	calls := 0
+	failure := &ErrNonZeroExit{ExitCode: 1}
+	_, err := p.do(context.Background(), func(context.Context) (*WAVResult, error) {
+		calls++
+		return nil, failure
+	})
+	if err != failure || calls != 3 {
+		t.Errorf("got %v after %d calls, want last error after 3", err, calls)
+	}
+}
+
+func TestRetryPolicy_MaxElapsed(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 100, InitialBackoff: 20 * time.Millisecond, Jitter: -1, MaxElapsed: 100 * time.Millisecond}
+	start := time.Now()
+	calls := 0
+	_, err := p.do(context.Background(), func(ctx context.Context) (*WAVResult, error) {
+		calls++
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("attempt context has no deadline")
+		}
+		return nil, &ErrNonZeroExit{ExitCode: 1}
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("gave up after %s, want about 100ms", d)
+	}
+	if calls < 2 || calls > 4 {
+		t.Errorf("made %d attempts, want 2-4 within 100ms", calls)
+	}
+}
+
+func TestRetryPolicy_StopsWhenContextDone(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Hour}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	calls := 0
+	_, err := p.do(ctx, func(context.Context) (*WAVResult, error) {
+		calls++
+		return nil, &ErrNonZeroExit{ExitCode: 1}
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("got %v after %d calls, want an error after 1", err, calls)
+	}
+}
+
+func TestClient_Retry(t *testing.T) {
+	wav := writeTempFile(t, "out.wav", append(makeWAVHeader(24000, 1, 16), make([]byte, 10)...))
+	count := filepath.Join(t.TempDir(), "count")
+	// Fails with a generic error on the first call and succeeds afterwards.
+	exe := fakeExecutable(t, fmt.Sprintf(`cat >/dev/null
+echo x >> %q
+if [ "$(wc -l < %q)" -lt 2 ]; then echo "connection reset by peer" >&2; exit 1; fi
+cat %q`, count, count, wav))
+
+	c := NewClient(Options{ExecutablePath: exe, Retry: RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}})
+	res, err := c.Generate(context.Background(), "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Stats.Attempts != 2 {
+		t.Errorf("Attempts = %d, want 2", res.Stats.Attempts)
+	}
+}
+
+func TestClient_RetryInvalidVoiceNotRetried(t *testing.T) {
+	count := filepath.Join(t.TempDir(), "count")
+	exe := fakeExecutable(t, fmt.Sprintf(`cat >/dev/null; echo x >> %q
+echo "ValueError: unknown voice 'bob'" >&2; exit 1`, count))
+
+	c := NewClient(Options{ExecutablePath: exe, Retry: RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}})
+	_, err := c.GenerateWithOptions(context.Background(), "hello", &GenerateOptions{Voice: "bob"})
+	var iv *ErrInvalidVoice
+	if !errors.As(err, &iv) {
+		t.Fatalf("err = %v, want *ErrInvalidVoice", err)
+	}
+	b, _ := os.ReadFile(count)
+	if n := strings.Count(string(b), "x"); n != 1 {
+		t.Errorf("ran %d times, want 1", n)
+	}
+}
+
+func TestServerClient_Retry(t *testing.T) {
+	wav := append(makeWAVHeader(24000, 1, 16), make([]byte, 10)...)
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch n := calls.Add(1); {
+		case n == 1:
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+		case n == 2:
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+		default:
+			w.Write(wav)
+		}
+	}))
+	defer ts.Close()
+
+	sc := NewServerClient(ServerOptions{BaseURL: ts.URL, Retry: RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}})
+	res, err := sc.Generate(context.Background(), "hello", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Stats.Attempts != 3 {
+		t.Errorf("Attempts = %d, want 3", res.Stats.Attempts)
+	}
+}
+
+func TestServerClient_RetryClientErrorNotRetried(t *testing.T) {
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	sc := NewServerClient(ServerOptions{BaseURL: ts.URL, Retry: RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}})
+	if _, err := sc.Generate(context.Background(), "hello", nil); err == nil {
+		t.Fatal("expected error")
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("server saw %d requests, want 1", n)
+	}
+}
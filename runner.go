@@ -1,18 +1,23 @@
 package pockettts
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"io"
+	"os"
 	"os/exec"
 	"sync"
+	"time"
 )
 
 // runResult holds the captured output of a subprocess run.
 type runResult struct {
-	stdout []byte
-	stderr string // last portion captured for error reporting
+	stdout   []byte
+	stderr   string // last portion captured for error reporting
+	exitCode int
+
+	spawn time.Duration // until the process was started
+	ttfb  time.Duration // until the first byte of stdout
 }
 
 // runner spawns a single pocket-tts subprocess, writes text to its stdin,
@@ -20,73 +25,177 @@ type runResult struct {
 type runner struct {
 	executablePath string
 	logWriter      io.Writer
+
+	// voice is the requested voice, used to classify failures (see
+	// classifyExit). May be empty.
+	voice string
+}
+
+// process is a started pocket-tts subprocess. The caller reads stdout until
+// EOF and then calls wait to collect the exit status.
+type process struct {
+	ctx    context.Context
+	start  time.Time
+	cmd    *exec.Cmd
+	stdout io.ReadCloser
+	stderr *tailBuffer
+	voice  string
+
+	stdinDone chan struct{}
+	stdinErr  error
+
+	waitOnce sync.Once
+	waitErr  error
 }
 
 func (r *runner) run(ctx context.Context, args []string, stdinPayload []byte) (*runResult, error) {
+	start := time.Now()
+	p, err := r.start(ctx, args, stdinPayload)
+	if err != nil {
+		return nil, err
+	}
+	spawn := time.Since(start)
+
+	stdout := &firstByteReader{r: p.stdout}
+	out, readErr := io.ReadAll(stdout)
+	if err := p.wait(); err != nil {
+		return nil, err
+	}
+	if readErr != nil {
+		return nil, fmt.Errorf("pockettts: read stdout: %w", readErr)
+	}
+
+	return &runResult{
+		stdout:   out,
+		stderr:   truncate(p.stderr.String(), 512),
+		exitCode: p.cmd.ProcessState.ExitCode(),
+		spawn:    spawn,
+		ttfb:     stdout.since(start),
+	}, nil
+}
+
+// start launches the subprocess and begins writing stdinPayload to it. The
+// returned process's stdout must be drained before calling wait.
+func (r *runner) start(ctx context.Context, args []string, stdinPayload []byte) (*process, error) {
 	exe := r.executablePath
 	if exe == "" {
 		exe = "pocket-tts"
 	}
 
-	cmd := exec.CommandContext(ctx, exe, args...)
-
-	stdinPipe, err := cmd.StdinPipe()
+	p := &process{
+		ctx:       ctx,
+		start:     time.Now(),
+		cmd:       exec.CommandContext(ctx, exe, args...),
+		stderr:    &tailBuffer{max: 4096},
+		voice:     r.voice,
+		stdinDone: make(chan struct{}),
+	}
+	stdinPipe, stdout, err := startProcess(p.cmd, p.stderr, r.logWriter)
 	if err != nil {
-		return nil, fmt.Errorf("pockettts: create stdin pipe: %w", err)
+		return nil, err
 	}
+	p.stdout = stdout
+
+	// Write stdin in a goroutine so we don't deadlock if the pipe buffer fills
+	// while the caller is still reading stdout.
+	go func() {
+		defer close(p.stdinDone)
+		defer stdinPipe.Close()
+		_, p.stdinErr = stdinPipe.Write(stdinPayload)
+	}()
 
-	var stdoutBuf bytes.Buffer
-	var stderrBuf bytes.Buffer
-	cmd.Stdout = &stdoutBuf
+	return p, nil
+}
+
+// wait waits for the stdin writer and the process to finish and maps the
+// outcome onto the package's error types, classifying non-zero exits by their
+// stderr (see classifyExit). It is safe to call more than once.
+func (p *process) wait() error {
+	p.waitOnce.Do(func() {
+		<-p.stdinDone
+		if p.stdinErr != nil {
+			_ = p.cmd.Process.Kill()
+			_ = p.cmd.Wait()
+			p.waitErr = fmt.Errorf("pockettts: write stdin: %w", p.stdinErr)
+			return
+		}
 
-	// stderr is always captured; also tee to logWriter if set.
-	if r.logWriter != nil {
-		cmd.Stderr = io.MultiWriter(&stderrBuf, r.logWriter)
+		if err := p.cmd.Wait(); err != nil {
+			stderr := truncate(p.stderr.String(), 512)
+			if p.ctx.Err() != nil {
+				p.waitErr = contextError(p.ctx, time.Since(p.start), stderr)
+				return
+			}
+			p.waitErr = exitError(p.cmd.ProcessState, p.stderr, p.voice)
+		}
+	})
+	return p.waitErr
+}
+
+// kill terminates the process without waiting for it; call wait afterwards
+// to reap it.
+func (p *process) kill() {
+	_ = p.cmd.Process.Kill()
+}
+
+// startProcess starts cmd with its stdin and stdout piped, keeping the tail
+// of its stderr in stderr and copying it to logWriter, if set. A missing
+// executable is reported as *ErrExecutableNotFound.
+func startProcess(cmd *exec.Cmd, stderr *tailBuffer, logWriter io.Writer) (stdin io.WriteCloser, stdout io.ReadCloser, err error) {
+	if stdin, err = cmd.StdinPipe(); err != nil {
+		return nil, nil, fmt.Errorf("pockettts: create stdin pipe: %w", err)
+	}
+	if stdout, err = cmd.StdoutPipe(); err != nil {
+		return nil, nil, fmt.Errorf("pockettts: create stdout pipe: %w", err)
+	}
+	if logWriter != nil {
+		cmd.Stderr = io.MultiWriter(stderr, logWriter)
 	} else {
-		cmd.Stderr = &stderrBuf
+		cmd.Stderr = stderr
 	}
-
 	if err := cmd.Start(); err != nil {
 		if isNotFound(err) {
-			return nil, &ErrExecutableNotFound{Executable: exe}
+			return nil, nil, &ErrExecutableNotFound{Executable: cmd.Args[0]}
 		}
-		return nil, fmt.Errorf("pockettts: start process: %w", err)
+		return nil, nil, fmt.Errorf("pockettts: start process: %w", err)
 	}
+	return stdin, stdout, nil
+}
 
-	// Write stdin in a goroutine so we don't deadlock if the pipe buffer fills.
-	var wg sync.WaitGroup
-	var stdinErr error
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		defer stdinPipe.Close()
-		_, stdinErr = stdinPipe.Write(stdinPayload)
-	}()
-
-	wg.Wait()
-	if stdinErr != nil {
-		_ = cmd.Process.Kill()
-		return nil, fmt.Errorf("pockettts: write stdin: %w", stdinErr)
-	}
+// exitError maps the failed exit of a process onto the package's error
+// types, classifying it by the tail of its stderr (see classifyExit).
+func exitError(state *os.ProcessState, stderr *tailBuffer, voice string) error {
+	return classifyExit(&ErrNonZeroExit{
+		ExitCode: state.ExitCode(),
+		Stderr:   truncate(stderr.String(), 512),
+		Signal:   exitSignal(state),
+	}, voice)
+}
 
-	waitErr := cmd.Wait()
+// firstByteReader records when the first byte was read from r, and counts
+// the bytes read.
+type firstByteReader struct {
+	r  io.Reader
+	at time.Time
+	n  int
+}
 
-	res := &runResult{
-		stdout: stdoutBuf.Bytes(),
-		stderr: truncate(stderrBuf.String(), 512),
+func (f *firstByteReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if n > 0 && f.at.IsZero() {
+		f.at = time.Now()
 	}
+	f.n += n
+	return n, err
+}
 
-	if waitErr != nil {
-		if ctx.Err() != nil {
-			return nil, &ErrProcessTimeout{Stderr: res.stderr}
-		}
-		return nil, &ErrNonZeroExit{
-			ExitCode: cmd.ProcessState.ExitCode(),
-			Stderr:   res.stderr,
-		}
+// since returns the time from start to the first byte, or zero if nothing
+// was read.
+func (f *firstByteReader) since(start time.Time) time.Duration {
+	if f.at.IsZero() {
+		return 0
 	}
-
-	return res, nil
+	return f.at.Sub(start)
 }
 
 // truncate keeps at most n bytes from the end of s (for stderr excerpts).
@@ -3,14 +3,20 @@ package pockettts
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -22,6 +28,39 @@ type ServerOptions struct {
 	// Port that the server listens on (default: 8000).
 	Port int
 
+	// BaseURL connects to an externally managed server instead of one on
+	// Host and Port, e.g. "https://tts.example.com/pocket". Its scheme and
+	// path prefix are kept: requests go to BaseURL + "/tts". Start cannot be
+	// used with BaseURL.
+	BaseURL string
+
+	// HTTPClient sends the requests. Defaults to a client with a 10 minute
+	// timeout using Transport. Transport and TLSConfig are ignored when it
+	// is set.
+	HTTPClient *http.Client
+
+	// Transport is the round tripper of the default HTTPClient, e.g. for
+	// instrumentation. Defaults to a clone of http.DefaultTransport.
+	Transport http.RoundTripper
+
+	// TLSConfig configures TLS for https BaseURLs, e.g. a private CA or a
+	// client certificate for mutual TLS (see LoadTLSConfig). Ignored when
+	// Transport is set.
+	TLSConfig *tls.Config
+
+	// Header is added to every request, e.g. an Authorization header.
+	Header http.Header
+
+	// BeforeRequest, if set, is called with every request (health checks
+	// included) before it is sent, e.g. to attach a short-lived token. An
+	// error fails the request.
+	BeforeRequest func(*http.Request) error
+
+	// AutoPort makes Start launch the server on a free port chosen by the
+	// operating system instead of Port. The port is kept across restarts and
+	// reported by ServerClient.BaseURL.
+	AutoPort bool
+
 	// Voice is the default voice name or path passed to `pocket-tts serve`.
 	Voice string
 
@@ -38,6 +77,86 @@ type ServerOptions struct {
 	// StartupTimeout is how long to wait for the server to become healthy
 	// after starting. Defaults to 5 minutes (model download may be needed).
 	StartupTimeout time.Duration
+
+	// Lifetime, if set, bounds how long a started server lives: once it is
+	// done, the server is shut down as by Stop. The context passed to Start
+	// only bounds the startup; without Lifetime the server runs until Stop.
+	Lifetime context.Context
+
+	// Supervise makes Start watch the server process and restart it when it
+	// exits or fails HealthFailureThreshold consecutive health checks.
+	Supervise bool
+
+	// RestartBackoff is the delay before the first restart. It doubles for
+	// each consecutive restart up to MaxRestartBackoff, and resets once the
+	// server has stayed up for MaxRestartBackoff. Defaults to 1 second.
+	RestartBackoff time.Duration
+
+	// MaxRestartBackoff caps the restart delay. Defaults to 1 minute.
+	MaxRestartBackoff time.Duration
+
+	// MaxRestarts is the restart budget: the number of restarts allowed
+	// within RestartWindow before the supervisor gives up and requests fail
+	// with ErrRestartBudgetExhausted. Defaults to 5; negative is unlimited.
+	MaxRestarts int
+
+	// RestartWindow is the period over which MaxRestarts is counted.
+	// Defaults to 10 minutes.
+	RestartWindow time.Duration
+
+	// HealthCheckInterval is the time between supervisor health checks.
+	// Defaults to 10 seconds.
+	HealthCheckInterval time.Duration
+
+	// HealthFailureThreshold is the number of consecutive failed health
+	// checks after which a running server is considered hung and restarted.
+	// Defaults to 3.
+	HealthFailureThreshold int
+
+	// QueueDuringRestart makes requests issued while the supervisor is
+	// restarting the server wait (bounded by their context) until it is
+	// back. By default they fail fast with ErrServerRestarting.
+	QueueDuringRestart bool
+
+	// OnEvent, if set, receives server lifecycle events. It is called
+	// synchronously from the supervisor and must not block.
+	OnEvent func(ServerEvent)
+
+	// ShutdownTimeout bounds how long Stop waits for in-flight requests and
+	// for the server to exit after SIGTERM before killing it. Defaults to
+	// 10 seconds. Use Shutdown to pass a context instead.
+	ShutdownTimeout time.Duration
+
+	// Concurrency is the maximum number of /tts requests (including open
+	// streams) sent to the server at once; the rest wait in a queue. A
+	// pocket-tts server synthesizes on one model, so a low limit keeps tail
+	// latency predictable. Zero or negative means unlimited.
+	Concurrency int
+
+	// MaxQueue is the maximum number of requests waiting for a slot when
+	// Concurrency is set; further requests fail at once with ErrQueueFull.
+	// Zero or negative means unlimited.
+	MaxQueue int
+
+	// QueueTimeout is the longest a request waits for a slot before failing
+	// with *ErrQueueTimeout. Zero means it waits as long as its context
+	// allows.
+	QueueTimeout time.Duration
+
+	// QueueAging raises a waiting request's priority by one level for each
+	// QueueAging it has waited, so that batch requests are not starved by a
+	// steady stream of interactive ones. Zero means 10 seconds; negative
+	// disables aging.
+	QueueAging time.Duration
+
+	// Retry retries failed requests, e.g. connection errors while a
+	// supervised server restarts (see RetryPolicy). The zero value disables
+	// retries.
+	Retry RetryPolicy
+
+	// Observer, if set, is notified of each /tts request attempt made by
+	// Generate and Synthesize (see Observer).
+	Observer Observer
 }
 
 func (o *ServerOptions) host() string {
@@ -54,8 +173,17 @@ func (o *ServerOptions) port() int {
 	return o.Port
 }
 
-func (o *ServerOptions) baseURL() string {
-	return fmt.Sprintf("http://%s:%d", o.host(), o.port())
+func (o *ServerOptions) httpClient() *http.Client {
+	if o.HTTPClient != nil {
+		return o.HTTPClient
+	}
+	rt := o.Transport
+	if rt == nil && o.TLSConfig != nil {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.TLSClientConfig = o.TLSConfig
+		rt = t
+	}
+	return &http.Client{Timeout: 10 * time.Minute, Transport: rt}
 }
 
 func (o *ServerOptions) startupTimeout() time.Duration {
@@ -65,6 +193,55 @@ func (o *ServerOptions) startupTimeout() time.Duration {
 	return o.StartupTimeout
 }
 
+func (o *ServerOptions) restartBackoff() time.Duration {
+	if o.RestartBackoff <= 0 {
+		return time.Second
+	}
+	return o.RestartBackoff
+}
+
+func (o *ServerOptions) maxRestartBackoff() time.Duration {
+	if o.MaxRestartBackoff <= 0 {
+		return time.Minute
+	}
+	return o.MaxRestartBackoff
+}
+
+func (o *ServerOptions) maxRestarts() int {
+	if o.MaxRestarts == 0 {
+		return 5
+	}
+	return o.MaxRestarts
+}
+
+func (o *ServerOptions) restartWindow() time.Duration {
+	if o.RestartWindow <= 0 {
+		return 10 * time.Minute
+	}
+	return o.RestartWindow
+}
+
+func (o *ServerOptions) healthCheckInterval() time.Duration {
+	if o.HealthCheckInterval <= 0 {
+		return 10 * time.Second
+	}
+	return o.HealthCheckInterval
+}
+
+func (o *ServerOptions) shutdownTimeout() time.Duration {
+	if o.ShutdownTimeout <= 0 {
+		return 10 * time.Second
+	}
+	return o.ShutdownTimeout
+}
+
+func (o *ServerOptions) healthFailureThreshold() int {
+	if o.HealthFailureThreshold <= 0 {
+		return 3
+	}
+	return o.HealthFailureThreshold
+}
+
 // ServerClient manages a pocket-tts HTTP server and issues TTS requests to it.
 //
 // Unlike the CLI-based Client, ServerClient keeps the model warm in memory
@@ -72,35 +249,96 @@ func (o *ServerOptions) startupTimeout() time.Duration {
 // first request.
 //
 // Create with NewServerClient. Call Start to launch the server process, then
-// Generate for each TTS request, and Stop when done.
+// Generate for each TTS request, and Stop or Shutdown when done.
 type ServerClient struct {
-	opts ServerOptions
-	proc *exec.Cmd
-	http *http.Client
+	opts  ServerOptions
+	http  *http.Client
+	slots *limiter // bounds /tts requests per ServerOptions.Concurrency
+
+	mu       sync.Mutex // guards the fields below
+	proc     *serverProcess
+	sup      *supervisor
+	port     int           // chosen by AutoPort; 0 means opts.port()
+	unwatch  func() bool   // stops the Lifetime watch; nil without one
+	stopping bool          // set by Shutdown; new requests are rejected
+	inFlight int           // /tts requests whose response is still open
+	drained  chan struct{} // closed when inFlight drops to zero; nil if unused
+
+	checkPort func(addr string) error // checkPortFree; replaced in tests
 }
 
 // NewServerClient creates a ServerClient with the given options.
 // Call Start to actually launch the pocket-tts server process.
 func NewServerClient(opts ServerOptions) *ServerClient {
 	return &ServerClient{
-		opts: opts,
-		http: &http.Client{Timeout: 10 * time.Minute},
+		opts:      opts,
+		http:      opts.httpClient(),
+		slots:     &limiter{limit: opts.Concurrency, maxQueue: opts.MaxQueue, timeout: opts.QueueTimeout, aging: opts.QueueAging},
+		checkPort: checkPortFree,
 	}
 }
 
 // Start launches `pocket-tts serve` as a managed subprocess and waits until
 // the /health endpoint responds. Returns an error if the server does not become
-// healthy within ServerOptions.StartupTimeout.
+// healthy within ServerOptions.StartupTimeout, ErrPortInUse if another process
+// already listens on the port, and the classified exit (e.g.
+// ErrMissingDependency) if the server exits during startup.
+//
+// ctx only bounds the wait for startup: once Start has returned, cancelling
+// it does not affect the server, which runs until Stop, Shutdown or the end
+// of ServerOptions.Lifetime.
+//
+// With ServerOptions.Supervise set, a supervisor then watches the process and
+// restarts it when it exits or stops answering health checks.
 func (s *ServerClient) Start(ctx context.Context) error {
+	if s.opts.BaseURL != "" {
+		return errors.New("pockettts: Start launches a local server; it cannot be used with BaseURL")
+	}
+	proc, err := s.launch(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.proc = proc
+	s.stopping = false
+	s.mu.Unlock()
+	s.emit(ServerEvent{Type: ServerStarted, PID: proc.pid()})
+
+	if s.opts.Supervise {
+		s.startSupervisor(proc)
+	}
+	if s.opts.Lifetime != nil {
+		unwatch := context.AfterFunc(s.opts.Lifetime, func() { _ = s.Stop() })
+		s.mu.Lock()
+		s.unwatch = unwatch
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// launch starts a server process and polls /health until it is healthy. The
+// process is killed if it does not become healthy. If it exits first, launch
+// fails at once with the exit classified from its stderr (see classifyExit).
+func (s *ServerClient) launch(ctx context.Context) (*serverProcess, error) {
 	exe := s.opts.ExecutablePath
 	if exe == "" {
 		exe = "pocket-tts"
 	}
 
+	port, err := s.listenPort()
+	if err != nil {
+		return nil, err
+	}
+	addr := net.JoinHostPort(s.opts.host(), strconv.Itoa(port))
+	if err := s.checkPort(addr); err != nil {
+		return nil, err
+	}
+
 	args := []string{
 		"serve",
 		"--host", s.opts.host(),
-		"--port", fmt.Sprintf("%d", s.opts.port()),
+		"--port", strconv.Itoa(port),
 		"--no-reload",
 	}
 	if s.opts.Voice != "" {
@@ -110,57 +348,167 @@ func (s *ServerClient) Start(ctx context.Context) error {
 		args = append(args, "--config", s.opts.Config)
 	}
 
-	cmd := exec.CommandContext(ctx, exe, args...)
+	// Not CommandContext: ctx bounds the startup, not the process lifetime.
+	cmd := exec.Command(exe, args...)
+	setProcessGroup(cmd)
+	stderr := &tailBuffer{max: 4096}
 	if s.opts.LogWriter != nil {
-		cmd.Stderr = s.opts.LogWriter
+		cmd.Stderr = io.MultiWriter(stderr, s.opts.LogWriter)
+	} else {
+		cmd.Stderr = stderr
 	}
+	// Children inheriting stderr must not keep Wait from reporting the exit.
+	cmd.WaitDelay = time.Second
 
 	if err := cmd.Start(); err != nil {
 		if isNotFound(err) {
-			return &ErrExecutableNotFound{Executable: exe}
+			return nil, &ErrExecutableNotFound{Executable: exe}
 		}
-		return fmt.Errorf("pockettts: start server: %w", err)
+		return nil, fmt.Errorf("pockettts: start server: %w", err)
 	}
-	s.proc = cmd
-
-	// Poll /health until healthy or timeout.
-	deadline := time.Now().Add(s.opts.startupTimeout())
-	for time.Now().Before(deadline) {
-		if ctx.Err() != nil {
-			_ = s.proc.Process.Kill()
-			return ctx.Err()
-		}
+	proc := watchProcess(cmd, stderr)
+
+	// Poll /health until healthy, the process exits or the timeout expires.
+	// Since the port was free before the launch, a healthy response while
+	// the process is still running comes from the server we started.
+	timeout := time.NewTimer(s.opts.startupTimeout())
+	defer timeout.Stop()
+	poll := time.NewTicker(500 * time.Millisecond)
+	defer poll.Stop()
+	for {
 		if err := s.Health(ctx); err == nil {
-			return nil
+			select {
+			case <-proc.done:
+			default:
+				return proc, nil
+			}
 		}
-		time.Sleep(500 * time.Millisecond)
+		select {
+		case <-proc.done:
+			return nil, proc.startupError(s.opts.Voice)
+		case <-ctx.Done():
+			proc.kill()
+			return nil, ctx.Err()
+		case <-timeout.C:
+			proc.kill()
+			return nil, fmt.Errorf("pockettts: server did not become healthy within %s", s.opts.startupTimeout())
+		case <-poll.C:
+		}
+	}
+}
+
+// listenPort returns the port the server listens on, choosing a free one on
+// first use if ServerOptions.AutoPort is set.
+func (s *ServerClient) listenPort() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.port != 0 {
+		return s.port, nil
+	}
+	if !s.opts.AutoPort {
+		return s.opts.port(), nil
 	}
+	ln, err := net.Listen("tcp", net.JoinHostPort(s.opts.host(), "0"))
+	if err != nil {
+		return 0, fmt.Errorf("pockettts: pick free port: %w", err)
+	}
+	s.port = ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+	return s.port, nil
+}
 
-	_ = s.proc.Process.Kill()
-	return fmt.Errorf("pockettts: server did not become healthy within %s", s.opts.startupTimeout())
+// checkPortFree fails with ErrPortInUse if something already listens on addr,
+// so that Start does not mistake another server for the one it launches.
+func checkPortFree(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return &ErrPortInUse{Addr: addr, Err: err}
+	}
+	return ln.Close()
 }
 
-// Stop terminates the managed server process. It is safe to call even if Start
-// was never called or the process has already exited.
+// BaseURL returns the URL requests are sent to. With ServerOptions.AutoPort
+// set, the port is known once Start has launched the server.
+func (s *ServerClient) BaseURL() string {
+	return s.baseURL()
+}
+
+func (s *ServerClient) baseURL() string {
+	if s.opts.BaseURL != "" {
+		return strings.TrimSuffix(s.opts.BaseURL, "/")
+	}
+	s.mu.Lock()
+	port := s.port
+	s.mu.Unlock()
+	if port == 0 {
+		port = s.opts.port()
+	}
+	return fmt.Sprintf("http://%s", net.JoinHostPort(s.opts.host(), strconv.Itoa(port)))
+}
+
+// Stop shuts the server down gracefully, bounded by
+// ServerOptions.ShutdownTimeout. See Shutdown.
 func (s *ServerClient) Stop() error {
-	if s.proc == nil || s.proc.Process == nil {
+	ctx, cancel := context.WithTimeout(context.Background(), s.opts.shutdownTimeout())
+	defer cancel()
+	return s.Shutdown(ctx)
+}
+
+// Shutdown stops the supervisor, if any, and shuts the managed server process
+// down gracefully: new requests fail with ErrServerStopped, in-flight
+// requests (including open streams) are allowed to finish, and then the
+// server's process group is sent SIGTERM. Once ctx is done, the process
+// group is killed instead of waited for and ctx.Err() is returned.
+//
+// It is safe to call even if Start was never called or the process has
+// already exited. Start may be called again afterwards.
+func (s *ServerClient) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.stopping = true
+	if s.unwatch != nil {
+		s.unwatch()
+		s.unwatch = nil
+	}
+	var drained chan struct{}
+	if s.inFlight > 0 {
+		if s.drained == nil {
+			s.drained = make(chan struct{})
+		}
+		drained = s.drained
+	}
+	s.mu.Unlock()
+	s.stopSupervisor()
+
+	if drained != nil {
+		select {
+		case <-drained:
+		case <-ctx.Done():
+		}
+	}
+
+	s.mu.Lock()
+	proc := s.proc
+	s.proc = nil
+	s.mu.Unlock()
+	if proc == nil {
 		return nil
 	}
-	if err := s.proc.Process.Kill(); err != nil {
+	err := proc.terminate(ctx)
+	s.emit(ServerEvent{Type: ServerStopped, PID: proc.pid()})
+	if err != nil {
 		return fmt.Errorf("pockettts: stop server: %w", err)
 	}
-	_ = s.proc.Wait()
 	return nil
 }
 
 // Health calls GET /health and returns nil if the server responds with status
 // 200. This can be used independently of Start for externally-managed servers.
 func (s *ServerClient) Health(ctx context.Context) error {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.opts.baseURL()+"/health", nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.baseURL()+"/health", nil)
 	if err != nil {
 		return fmt.Errorf("pockettts: build health request: %w", err)
 	}
-	resp, err := s.http.Do(req)
+	resp, err := s.do(req)
 	if err != nil {
 		return fmt.Errorf("pockettts: health check: %w", err)
 	}
@@ -171,6 +519,51 @@ func (s *ServerClient) Health(ctx context.Context) error {
 	return nil
 }
 
+// do applies ServerOptions.Header and BeforeRequest to req and sends it.
+func (s *ServerClient) do(req *http.Request) (*http.Response, error) {
+	for k, vs := range s.opts.Header {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	if s.opts.BeforeRequest != nil {
+		if err := s.opts.BeforeRequest(req); err != nil {
+			return nil, err
+		}
+	}
+	return s.http.Do(req)
+}
+
+// LoadTLSConfig returns a TLS configuration for ServerOptions.TLSConfig that
+// trusts the PEM certificates in caFile (in addition to the system roots)
+// and, if certFile and keyFile are set, presents that client certificate for
+// mutual TLS. Empty paths are skipped.
+func LoadTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("pockettts: read CA file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("pockettts: no certificates found in %s", caFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("pockettts: load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
 // ServerGenerateOptions controls per-request parameters for server-mode TTS.
 type ServerGenerateOptions struct {
 	// VoiceURL is a URL (http://, https://, or hf://) to a voice audio file.
@@ -180,6 +573,10 @@ type ServerGenerateOptions struct {
 	// VoiceWAVPath is a local path to a voice WAV or .safetensors file to
 	// upload for voice cloning. Mutually exclusive with VoiceURL.
 	VoiceWAVPath string
+
+	// Priority orders the request in the concurrency queue (see
+	// ServerOptions.Concurrency).
+	Priority Priority
 }
 
 // Generate sends a POST /tts request to the running pocket-tts server and
@@ -193,53 +590,198 @@ func (s *ServerClient) Generate(ctx context.Context, text string, opts *ServerGe
 	if opts == nil {
 		opts = &ServerGenerateOptions{}
 	}
+	return s.opts.Retry.do(ctx, func(ctx context.Context) (*WAVResult, error) {
+		return s.generateOnce(ctx, text, opts)
+	})
+}
+
+// generateOnce sends a single /tts request for Generate.
+func (s *ServerClient) generateOnce(ctx context.Context, text string, opts *ServerGenerateOptions) (result *WAVResult, err error) {
+	obs := observe(s.opts.Observer, ModeServer, opts.Priority)
+	defer func() { obs.done(result, err) }()
+
+	start := time.Now()
+	resp, queued, err := s.postTTS(ctx, text, opts, obs)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	sent := start.Add(queued)
+	body := &firstByteReader{r: resp.Body}
+	wavBytes, err := io.ReadAll(body)
+	elapsed := time.Since(sent)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, contextError(ctx, elapsed, "")
+		}
+		return nil, fmt.Errorf("pockettts: read TTS response: %w", err)
+	}
+
+	sr, ch, bps, err := parseWAVHeader(wavBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := GenerationStats{
+		Duration:  elapsed,
+		QueueWait: queued,
+		TTFB:      body.since(sent),
+		ExitCode:  resp.StatusCode,
+		Backend:   ModeServer,
+	}
+	stats.measure(wavBytes)
+	stats.log(s.opts.LogWriter)
+
+	return &WAVResult{
+		Data:          wavBytes,
+		SampleRate:    sr,
+		Channels:      ch,
+		BitsPerSample: bps,
+		Stats:         stats,
+	}, nil
+}
+
+// requestVoice returns the voice a /tts request will use, for error
+// classification.
+func (s *ServerClient) requestVoice(opts *ServerGenerateOptions) string {
+	switch {
+	case opts.VoiceURL != "":
+		return opts.VoiceURL
+	case opts.VoiceWAVPath != "":
+		return opts.VoiceWAVPath
+	default:
+		return s.opts.Voice
+	}
+}
+
+// postTTS waits for a concurrency slot, sends POST /tts and returns the
+// response if the server answered with status 200, together with the time
+// spent waiting for the slot. Error responses are classified like CLI stderr
+// (see classifyExit). The slot is held until the caller closes the response
+// body. obs, which may be nil, is told when the slot was acquired.
+func (s *ServerClient) postTTS(ctx context.Context, text string, opts *ServerGenerateOptions, obs *observation) (*http.Response, time.Duration, error) {
+	releaseSlot, queued, err := s.slots.acquire(ctx, opts.Priority)
+	if err != nil {
+		return nil, queued, err
+	}
+	obs.admitted(queued)
+	endRequest, err := s.beginRequest()
+	if err != nil {
+		releaseSlot()
+		return nil, queued, err
+	}
+	release := func() {
+		endRequest()
+		releaseSlot()
+	}
+	if err := s.awaitReady(ctx); err != nil {
+		release()
+		return nil, queued, err
+	}
+	resp, err := s.doTTS(ctx, text, opts)
+	if err != nil {
+		release()
+		return nil, queued, err
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+	return resp, queued, nil
+}
+
+// Queued returns the number of requests waiting for a concurrency slot (see
+// ServerOptions.Concurrency).
+func (s *ServerClient) Queued() int {
+	return s.slots.queued()
+}
+
+// QueueDepth returns the number of requests waiting for a concurrency slot
+// by priority. Priorities without waiting requests are omitted.
+func (s *ServerClient) QueueDepth() map[Priority]int {
+	return s.slots.depth()
+}
+
+// beginRequest counts a request as in flight so that Shutdown waits for it.
+// It fails with ErrServerStopped once Shutdown has begun.
+func (s *ServerClient) beginRequest() (release func(), err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopping {
+		return nil, ErrServerStopped
+	}
+	s.inFlight++
+	var once sync.Once
+	return func() { once.Do(s.endRequest) }, nil
+}
+
+// endRequest counts a request as finished, waking Shutdown after the last.
+func (s *ServerClient) endRequest() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inFlight--
+	if s.inFlight == 0 && s.drained != nil {
+		close(s.drained)
+		s.drained = nil
+	}
+}
+
+// releasingBody ends an in-flight request when its response body is closed.
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
 
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
+
+func (s *ServerClient) doTTS(ctx context.Context, text string, opts *ServerGenerateOptions) (*http.Response, error) {
 	body, contentType, err := buildTTSRequest(text, opts)
 	if err != nil {
 		return nil, err
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.opts.baseURL()+"/tts", body)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.baseURL()+"/tts", body)
 	if err != nil {
 		return nil, fmt.Errorf("pockettts: build TTS request: %w", err)
 	}
 	req.Header.Set("Content-Type", contentType)
 
 	start := time.Now()
-	resp, err := s.http.Do(req)
+	resp, err := s.do(req)
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, contextError(ctx, time.Since(start), "")
+		}
 		return nil, fmt.Errorf("pockettts: TTS request: %w", err)
 	}
-	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
 		errBody, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
-		return nil, &ErrNonZeroExit{ExitCode: resp.StatusCode, Stderr: string(errBody)}
+		exit := &ErrNonZeroExit{ExitCode: resp.StatusCode, Stderr: string(errBody), HTTP: true}
+		return nil, classifyExit(exit, s.requestVoice(opts))
 	}
+	return resp, nil
+}
 
-	wavBytes, err := io.ReadAll(resp.Body)
-	elapsed := time.Since(start)
-	if err != nil {
-		return nil, fmt.Errorf("pockettts: read TTS response: %w", err)
+// Synthesize implements Synthesizer by mapping opts onto the /tts multipart
+// fields and calling Generate. opts may be nil.
+func (s *ServerClient) Synthesize(ctx context.Context, text string, opts *GenerateOptions) (*WAVResult, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
 	}
-
-	sr, ch, bps, err := parseWAVHeader(wavBytes)
+	res, err := s.Generate(ctx, text, opts.serverOptions())
 	if err != nil {
 		return nil, err
 	}
+	return finishResult(ctx, res, opts)
+}
 
-	if s.opts.LogWriter != nil {
-		fmt.Fprintf(s.opts.LogWriter, "pockettts: generated %d bytes in %s (mode=server)\n",
-			len(wavBytes), elapsed.Round(time.Millisecond))
-	}
-
-	return &WAVResult{
-		Data:          wavBytes,
-		SampleRate:    sr,
-		Channels:      ch,
-		BitsPerSample: bps,
-		Stats:         GenerationStats{Duration: elapsed},
-	}, nil
+// Close implements Synthesizer by stopping the managed server process, if any.
+func (s *ServerClient) Close() error {
+	return s.Stop()
 }
 
 // buildTTSRequest constructs the multipart/form-data body for POST /tts.
@@ -1,11 +1,20 @@
 package pockettts
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
 	"errors"
+	"io"
+	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"testing"
@@ -100,6 +109,34 @@ func TestServerClient_Generate_ValidWAV(t *testing.T) {
 	}
 }
 
+func TestServerClient_Generate_Stats(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(makeWAVHeader(24000, 1, 16))
+		w.(http.Flusher).Flush()
+		time.Sleep(50 * time.Millisecond)
+		_, _ = w.Write(make([]byte, 4800)) // 100ms of audio
+	}))
+	defer ts.Close()
+
+	res, err := serverClientFor(ts).Generate(context.Background(), "Hello", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := res.Stats
+	if st.Backend != ModeServer || st.ExitCode != http.StatusOK || st.SpawnTime != 0 {
+		t.Errorf("Backend %q, ExitCode %d, SpawnTime %s; want server, 200, 0", st.Backend, st.ExitCode, st.SpawnTime)
+	}
+	if st.AudioDuration != 100*time.Millisecond || st.Bytes != 44+4800 {
+		t.Errorf("AudioDuration %s, Bytes %d; want 100ms, %d", st.AudioDuration, st.Bytes, 44+4800)
+	}
+	if st.TTFB <= 0 || st.Duration-st.TTFB < 50*time.Millisecond {
+		t.Errorf("TTFB %s, Duration %s; want the header 50ms before the end", st.TTFB, st.Duration)
+	}
+	if st.SynthesisTime != st.Duration || st.RealTimeFactor < 0.5 {
+		t.Errorf("SynthesisTime %s, RealTimeFactor %v; want Duration, >= 0.5", st.SynthesisTime, st.RealTimeFactor)
+	}
+}
+
 func TestServerClient_Generate_ServerError(t *testing.T) {
 	fs := newFakeServer(http.StatusOK, http.StatusInternalServerError, []byte("model error"))
 	defer fs.ts.Close()
@@ -118,6 +155,41 @@ func TestServerClient_Generate_ServerError(t *testing.T) {
 	}
 }
 
+func TestServerClient_Generate_ContextErrors(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+	defer close(release)
+	sc := serverClientFor(ts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := sc.Generate(ctx, "Hello", nil)
+	var tErr *ErrProcessTimeout
+	if !errors.As(err, &tErr) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("deadline: expected ErrProcessTimeout, got %T: %v", err, err)
+	}
+	if tErr.Elapsed < 50*time.Millisecond {
+		t.Errorf("deadline: Elapsed = %s, want >= 50ms", tErr.Elapsed)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	_, err = sc.Generate(ctx, "Hello", nil)
+	var cErr *ErrCanceled
+	if !errors.As(err, &cErr) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancel: expected ErrCanceled, got %T: %v", err, err)
+	}
+	if cErr.Queued || cErr.Elapsed < 50*time.Millisecond {
+		t.Errorf("cancel: ErrCanceled = %+v, want a synthesis cancel after >= 50ms", cErr)
+	}
+}
+
 // ---------------------------------------------------------------------------
 // buildTTSRequest
 // ---------------------------------------------------------------------------
@@ -141,6 +213,164 @@ func TestBuildTTSRequest_WithVoiceURL(t *testing.T) {
 	_ = body
 }
 
+// ---------------------------------------------------------------------------
+// Start
+// ---------------------------------------------------------------------------
+
+func TestServerClient_Start_EarlyExit(t *testing.T) {
+	exe := fakeExecutable(t, `echo "ModuleNotFoundError: No module named 'torch'" >&2; exit 1`)
+	sc := NewServerClient(ServerOptions{Host: "127.0.0.1", AutoPort: true, ExecutablePath: exe, StartupTimeout: time.Minute})
+
+	start := time.Now()
+	err := sc.Start(context.Background())
+	var dep *ErrMissingDependency
+	if !errors.As(err, &dep) {
+		t.Fatalf("expected ErrMissingDependency, got %T: %v", err, err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Start took %s to notice the exit", elapsed)
+	}
+}
+
+func TestServerClient_Start_PortInUse(t *testing.T) {
+	fs := newFakeServer(http.StatusOK, http.StatusOK, nil)
+	defer fs.ts.Close()
+
+	mark := filepath.Join(t.TempDir(), "launched")
+	sc := serverClientFor(fs.ts)
+	sc.opts.ExecutablePath = fakeExecutable(t, "touch "+mark+"; exec sleep 30")
+	defer sc.Stop()
+
+	err := sc.Start(context.Background())
+	var inUse *ErrPortInUse
+	if !errors.As(err, &inUse) {
+		t.Fatalf("expected ErrPortInUse, got %T: %v", err, err)
+	}
+	if _, err := os.Stat(mark); err == nil {
+		t.Error("server was launched although the port is taken")
+	}
+}
+
+func TestServerClient_Start_AutoPort(t *testing.T) {
+	argsFile := filepath.Join(t.TempDir(), "args")
+	exe := fakeExecutable(t, `echo "$@" > `+argsFile+`; exec sleep 30`)
+	sc := NewServerClient(ServerOptions{Host: "127.0.0.1", Port: 1, AutoPort: true, ExecutablePath: exe})
+	defer sc.Stop()
+
+	// Stand in for the launched server on whichever port it was given.
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer ts.Close()
+	go func() {
+		for {
+			b, err := os.ReadFile(argsFile)
+			if f := strings.Fields(string(b)); err == nil && len(f) > 4 && f[3] == "--port" {
+				ts.Listener.Close()
+				if ts.Listener, err = net.Listen("tcp", "127.0.0.1:"+f[4]); err == nil {
+					ts.Start()
+				}
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+
+	if err := sc.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if u := sc.BaseURL(); u == "http://127.0.0.1:1" || !strings.HasPrefix(u, "http://127.0.0.1:") {
+		t.Errorf("BaseURL = %s, want the automatically chosen port", u)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// External servers
+// ---------------------------------------------------------------------------
+
+func TestServerClient_BaseURLAndAuth(t *testing.T) {
+	wav := makeWAVHeader(24000, 1, 16)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pocket/health", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/pocket/tts", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(wav)
+	})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" || r.Header.Get("X-Request-Id") == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	var calls int
+	sc := NewServerClient(ServerOptions{
+		BaseURL: ts.URL + "/pocket/",
+		Header:  http.Header{"Authorization": {"Bearer secret"}},
+		BeforeRequest: func(r *http.Request) error {
+			calls++
+			r.Header.Set("X-Request-Id", strconv.Itoa(calls))
+			return nil
+		},
+	})
+	if err := sc.Health(context.Background()); err != nil {
+		t.Fatalf("Health: %v", err)
+	}
+	if _, err := sc.Generate(context.Background(), "Hello", nil); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("BeforeRequest called %d times, want 2", calls)
+	}
+	if err := sc.Start(context.Background()); err == nil {
+		t.Error("Start should refuse to launch a server for a BaseURL")
+	}
+
+	hookErr := errors.New("no token")
+	sc = NewServerClient(ServerOptions{BaseURL: ts.URL, BeforeRequest: func(*http.Request) error { return hookErr }})
+	if _, err := sc.Generate(context.Background(), "Hello", nil); !errors.Is(err, hookErr) {
+		t.Errorf("expected the BeforeRequest error, got %v", err)
+	}
+}
+
+func TestServerClient_MutualTLS(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	ts.Config.ErrorLog = log.New(io.Discard, "", 0) // expected handshake failures
+	ts.StartTLS()
+	defer ts.Close()
+
+	// The test server's own certificate doubles as CA and client certificate.
+	cert := ts.TLS.Certificates[0]
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := writeTempFile(t, "cert.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}))
+	keyFile := writeTempFile(t, "key.pem", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}))
+
+	cfg, err := LoadTLSConfig(certFile, certFile, keyFile)
+	if err != nil {
+		t.Fatalf("LoadTLSConfig: %v", err)
+	}
+	sc := NewServerClient(ServerOptions{BaseURL: ts.URL, TLSConfig: cfg})
+	if err := sc.Health(context.Background()); err != nil {
+		t.Fatalf("Health over mutual TLS: %v", err)
+	}
+
+	// Without the private CA the server is not trusted.
+	sc = NewServerClient(ServerOptions{BaseURL: ts.URL})
+	if err := sc.Health(context.Background()); err == nil {
+		t.Error("expected a certificate error without TLSConfig")
+	}
+	if _, err := LoadTLSConfig(keyFile, "", ""); err == nil {
+		t.Error("expected an error for a CA file without certificates")
+	}
+}
+
 // ---------------------------------------------------------------------------
 // Golden server test: runs only when pocket-tts is on PATH
 // ---------------------------------------------------------------------------
@@ -173,3 +403,84 @@ func TestServerClient_Golden(t *testing.T) {
 	t.Logf("server WAV: %d bytes, %d Hz, %d ch, %d-bit",
 		len(result.Data), result.SampleRate, result.Channels, result.BitsPerSample)
 }
+
+// ---------------------------------------------------------------------------
+// GenerateStream
+// ---------------------------------------------------------------------------
+
+// newStreamingServer returns a /tts handler that flushes the WAV header, then
+// blocks until release is closed before writing pcm.
+func newStreamingServer(pcm []byte, release <-chan struct{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/wav")
+		_, _ = w.Write(makeWAVHeader(24000, 1, 16))
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			return
+		}
+		_, _ = w.Write(pcm)
+	}))
+}
+
+func TestServerClient_GenerateStream(t *testing.T) {
+	pcm := []byte{1, 0, 2, 0, 3, 0}
+	release := make(chan struct{})
+	ts := newStreamingServer(pcm, release)
+	defer ts.Close()
+
+	sc := serverClientFor(ts)
+	// GenerateStream must return before the body is complete.
+	s, err := sc.GenerateStream(context.Background(), "Hello", nil)
+	if err != nil {
+		t.Fatalf("GenerateStream: %v", err)
+	}
+	defer s.Close()
+	if s.SampleRate != 24000 || s.Channels != 1 || s.BitsPerSample != 16 {
+		t.Errorf("format: got %d Hz, %d ch, %d-bit", s.SampleRate, s.Channels, s.BitsPerSample)
+	}
+
+	close(release)
+	got, err := io.ReadAll(s)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if !bytes.Equal(got, pcm) {
+		t.Errorf("PCM: got %v, want %v", got, pcm)
+	}
+}
+
+func TestServerClient_GenerateStream_Cancel(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	ts := newStreamingServer([]byte{1, 0}, release)
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sc := serverClientFor(ts)
+	s, err := sc.GenerateStream(ctx, "Hello", nil)
+	if err != nil {
+		t.Fatalf("GenerateStream: %v", err)
+	}
+	defer s.Close()
+
+	cancel()
+	_, err = io.ReadAll(s)
+	var cErr *ErrCanceled
+	if !errors.As(err, &cErr) || !errors.Is(err, context.Canceled) {
+		t.Errorf("expected ErrCanceled after cancel, got %T: %v", err, err)
+	}
+}
+
+func TestServerClient_GenerateStream_ServerError(t *testing.T) {
+	fs := newFakeServer(http.StatusOK, http.StatusInternalServerError, []byte("model error"))
+	defer fs.ts.Close()
+
+	sc := serverClientFor(fs.ts)
+	_, err := sc.GenerateStream(context.Background(), "Hello", nil)
+	var exitErr *ErrNonZeroExit
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("expected ErrNonZeroExit, got %T: %v", err, err)
+	}
+}
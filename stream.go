@@ -0,0 +1,370 @@
+package pockettts
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AudioStream is a TTS result that is read while synthesis is still running.
+//
+// The WAV header is parsed before the stream is returned, so SampleRate,
+// Channels and BitsPerSample are valid immediately. Read then yields the raw
+// PCM frames of the WAV data chunk (little-endian, interleaved) as the
+// backend produces them.
+//
+// When the backend fails after the stream was returned, Read reports the
+// failure (e.g. ErrNonZeroExit, ErrProcessTimeout or ErrCanceled) instead
+// of io.EOF. Always Close the stream, even after reading it to the end.
+type AudioStream struct {
+	// SampleRate is parsed from the WAV header. Pocket-tts produces 24000 Hz.
+	SampleRate uint32
+
+	// Channels is parsed from the WAV header.
+	Channels uint16
+
+	// BitsPerSample is parsed from the WAV header.
+	BitsPerSample uint16
+
+	body   io.Reader
+	finish func() error // reports how the backend ended; called once at EOF
+	abort  func()       // stops the backend early; called by Close before EOF
+	done   func()       // releases resources; called once by Close
+	stats  *streamStats // reported when the stream ends
+
+	mu        sync.Mutex
+	finished  bool
+	aborted   bool  // Close stopped the backend before EOF
+	readErr   error // the first failed Read, other than io.EOF
+	finishErr error
+	closeOnce sync.Once
+}
+
+// Read reads PCM bytes from the stream. It returns io.EOF only if the backend
+// finished successfully.
+func (s *AudioStream) Read(p []byte) (int, error) {
+	n, err := s.body.Read(p)
+	if errors.Is(err, io.EOF) {
+		if ferr := s.end(); ferr != nil {
+			return n, ferr
+		}
+	} else if err != nil {
+		s.mu.Lock()
+		if s.readErr == nil {
+			s.readErr = err
+		}
+		s.mu.Unlock()
+	}
+	return n, err
+}
+
+// Close stops the backend if it is still producing audio and releases the
+// resources held by the stream. It is safe to call more than once.
+func (s *AudioStream) Close() error {
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		finished := s.finished
+		s.mu.Unlock()
+		if !finished {
+			s.mu.Lock()
+			s.aborted = true
+			s.mu.Unlock()
+			if s.abort != nil {
+				s.abort()
+			}
+		}
+		_ = s.end()
+		if s.done != nil {
+			s.done()
+		}
+	})
+	return nil
+}
+
+// end calls finish exactly once, reports the outcome to stats, and returns
+// finish's result.
+func (s *AudioStream) end() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.finished {
+		s.finished = true
+		if s.finish != nil {
+			s.finishErr = s.finish()
+		}
+		if s.stats != nil {
+			s.stats.done(s.outcome())
+		}
+	}
+	return s.finishErr
+}
+
+// outcome returns the error the stream ended with, or nil if it was read to
+// the end. After an abort, finish only reports the kill, so the error is the
+// failed Read, if any, or a cancellation. s.mu must be held.
+func (s *AudioStream) outcome() error {
+	if !s.aborted {
+		if s.finishErr != nil {
+			return s.finishErr
+		}
+		return s.readErr
+	}
+	if s.readErr != nil {
+		return s.readErr
+	}
+	elapsed := time.Duration(0)
+	if s.stats != nil {
+		elapsed = time.Since(s.stats.sent)
+	}
+	return &ErrCanceled{Elapsed: elapsed, Err: context.Canceled}
+}
+
+// streamStats collects the GenerationStats of a stream, which are reported
+// to the Observer and LogWriter when it ends.
+type streamStats struct {
+	stats GenerationStats // QueueWait, SpawnTime, ExitCode and Backend
+	sent  time.Time       // when the request was sent, after queueing
+	raw   *firstByteReader
+	pcm   *firstByteReader // the data chunk, in format src
+	src   *wavData
+	obs   *observation
+	log   io.Writer
+}
+
+func (st *streamStats) done(err error) {
+	if err != nil {
+		st.obs.finished(GenerationStats{}, err)
+		return
+	}
+	s := st.stats
+	s.Duration = time.Since(st.sent)
+	s.TTFB = st.raw.since(st.sent)
+	s.Bytes = st.raw.n
+	s.AudioDuration = pcmDuration(st.pcm.n, st.src.sampleRate, st.src.channels, st.src.bitsPerSample)
+	s.derive()
+	s.log(st.log)
+	st.obs.finished(s, nil)
+}
+
+// GenerateStream is like GenerateWithOptions, but returns as soon as the WAV
+// header has been written by `pocket-tts generate --output-path -`. The PCM
+// body is then read from the subprocess stdout while synthesis continues.
+// Sample format conversion (opts.SampleRate, Channels, BitsPerSample) is
+// applied on the fly; opts.Format and opts.Encoder are ignored.
+//
+// The Client's Concurrency slot is held until the stream is closed.
+// Returns ErrEmptyText, ErrExecutableNotFound, ErrProcessTimeout, ErrCanceled
+// or ErrNonZeroExit when the process fails before the header is available;
+// later failures are reported by AudioStream.Read.
+func (c *Client) GenerateStream(ctx context.Context, text string, opts *GenerateOptions) (*AudioStream, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, ErrEmptyText
+	}
+	if err := opts.validateConversion(); err != nil {
+		return nil, err
+	}
+
+	obs := observe(c.opts.Observer, ModeCLI, opts.priority())
+	release, queued, err := c.acquire(ctx, opts.priority())
+	if err != nil {
+		obs.done(nil, err)
+		return nil, err
+	}
+	obs.admitted(queued)
+
+	start := time.Now()
+	p, err := c.newRunner(opts).start(ctx, c.buildArgs(opts), []byte(text))
+	if err != nil {
+		release()
+		obs.done(nil, err)
+		return nil, err
+	}
+	spawn := time.Since(start)
+
+	raw := &firstByteReader{r: p.stdout}
+	br := bufio.NewReader(raw)
+	src, err := readWAVStreamHeader(br)
+	if err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			// stdout ended: the process exited (or is exiting) on its own,
+			// and its exit status explains why better than the short header.
+			if werr := p.wait(); werr != nil {
+				err = werr
+			} else if errors.Is(err, io.EOF) {
+				err = &ErrNonZeroExit{ExitCode: 0, Stderr: "empty stdout — no WAV produced"}
+			}
+		} else {
+			// The process is still writing something that is not a WAV
+			// stream; its exit status after our kill says nothing.
+			p.kill()
+			_ = p.wait()
+		}
+		release()
+		obs.done(nil, err)
+		return nil, err
+	}
+
+	pcm := &firstByteReader{r: br}
+	s := newAudioStream(pcm, src, opts)
+	s.finish, s.abort, s.done = p.wait, p.kill, release
+	s.stats = &streamStats{
+		stats: GenerationStats{QueueWait: queued, SpawnTime: spawn, Backend: ModeCLI},
+		sent:  start,
+		raw:   raw,
+		pcm:   pcm,
+		src:   src,
+		obs:   obs,
+		log:   c.opts.LogWriter,
+	}
+	return s, nil
+}
+
+// GenerateStream is like Generate, but returns as soon as the WAV header of
+// the /tts response has arrived. The PCM body is then read from the HTTP
+// response while the server continues synthesizing.
+//
+// Cancelling ctx aborts the HTTP request; a subsequent Read returns
+// ErrCanceled, or ErrProcessTimeout if ctx's deadline passed. opts is mapped
+// onto the /tts form fields as in Synthesize and may be nil.
+func (s *ServerClient) GenerateStream(ctx context.Context, text string, opts *GenerateOptions) (*AudioStream, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, ErrEmptyText
+	}
+	if err := opts.validateConversion(); err != nil {
+		return nil, err
+	}
+
+	so := opts.serverOptions()
+	obs := observe(s.opts.Observer, ModeServer, so.Priority)
+	start := time.Now()
+	resp, queued, err := s.postTTS(ctx, text, so, obs)
+	if err != nil {
+		obs.done(nil, err)
+		return nil, err
+	}
+
+	sent := start.Add(queued)
+	raw := &firstByteReader{r: &ctxReader{ctx: ctx, start: sent, r: resp.Body}}
+	br := bufio.NewReader(raw)
+	src, err := readWAVStreamHeader(br)
+	if err != nil {
+		resp.Body.Close()
+		obs.done(nil, err)
+		return nil, err
+	}
+
+	pcm := &firstByteReader{r: br}
+	stream := newAudioStream(pcm, src, opts)
+	stream.done = func() { _ = resp.Body.Close() }
+	stream.stats = &streamStats{
+		stats: GenerationStats{QueueWait: queued, ExitCode: resp.StatusCode, Backend: ModeServer},
+		sent:  sent,
+		raw:   raw,
+		pcm:   pcm,
+		src:   src,
+		obs:   obs,
+		log:   s.opts.LogWriter,
+	}
+	return stream, nil
+}
+
+// newAudioStream returns a stream reading PCM in format src from body,
+// converted to the sample format requested in opts (which may be nil).
+func newAudioStream(body io.Reader, src *wavData, opts *GenerateOptions) *AudioStream {
+	s := &AudioStream{
+		SampleRate:    src.sampleRate,
+		Channels:      src.channels,
+		BitsPerSample: src.bitsPerSample,
+		body:          body,
+	}
+	if !opts.needsConversion() {
+		return s
+	}
+	if dst := opts.targetFormat(src); !sameFormat(src, dst) {
+		s.SampleRate, s.Channels, s.BitsPerSample = dst.sampleRate, dst.channels, dst.bitsPerSample
+		s.body = newConvertReader(body, src, dst)
+	}
+	return s
+}
+
+// ctxReader reports read failures caused by ctx ending as ErrProcessTimeout
+// or ErrCanceled, matching the errors returned by the CLI stream.
+type ctxReader struct {
+	ctx   context.Context
+	start time.Time // when the request was sent
+	r     io.Reader
+}
+
+func (c *ctxReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) && c.ctx.Err() != nil {
+		return n, contextError(c.ctx, time.Since(c.start), "")
+	}
+	return n, err
+}
+
+// maxWAVFmtChunk bounds the fmt chunk size accepted from a stream. Real fmt
+// chunks are 16 to 40 bytes; the bound keeps a corrupt header from forcing
+// a huge allocation.
+const maxWAVFmtChunk = 1024
+
+// readWAVStreamHeader consumes a WAV header from r up to and including the
+// data chunk header, leaving r positioned at the first PCM byte, and returns
+// the stream's sample format (with no PCM). The data chunk size is ignored
+// because streaming writers cannot know it in advance.
+func readWAVStreamHeader(r io.Reader) (*wavData, error) {
+	// hdr collects the RIFF header, the fmt chunk and the data chunk header so
+	// the result can be validated by parseWAVHeader. Other chunks are skipped.
+	hdr := make([]byte, 12, 64)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, fmt.Errorf("pockettts: read WAV header: %w", err)
+	}
+	if string(hdr[0:4]) != "RIFF" || string(hdr[8:12]) != "WAVE" {
+		return nil, fmt.Errorf("pockettts: output is not a WAV stream")
+	}
+
+	var formatTag uint16
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return nil, fmt.Errorf("pockettts: read WAV chunk header: %w", err)
+		}
+		id := string(chunk[0:4])
+		size := binary.LittleEndian.Uint32(chunk[4:8])
+
+		switch id {
+		case "data":
+			hdr = append(hdr, chunk[:]...)
+			sr, ch, bps, err := parseWAVHeader(hdr)
+			if err != nil {
+				return nil, err
+			}
+			return &wavData{sampleRate: sr, channels: ch, bitsPerSample: bps, formatTag: formatTag}, nil
+		case "fmt ":
+			if size > maxWAVFmtChunk {
+				return nil, fmt.Errorf("%w: fmt chunk of %d bytes", ErrMalformedWAV, size)
+			}
+			body := make([]byte, int(size)+int(size%2)) // RIFF chunks are word aligned
+			if _, err := io.ReadFull(r, body); err != nil {
+				return nil, fmt.Errorf("pockettts: read WAV fmt chunk: %w", err)
+			}
+			hdr = append(hdr, chunk[:]...)
+			hdr = append(hdr, body...)
+			if len(body) >= 2 {
+				formatTag = binary.LittleEndian.Uint16(body)
+			}
+			if formatTag == wavFormatExtensible && len(body) >= 26 {
+				formatTag = binary.LittleEndian.Uint16(body[24:]) // SubFormat GUID
+			}
+		default:
+			if _, err := io.CopyN(io.Discard, r, int64(size)+int64(size%2)); err != nil {
+				return nil, fmt.Errorf("pockettts: skip WAV %q chunk: %w", id, err)
+			}
+		}
+	}
+}
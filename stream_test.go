@@ -0,0 +1,193 @@
+package pockettts
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeExecutable writes an executable shell script that stands in for
+// pocket-tts and returns its path. The script ignores its arguments.
+func fakeExecutable(t *testing.T, script string) string {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("'sh' not found on PATH")
+	}
+	path := filepath.Join(t.TempDir(), "pocket-tts")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0o755); err != nil {
+		t.Fatalf("write fake executable: %v", err)
+	}
+	return path
+}
+
+// writeTempFile writes data to a file in a test temp dir and returns its path.
+func writeTempFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("write temp file: %v", err)
+	}
+	return path
+}
+
+// ---------------------------------------------------------------------------
+// WAV stream header parsing
+// ---------------------------------------------------------------------------
+
+func TestReadWAVStreamHeader_SkipsExtraChunks(t *testing.T) {
+	hdr := makeWAVHeader(24000, 1, 16)
+	// Insert a LIST chunk between fmt and data.
+	var buf bytes.Buffer
+	buf.Write(hdr[:36])
+	buf.WriteString("LIST")
+	buf.Write([]byte{3, 0, 0, 0, 'a', 'b', 'c', 0}) // odd size + pad byte
+	buf.Write(hdr[36:])
+	buf.Write([]byte{1, 2, 3, 4})
+
+	r := bytes.NewReader(buf.Bytes())
+	f, err := readWAVStreamHeader(r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if f.sampleRate != 24000 || f.channels != 1 || f.bitsPerSample != 16 || f.formatTag != wavFormatPCM {
+		t.Errorf("format: got %d Hz, %d ch, %d-bit, tag %d", f.sampleRate, f.channels, f.bitsPerSample, f.formatTag)
+	}
+	rest, _ := io.ReadAll(r)
+	if !bytes.Equal(rest, []byte{1, 2, 3, 4}) {
+		t.Errorf("reader not positioned at PCM data, rest = %v", rest)
+	}
+}
+
+func TestReadWAVStreamHeader_NotWAV(t *testing.T) {
+	_, err := readWAVStreamHeader(bytes.NewReader([]byte("ID3\x04 this is an mp3 file")))
+	if err == nil {
+		t.Fatal("expected error for non-WAV stream")
+	}
+}
+
+func TestReadWAVStreamHeader_OversizedFmtChunk(t *testing.T) {
+	hdr := makeWAVHeader(24000, 1, 16)
+	binary.LittleEndian.PutUint32(hdr[16:20], 0xFFFFFFF0) // fmt chunk size
+	_, err := readWAVStreamHeader(bytes.NewReader(hdr))
+	if !errors.Is(err, ErrMalformedWAV) {
+		t.Fatalf("expected ErrMalformedWAV, got %v", err)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Client.GenerateStream
+// ---------------------------------------------------------------------------
+
+func TestClient_GenerateStream(t *testing.T) {
+	pcm := []byte{1, 0, 2, 0, 3, 0, 4, 0}
+	wavPath := writeTempFile(t, "out.wav", append(makeWAVHeader(24000, 1, 16), pcm...))
+	exe := fakeExecutable(t, "cat >/dev/null; cat "+wavPath)
+
+	c := NewClient(Options{ExecutablePath: exe})
+	s, err := c.GenerateStream(context.Background(), "Hello", nil)
+	if err != nil {
+		t.Fatalf("GenerateStream: %v", err)
+	}
+	defer s.Close()
+
+	if s.SampleRate != 24000 || s.Channels != 1 || s.BitsPerSample != 16 {
+		t.Errorf("format: got %d Hz, %d ch, %d-bit", s.SampleRate, s.Channels, s.BitsPerSample)
+	}
+	got, err := io.ReadAll(s)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if !bytes.Equal(got, pcm) {
+		t.Errorf("PCM: got %v, want %v", got, pcm)
+	}
+}
+
+func TestClient_GenerateStream_FailureAfterHeader(t *testing.T) {
+	wavPath := writeTempFile(t, "out.wav", makeWAVHeader(24000, 1, 16))
+	exe := fakeExecutable(t, "cat >/dev/null; cat "+wavPath+"; echo boom >&2; exit 3")
+
+	c := NewClient(Options{ExecutablePath: exe})
+	s, err := c.GenerateStream(context.Background(), "Hello", nil)
+	if err != nil {
+		t.Fatalf("GenerateStream: %v", err)
+	}
+	defer s.Close()
+
+	_, err = io.ReadAll(s)
+	var exitErr *ErrNonZeroExit
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("expected ErrNonZeroExit from Read, got %T: %v", err, err)
+	}
+	if exitErr.ExitCode != 3 {
+		t.Errorf("exit code: got %d, want 3", exitErr.ExitCode)
+	}
+}
+
+func TestClient_GenerateStream_NoOutput(t *testing.T) {
+	exe := fakeExecutable(t, "cat >/dev/null; exit 0")
+
+	c := NewClient(Options{ExecutablePath: exe})
+	_, err := c.GenerateStream(context.Background(), "Hello", nil)
+	var exitErr *ErrNonZeroExit
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("expected ErrNonZeroExit, got %T: %v", err, err)
+	}
+}
+
+func TestClient_GenerateStream_NotWAVKeepsParseError(t *testing.T) {
+	exe := fakeExecutable(t, "cat >/dev/null; echo 'this is not a wav file'; exec sleep 10")
+
+	c := NewClient(Options{ExecutablePath: exe})
+	_, err := c.GenerateStream(context.Background(), "Hello", nil)
+	var exitErr *ErrNonZeroExit
+	if err == nil || errors.As(err, &exitErr) || !strings.Contains(err.Error(), "not a WAV stream") {
+		t.Fatalf("expected the header parse error, got %T: %v", err, err)
+	}
+}
+
+func TestClient_GenerateStream_ExitBeforeHeader(t *testing.T) {
+	exe := fakeExecutable(t, "cat >/dev/null; printf RIFF; echo 'ModuleNotFoundError: No module named torch' >&2; exit 1")
+
+	c := NewClient(Options{ExecutablePath: exe})
+	_, err := c.GenerateStream(context.Background(), "Hello", nil)
+	var dep *ErrMissingDependency
+	if !errors.As(err, &dep) {
+		t.Fatalf("expected the process's exit error, got %T: %v", err, err)
+	}
+}
+
+func TestClient_GenerateStream_CloseReleasesSlot(t *testing.T) {
+	wavPath := writeTempFile(t, "out.wav", makeWAVHeader(24000, 1, 16))
+	exe := fakeExecutable(t, "cat >/dev/null; cat "+wavPath+"; exec sleep 10")
+
+	c := NewClient(Options{ExecutablePath: exe, Concurrency: 1})
+	s, err := c.GenerateStream(context.Background(), "Hello", nil)
+	if err != nil {
+		t.Fatalf("GenerateStream: %v", err)
+	}
+	if c.slots.active() != 1 {
+		t.Errorf("concurrency slot should be held while streaming")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		_ = s.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not stop the subprocess")
+	}
+	if c.slots.active() != 0 {
+		t.Errorf("concurrency slot not released after Close")
+	}
+}
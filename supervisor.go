@@ -0,0 +1,372 @@
+package pockettts
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// serverProcess is a running `pocket-tts serve` process. A goroutine waits
+// for it, so exit is observable through done without polling.
+type serverProcess struct {
+	cmd    *exec.Cmd
+	stderr *tailBuffer
+	done   chan struct{} // closed when the process has exited
+	err    error         // result of cmd.Wait; valid after done is closed
+}
+
+func watchProcess(cmd *exec.Cmd, stderr *tailBuffer) *serverProcess {
+	p := &serverProcess{cmd: cmd, stderr: stderr, done: make(chan struct{})}
+	go func() {
+		p.err = waitProcess(cmd)
+		close(p.done)
+	}()
+	return p
+}
+
+func (p *serverProcess) pid() int {
+	return p.cmd.Process.Pid
+}
+
+// kill kills the process group, if the process is still running, and waits
+// for the process to exit.
+func (p *serverProcess) kill() error {
+	select {
+	case <-p.done:
+		return nil
+	default:
+	}
+	if err := killProcessGroup(p.cmd.Process); err != nil {
+		select {
+		case <-p.done: // exited concurrently
+			return nil
+		default:
+			return err
+		}
+	}
+	<-p.done
+	return nil
+}
+
+// terminate sends SIGTERM to the process group and waits for the process to
+// exit; waitProcess kills any children left behind. If ctx is done first, the
+// group is killed and ctx.Err() is returned.
+func (p *serverProcess) terminate(ctx context.Context) error {
+	if ctx.Err() == nil {
+		select {
+		case <-p.done:
+		default:
+			if err := terminateProcessGroup(p.cmd.Process); err != nil {
+				return p.kill()
+			}
+			select {
+			case <-p.done:
+			case <-ctx.Done():
+			}
+		}
+	}
+	if err := p.kill(); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// exitError describes how the process ended.
+func (p *serverProcess) exitError() error {
+	if p.err == nil {
+		return fmt.Errorf("pockettts: server process %d exited", p.pid())
+	}
+	return fmt.Errorf("pockettts: server process %d exited: %w", p.pid(), p.err)
+}
+
+// startupError describes a process that exited before becoming healthy,
+// classifying its stderr like a failed CLI run. voice is the server's default
+// voice. It must be called after done is closed.
+func (p *serverProcess) startupError(voice string) error {
+	return fmt.Errorf("pockettts: server exited during startup: %w", exitError(p.cmd.ProcessState, p.stderr, voice))
+}
+
+// tailBuffer is an io.Writer that keeps the last max bytes written to it, so
+// that a long-running server's stderr can be reported without growing
+// without bound.
+type tailBuffer struct {
+	max int
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf = append(b.buf, p...)
+	if over := len(b.buf) - b.max; over > 0 {
+		b.buf = append(b.buf[:0], b.buf[over:]...)
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.buf)
+}
+
+// ServerEventType identifies a server lifecycle event.
+type ServerEventType string
+
+const (
+	// ServerStarted: Start launched the server and it became healthy.
+	ServerStarted ServerEventType = "started"
+
+	// ServerExited: the supervised process exited unexpectedly (Err).
+	ServerExited ServerEventType = "exited"
+
+	// ServerUnhealthy: the supervised process failed
+	// HealthFailureThreshold consecutive health checks (Err) and is killed.
+	ServerUnhealthy ServerEventType = "unhealthy"
+
+	// ServerRestarting: the supervisor will start a new process after Delay.
+	ServerRestarting ServerEventType = "restarting"
+
+	// ServerRestartFailed: a restart attempt failed (Err); another follows.
+	ServerRestartFailed ServerEventType = "restart_failed"
+
+	// ServerRestarted: a new process is healthy and serving requests.
+	ServerRestarted ServerEventType = "restarted"
+
+	// ServerGaveUp: the restart budget is exhausted (Err); the supervisor
+	// stops and requests fail with ErrRestartBudgetExhausted.
+	ServerGaveUp ServerEventType = "gave_up"
+
+	// ServerStopped: Stop terminated the server.
+	ServerStopped ServerEventType = "stopped"
+)
+
+// ServerEvent is a lifecycle event of a managed server, delivered to
+// ServerOptions.OnEvent.
+type ServerEvent struct {
+	Type ServerEventType
+	Time time.Time
+
+	// PID is the process ID the event refers to, if any.
+	PID int
+
+	// Attempt counts the restarts within the current RestartWindow
+	// (ServerRestarting, ServerRestartFailed, ServerRestarted).
+	Attempt int
+
+	// Delay is the backoff before the restart (ServerRestarting).
+	Delay time.Duration
+
+	// Err is the failure that caused the event, if any.
+	Err error
+}
+
+func (s *ServerClient) emit(ev ServerEvent) {
+	if s.opts.OnEvent == nil {
+		return
+	}
+	ev.Time = time.Now()
+	s.opts.OnEvent(ev)
+}
+
+// supervisor watches a ServerClient's process and restarts it.
+type supervisor struct {
+	cancel context.CancelFunc
+	done   chan struct{} // closed when the supervise loop returns
+
+	mu     sync.Mutex
+	ready  chan struct{} // closed while the server is serving
+	failed error         // set once the supervisor has given up
+}
+
+func (s *ServerClient) startSupervisor(proc *serverProcess) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sup := &supervisor{cancel: cancel, done: make(chan struct{}), ready: make(chan struct{})}
+	close(sup.ready)
+
+	s.mu.Lock()
+	s.sup = sup
+	s.mu.Unlock()
+	go s.supervise(ctx, sup, proc)
+}
+
+func (s *ServerClient) stopSupervisor() {
+	s.mu.Lock()
+	sup := s.sup
+	s.sup = nil
+	s.mu.Unlock()
+	if sup == nil {
+		return
+	}
+	sup.cancel()
+	<-sup.done
+	sup.fail(fmt.Errorf("%w: server stopped", ErrServerRestarting))
+}
+
+// restarting marks the server as unavailable until ready is called.
+func (sup *supervisor) restarting() {
+	sup.mu.Lock()
+	defer sup.mu.Unlock()
+	select {
+	case <-sup.ready:
+		sup.ready = make(chan struct{})
+	default:
+	}
+}
+
+// serving releases requests waiting for the restart.
+func (sup *supervisor) serving() {
+	sup.mu.Lock()
+	defer sup.mu.Unlock()
+	select {
+	case <-sup.ready:
+	default:
+		close(sup.ready)
+	}
+}
+
+// fail makes all current and future requests fail with err.
+func (sup *supervisor) fail(err error) {
+	sup.mu.Lock()
+	if sup.failed == nil {
+		sup.failed = err
+	}
+	sup.mu.Unlock()
+	sup.serving() // wake waiters so they see failed
+}
+
+// awaitReady gates requests on a supervised server: it returns nil when the
+// server is serving, waits or fails fast while it is restarting, and fails
+// once the supervisor has given up. Unsupervised servers are never gated.
+func (s *ServerClient) awaitReady(ctx context.Context) error {
+	s.mu.Lock()
+	sup := s.sup
+	s.mu.Unlock()
+	if sup == nil {
+		return nil
+	}
+
+	sup.mu.Lock()
+	ready, failed := sup.ready, sup.failed
+	sup.mu.Unlock()
+	if failed != nil {
+		return failed
+	}
+	select {
+	case <-ready:
+		return nil
+	default:
+	}
+	if !s.opts.QueueDuringRestart {
+		return ErrServerRestarting
+	}
+
+	start := time.Now()
+	select {
+	case <-ready:
+		sup.mu.Lock()
+		failed = sup.failed
+		sup.mu.Unlock()
+		return failed
+	case <-ctx.Done():
+		return waitError(ctx.Err(), time.Since(start))
+	}
+}
+
+// supervise watches proc and replaces it whenever it exits or stops
+// answering health checks, until ctx is cancelled or the restart budget is
+// exhausted.
+func (s *ServerClient) supervise(ctx context.Context, sup *supervisor, proc *serverProcess) {
+	defer close(sup.done)
+
+	ticker := time.NewTicker(s.opts.healthCheckInterval())
+	defer ticker.Stop()
+
+	var (
+		restarts  []time.Time // restart times within the window
+		backoff   = s.opts.restartBackoff()
+		upSince   = time.Now()
+		badChecks int
+	)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-proc.done:
+			s.emit(ServerEvent{Type: ServerExited, PID: proc.pid(), Err: proc.exitError()})
+
+		case <-ticker.C:
+			hctx, cancel := context.WithTimeout(ctx, s.opts.healthCheckInterval())
+			err := s.Health(hctx)
+			cancel()
+			if ctx.Err() != nil {
+				return
+			}
+			if err == nil {
+				badChecks = 0
+				if time.Since(upSince) >= s.opts.maxRestartBackoff() {
+					backoff = s.opts.restartBackoff()
+				}
+				continue
+			}
+			if badChecks++; badChecks < s.opts.healthFailureThreshold() {
+				continue
+			}
+			s.emit(ServerEvent{Type: ServerUnhealthy, PID: proc.pid(), Err: err})
+			_ = proc.kill()
+		}
+
+		badChecks = 0
+		sup.restarting()
+		for {
+			now := time.Now()
+			for len(restarts) > 0 && now.Sub(restarts[0]) > s.opts.restartWindow() {
+				restarts = restarts[1:]
+			}
+			if max := s.opts.maxRestarts(); max >= 0 && len(restarts) >= max {
+				err := fmt.Errorf("%w: %d restarts within %s", ErrRestartBudgetExhausted, len(restarts), s.opts.restartWindow())
+				sup.fail(err)
+				s.emit(ServerEvent{Type: ServerGaveUp, Attempt: len(restarts), Err: err})
+				return
+			}
+			restarts = append(restarts, now)
+			attempt := len(restarts)
+
+			s.emit(ServerEvent{Type: ServerRestarting, Attempt: attempt, Delay: backoff})
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return
+			}
+			backoff = min(2*backoff, s.opts.maxRestartBackoff())
+
+			next, err := s.launch(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				s.emit(ServerEvent{Type: ServerRestartFailed, Attempt: attempt, Err: err})
+				continue
+			}
+
+			s.mu.Lock()
+			if s.sup != sup { // Stop ran concurrently
+				s.mu.Unlock()
+				_ = next.kill()
+				return
+			}
+			s.proc = next
+			s.mu.Unlock()
+			proc = next
+			upSince = time.Now()
+			s.emit(ServerEvent{Type: ServerRestarted, PID: proc.pid(), Attempt: attempt})
+			sup.serving()
+			break
+		}
+	}
+}
@@ -0,0 +1,416 @@
+package pockettts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// eventLog records the lifecycle events of a ServerClient.
+type eventLog struct {
+	mu     sync.Mutex
+	events []ServerEvent
+}
+
+func (l *eventLog) record(ev ServerEvent) {
+	l.mu.Lock()
+	l.events = append(l.events, ev)
+	l.mu.Unlock()
+}
+
+func (l *eventLog) count(typ ServerEventType) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := 0
+	for _, ev := range l.events {
+		if ev.Type == typ {
+			n++
+		}
+	}
+	return n
+}
+
+// supervisedServer starts a supervised ServerClient running script, with
+// health and /tts answered by a test server on the client's port.
+func supervisedServer(t *testing.T, script string, opts ServerOptions) (*ServerClient, *poolTestServer, *eventLog) {
+	t.Helper()
+	opts.Supervise = true
+	return managedServer(t, script, nil, opts)
+}
+
+// managedServer starts a ServerClient running script, with health and /tts
+// answered by a test server on the client's port that blocks /tts requests
+// on hold, if non-nil.
+func managedServer(t *testing.T, script string, hold chan struct{}, opts ServerOptions) (*ServerClient, *poolTestServer, *eventLog) {
+	t.Helper()
+	srv := newPoolTestServer(t, hold)
+	port, _ := strconv.Atoi(srv.ts.URL[strings.LastIndex(srv.ts.URL, ":")+1:])
+
+	log := &eventLog{}
+	opts.Host = "127.0.0.1"
+	opts.Port = port
+	opts.ExecutablePath = fakeExecutable(t, script)
+	opts.OnEvent = log.record
+	if opts.StartupTimeout == 0 {
+		opts.StartupTimeout = 2 * time.Second
+	}
+	if opts.RestartBackoff == 0 {
+		opts.RestartBackoff = 10 * time.Millisecond
+	}
+
+	s := NewServerClient(opts)
+	s.checkPort = standInServer
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(func() { _ = s.Stop() })
+	return s, srv, log
+}
+
+// standInServer replaces the port check of clients whose launched "server"
+// is a shell script standing in for the test server already listening on
+// the port.
+func standInServer(string) error { return nil }
+
+// crashOnceScript exits shortly after its first run and keeps running on
+// later ones.
+func crashOnceScript(t *testing.T, firstRun string) string {
+	mark := filepath.Join(t.TempDir(), "started")
+	return fmt.Sprintf(`if [ -e %q ]; then exec sleep 30; fi; touch %q; %s`, mark, mark, firstRun)
+}
+
+// ---------------------------------------------------------------------------
+// Restarts
+// ---------------------------------------------------------------------------
+
+func TestSupervisor_RestartsExitedProcess(t *testing.T) {
+	s, _, log := supervisedServer(t, crashOnceScript(t, "sleep 0.1; exit 3"), ServerOptions{})
+
+	waitFor(t, "a restart", func() bool { return log.count(ServerRestarted) == 1 })
+	if log.count(ServerExited) != 1 || log.count(ServerRestarting) != 1 {
+		t.Errorf("unexpected events: %+v", log.events)
+	}
+	if _, err := s.Generate(context.Background(), "Hello", nil); err != nil {
+		t.Errorf("Generate after restart: %v", err)
+	}
+
+	if err := s.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if log.count(ServerStopped) != 1 {
+		t.Error("expected a stopped event")
+	}
+}
+
+func TestSupervisor_RestartsUnhealthyProcess(t *testing.T) {
+	_, srv, log := supervisedServer(t, "exec sleep 30", ServerOptions{
+		HealthCheckInterval:    10 * time.Millisecond,
+		HealthFailureThreshold: 2,
+	})
+
+	srv.healthy.Store(false)
+	waitFor(t, "unhealthy event", func() bool { return log.count(ServerUnhealthy) == 1 })
+	srv.healthy.Store(true)
+	waitFor(t, "a restart", func() bool { return log.count(ServerRestarted) == 1 })
+}
+
+func TestSupervisor_RestartBudget(t *testing.T) {
+	s, _, log := supervisedServer(t, "sleep 0.05", ServerOptions{MaxRestarts: 2})
+
+	waitFor(t, "supervisor to give up", func() bool { return log.count(ServerGaveUp) == 1 })
+	if n := log.count(ServerRestarted); n != 2 {
+		t.Errorf("expected 2 restarts before giving up, got %d", n)
+	}
+	_, err := s.Generate(context.Background(), "Hello", nil)
+	if !errors.Is(err, ErrRestartBudgetExhausted) {
+		t.Fatalf("expected ErrRestartBudgetExhausted, got %v", err)
+	}
+	if IsRetryable(err) {
+		t.Error("an exhausted restart budget should not be retryable")
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Requests during restarts
+// ---------------------------------------------------------------------------
+
+func TestSupervisor_FailsFastDuringRestart(t *testing.T) {
+	s, srv, log := supervisedServer(t, crashOnceScript(t, "sleep 0.1; exit 1"), ServerOptions{RestartBackoff: time.Hour})
+
+	waitFor(t, "restart to begin", func() bool { return log.count(ServerRestarting) == 1 })
+	if _, err := s.Generate(context.Background(), "Hello", nil); !errors.Is(err, ErrServerRestarting) {
+		t.Errorf("expected ErrServerRestarting, got %v", err)
+	}
+	if srv.tts.Load() != 0 {
+		t.Error("request reached the server during a restart")
+	}
+}
+
+func TestSupervisor_QueuesDuringRestart(t *testing.T) {
+	s, _, log := supervisedServer(t, crashOnceScript(t, "sleep 0.1; exit 1"), ServerOptions{
+		RestartBackoff:     200 * time.Millisecond,
+		QueueDuringRestart: true,
+	})
+
+	waitFor(t, "restart to begin", func() bool { return log.count(ServerRestarting) == 1 })
+	if _, err := s.Generate(context.Background(), "Hello", nil); err != nil {
+		t.Fatalf("queued Generate: %v", err)
+	}
+	if log.count(ServerRestarted) != 1 {
+		t.Error("queued request completed before the restart")
+	}
+
+	// A waiting request is bounded by its context.
+	s2, _, log2 := supervisedServer(t, crashOnceScript(t, "sleep 0.1; exit 1"), ServerOptions{
+		RestartBackoff:     time.Hour,
+		QueueDuringRestart: true,
+	})
+	waitFor(t, "restart to begin", func() bool { return log2.count(ServerRestarting) == 1 })
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	var timeout *ErrQueueTimeout
+	if _, err := s2.Generate(ctx, "Hello", nil); !errors.As(err, &timeout) {
+		t.Errorf("expected ErrQueueTimeout, got %v", err)
+	}
+}
+
+func TestServerClient_UnsupervisedEvents(t *testing.T) {
+	var log eventLog
+	s := NewServerClient(ServerOptions{OnEvent: log.record})
+	if err := s.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if len(log.events) != 0 {
+		t.Errorf("Stop without Start should emit nothing, got %+v", log.events)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Shutdown
+// ---------------------------------------------------------------------------
+
+func TestServerClient_ShutdownDrainsInFlight(t *testing.T) {
+	hold := make(chan struct{})
+	s, srv, log := managedServer(t, "exec sleep 30", hold, ServerOptions{})
+
+	generated := make(chan error, 1)
+	go func() {
+		_, err := s.Generate(context.Background(), "Hello", nil)
+		generated <- err
+	}()
+	waitFor(t, "request to arrive", func() bool { return srv.tts.Load() == 1 })
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- s.Shutdown(context.Background()) }()
+	waitFor(t, "shutdown to begin", func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.stopping
+	})
+	if _, err := s.Generate(context.Background(), "Hello", nil); !errors.Is(err, ErrServerStopped) {
+		t.Errorf("expected ErrServerStopped, got %v", err)
+	}
+	select {
+	case err := <-stopped:
+		t.Fatalf("Shutdown returned with a request in flight: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(hold)
+	if err := <-generated; err != nil {
+		t.Errorf("in-flight Generate: %v", err)
+	}
+	if err := <-stopped; err != nil {
+		t.Errorf("Shutdown: %v", err)
+	}
+	if log.count(ServerStopped) != 1 {
+		t.Error("expected a stopped event")
+	}
+}
+
+func TestServerClient_ShutdownKillsAfterDeadline(t *testing.T) {
+	// Signals ignored by the shell stay ignored across exec.
+	s, _, _ := managedServer(t, "trap '' TERM; exec sleep 30", nil, ServerOptions{})
+	proc := s.proc
+	waitFor(t, "server to ignore SIGTERM", func() bool { return ignoresSIGTERM(proc.pid()) })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := s.Shutdown(ctx); !errors.Is(err, ctx.Err()) || ctx.Err() == nil {
+		t.Errorf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("Shutdown did not kill the server after the deadline")
+	}
+	select {
+	case <-proc.done:
+	default:
+		t.Error("server process still running after Shutdown")
+	}
+}
+
+func TestServerClient_ShutdownReapsChildren(t *testing.T) {
+	if _, err := os.Stat("/proc/self/stat"); err != nil {
+		t.Skip("/proc not available")
+	}
+	pidFile := filepath.Join(t.TempDir(), "child.pid")
+	script := fmt.Sprintf(`trap 'exit 0' TERM; (trap '' TERM; exec sleep 30) & echo $! > %q; wait`, pidFile)
+	s, _, _ := managedServer(t, script, nil, ServerOptions{})
+
+	var child int
+	waitFor(t, "child to start", func() bool {
+		b, err := os.ReadFile(pidFile)
+		if err != nil {
+			return false
+		}
+		child, err = strconv.Atoi(strings.TrimSpace(string(b)))
+		return err == nil
+	})
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	waitFor(t, "child to exit", func() bool { return processGone(child) })
+}
+
+func TestServerClient_ShutdownTimeoutThenReuse(t *testing.T) {
+	s := NewServerClient(ServerOptions{})
+	first, err := s.beginRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown without a process: %v", err)
+	}
+
+	// Reopen the client as Start does, while the first request is still open.
+	s.mu.Lock()
+	s.stopping = false
+	s.mu.Unlock()
+	second, err := s.beginRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	first()
+	second()
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- s.Shutdown(context.Background()) }()
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Errorf("Shutdown: %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Shutdown did not see the requests finish")
+	}
+}
+
+func TestWatchProcess_KillsChildrenOfExitedLeader(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("children of an exited leader are only killed on Linux")
+	}
+	pidFile := filepath.Join(t.TempDir(), "child.pid")
+	cmd := exec.Command("sh", "-c", fmt.Sprintf(`sleep 30 </dev/null >/dev/null 2>&1 & echo $! > %q`, pidFile))
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	p := watchProcess(cmd, &tailBuffer{max: 64})
+	<-p.done
+
+	b, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	child, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "child to exit", func() bool { return processGone(child) })
+}
+
+// processGone reports whether process pid has exited, according to /proc. A
+// killed child may linger as a zombie until init reaps it.
+func processGone(pid int) bool {
+	b, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return true
+	}
+	fields := strings.Fields(string(b[strings.LastIndexByte(string(b), ')')+1:]))
+	return len(fields) > 0 && fields[0] == "Z"
+}
+
+// ignoresSIGTERM reports whether process pid ignores SIGTERM, according to
+// /proc. Without /proc it assumes so after a short delay.
+func ignoresSIGTERM(pid int) bool {
+	b, err := os.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		time.Sleep(100 * time.Millisecond)
+		return true
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if v, ok := strings.CutPrefix(line, "SigIgn:"); ok {
+			mask, err := strconv.ParseUint(strings.TrimSpace(v), 16, 64)
+			return err == nil && mask&(1<<(15-1)) != 0 // SIGTERM is 15
+		}
+	}
+	return false
+}
+
+// ---------------------------------------------------------------------------
+// Process lifetime
+// ---------------------------------------------------------------------------
+
+func TestServerClient_StartContextOnlyBoundsStartup(t *testing.T) {
+	srv := newPoolTestServer(t, nil)
+	port, _ := strconv.Atoi(srv.ts.URL[strings.LastIndex(srv.ts.URL, ":")+1:])
+	s := NewServerClient(ServerOptions{Host: "127.0.0.1", Port: port, ExecutablePath: fakeExecutable(t, "exec sleep 30")})
+	s.checkPort = standInServer
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	if err := s.Start(ctx); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(func() { _ = s.Stop() })
+	proc := s.proc
+	cancel()
+
+	select {
+	case <-proc.done:
+		t.Fatal("cancelling the Start context stopped the server")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if _, err := s.Generate(context.Background(), "Hello", nil); err != nil {
+		t.Errorf("Generate after the Start context ended: %v", err)
+	}
+}
+
+func TestServerClient_Lifetime(t *testing.T) {
+	lifetime, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s, _, log := managedServer(t, "exec sleep 30", nil, ServerOptions{Lifetime: lifetime})
+	proc := s.proc
+
+	cancel()
+	waitFor(t, "server to stop", func() bool { return log.count(ServerStopped) == 1 })
+	select {
+	case <-proc.done:
+	default:
+		t.Error("server process still running after its lifetime ended")
+	}
+	if _, err := s.Generate(context.Background(), "Hello", nil); !errors.Is(err, ErrServerStopped) {
+		t.Errorf("expected ErrServerStopped, got %v", err)
+	}
+}
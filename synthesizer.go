@@ -0,0 +1,240 @@
+package pockettts
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Synthesizer is the common interface implemented by the CLI-based Client and
+// the HTTP-based ServerClient. Code written against Synthesizer can switch
+// between the two backends (e.g. in tests or at deploy time) without touching
+// its call sites.
+type Synthesizer interface {
+	// Synthesize converts text to speech. opts may be nil (uses the backend's
+	// configured defaults).
+	Synthesize(ctx context.Context, text string, opts *GenerateOptions) (*WAVResult, error)
+
+	// Health returns nil if the backend is able to serve requests.
+	Health(ctx context.Context) error
+
+	// Close releases any resources held by the backend.
+	Close() error
+}
+
+var (
+	_ Synthesizer = (*Client)(nil)
+	_ Synthesizer = (*ServerClient)(nil)
+	_ Synthesizer = (*ServerPool)(nil)
+	_ Synthesizer = (*CachedSynthesizer)(nil)
+	_ Synthesizer = (*WorkerClient)(nil)
+	_ Synthesizer = (*CircuitBreaker)(nil)
+	_ Synthesizer = (*Failover)(nil)
+)
+
+// GenerateOptions holds per-request parameters. Zero values fall back to the
+// backend's configured defaults.
+//
+// At most one of Voice, VoiceURL and VoiceWAVPath should be set. If several
+// are, VoiceURL takes precedence over VoiceWAVPath, which takes precedence
+// over Voice.
+type GenerateOptions struct {
+	// Voice is a built-in voice name (e.g. "alba") or a path to a
+	// .safetensors voice-embedding file.
+	// CLI: --voice. Server: voice_url form field for a name; a local path
+	// (anything with a directory separator or file extension) is uploaded
+	// as the voice_wav form file, like VoiceWAVPath.
+	Voice string
+
+	// VoiceURL is a URL (http://, https://, or hf://) to a voice audio file.
+	// CLI: --voice. Server: voice_url form field.
+	VoiceURL string
+
+	// VoiceWAVPath is a local path to a voice WAV or .safetensors file.
+	// CLI: --voice. Server: uploaded as the voice_wav form file.
+	VoiceWAVPath string
+
+	// The following generation parameters override the matching Options
+	// fields in CLI mode. The server's /tts endpoint has no equivalent form
+	// fields, so ServerClient ignores them.
+
+	// Temperature overrides Options.Temperature (CLI: --temperature).
+	Temperature float64
+
+	// LSDDecodeSteps overrides Options.LSDDecodeSteps (CLI: --lsd-decode-steps).
+	LSDDecodeSteps int
+
+	// NoiseClamp overrides Options.NoiseClamp (CLI: --noise-clamp).
+	NoiseClamp float64
+
+	// EOSThreshold overrides Options.EOSThreshold (CLI: --eos-threshold).
+	EOSThreshold float64
+
+	// FramesAfterEOS overrides Options.FramesAfterEOS (CLI: --frames-after-eos).
+	FramesAfterEOS int
+
+	// MaxTokens overrides Options.MaxTokens (CLI: --max-tokens).
+	MaxTokens int
+
+	// SampleRate, Channels and BitsPerSample convert the generated audio
+	// (24000 Hz mono from pocket-tts) before it is returned or encoded, for
+	// both WAVResult and AudioStream output. Zero keeps the backend's value.
+	// Resampling uses a windowed-sinc polyphase filter; downmixing averages
+	// channels and upmixing from mono duplicates them. BitsPerSample must be
+	// 8, 16, 24 or 32; converted audio is always integer PCM.
+	SampleRate    uint32
+	Channels      uint16
+	BitsPerSample uint16
+
+	// Format selects the encoding of WAVResult.Data. Empty means FormatWAV.
+	// Not applied by GenerateStream, which always yields PCM.
+	Format AudioFormat
+
+	// Encoder overrides the built-in encoder for Format, e.g. to pass
+	// bitrate options to ffmpeg or to use a cgo codec. Format should still be
+	// set so that WAVResult.MIMEType reports the right type.
+	Encoder Encoder
+
+	// Priority orders the request in the backend's concurrency queue (see
+	// Options.Concurrency and ServerOptions.Concurrency). The zero value is
+	// PriorityNormal.
+	Priority Priority
+}
+
+// priority returns the queue priority of opts, which may be nil.
+func (o *GenerateOptions) priority() Priority {
+	if o == nil {
+		return PriorityNormal
+	}
+	return o.Priority
+}
+
+// validate rejects output options that cannot be honoured, so that a request
+// fails before any audio is generated. opts may be nil.
+func (o *GenerateOptions) validate() error {
+	if err := o.validateConversion(); err != nil {
+		return err
+	}
+	_, err := o.encoder()
+	return err
+}
+
+// voice returns the value passed to --voice in CLI mode, or "" if opts does
+// not select a voice.
+func (o *GenerateOptions) voice() string {
+	switch {
+	case o == nil:
+		return ""
+	case o.VoiceURL != "":
+		return o.VoiceURL
+	case o.VoiceWAVPath != "":
+		return o.VoiceWAVPath
+	default:
+		return o.Voice
+	}
+}
+
+// serverOptions maps opts onto the multipart fields sent by buildTTSRequest.
+func (o *GenerateOptions) serverOptions() *ServerGenerateOptions {
+	if o == nil {
+		return &ServerGenerateOptions{}
+	}
+	so := &ServerGenerateOptions{
+		VoiceURL:     o.VoiceURL,
+		VoiceWAVPath: o.VoiceWAVPath,
+		Priority:     o.Priority,
+	}
+	if so.VoiceURL == "" && so.VoiceWAVPath == "" {
+		if isVoicePath(o.Voice) {
+			so.VoiceWAVPath = o.Voice
+		} else {
+			so.VoiceURL = o.Voice
+		}
+	}
+	return so
+}
+
+// isVoicePath reports whether voice names a local file (e.g.
+// "/x/voice.safetensors" or "./me.wav") rather than a built-in voice or a
+// URL.
+func isVoicePath(voice string) bool {
+	if strings.Contains(voice, "://") {
+		return false
+	}
+	return strings.ContainsAny(voice, `/\`) || filepath.Ext(voice) != ""
+}
+
+// Mode selects which backend NewSynthesizer constructs.
+type Mode string
+
+const (
+	// ModeCLI spawns `pocket-tts generate` per request (see Client).
+	ModeCLI Mode = "cli"
+
+	// ModeServer talks to `pocket-tts serve` over HTTP (see ServerClient).
+	ModeServer Mode = "server"
+
+	// ModeWorker keeps pocket-tts worker processes with the model loaded
+	// (see WorkerClient).
+	ModeWorker Mode = "worker"
+
+	// ModeFailover uses the server while it is healthy and the CLI otherwise
+	// (see Failover).
+	ModeFailover Mode = "failover"
+)
+
+// SynthesizerConfig selects and configures a backend for NewSynthesizer.
+type SynthesizerConfig struct {
+	// Mode selects the backend. Empty means ModeCLI.
+	Mode Mode
+
+	// CLI configures the Client used in ModeCLI, and the fallback Client in
+	// ModeFailover.
+	CLI Options
+
+	// Server configures the ServerClient used in ModeServer and
+	// ModeFailover.
+	Server ServerOptions
+
+	// Worker configures the WorkerClient used in ModeWorker.
+	Worker WorkerOptions
+
+	// StartServer launches a managed `pocket-tts serve` process in ModeServer
+	// and ModeFailover before returning. When false, the ServerClient
+	// connects to an already running (externally managed) server.
+	StartServer bool
+}
+
+// NewSynthesizer constructs the backend selected by cfg.Mode. In ModeServer
+// and ModeFailover with StartServer set, ctx bounds the server startup (see
+// ServerClient.Start).
+//
+// Callers should Close the returned Synthesizer when done.
+func NewSynthesizer(ctx context.Context, cfg SynthesizerConfig) (Synthesizer, error) {
+	switch cfg.Mode {
+	case "", ModeCLI:
+		return NewClient(cfg.CLI), nil
+	case ModeServer:
+		sc := NewServerClient(cfg.Server)
+		if cfg.StartServer {
+			if err := sc.Start(ctx); err != nil {
+				return nil, err
+			}
+		}
+		return sc, nil
+	case ModeWorker:
+		return NewWorkerClient(cfg.Worker), nil
+	case ModeFailover:
+		sc := NewServerClient(cfg.Server)
+		if cfg.StartServer {
+			if err := sc.Start(ctx); err != nil {
+				return nil, err
+			}
+		}
+		return NewFailover(FailoverOptions{Server: sc, CLI: cfg.CLI}), nil
+	default:
+		return nil, fmt.Errorf("pockettts: unknown mode %q (want %q, %q, %q or %q)",
+			cfg.Mode, ModeCLI, ModeServer, ModeWorker, ModeFailover)
+	}
+}
@@ -0,0 +1,153 @@
+package pockettts
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// ---------------------------------------------------------------------------
+// GenerateOptions mapping
+// ---------------------------------------------------------------------------
+
+func TestBuildArgs_GenerateOptionsVoiceOverride(t *testing.T) {
+	c := newClient(&Options{Voice: "alba"})
+	args := c.buildArgs(&GenerateOptions{Voice: "marius"})
+	pairMustExist(t, args, "--voice", "marius")
+	for _, a := range args {
+		if a == "alba" {
+			t.Errorf("client voice should be overridden, got args %v", args)
+		}
+	}
+}
+
+func TestBuildArgs_GenerateOptionsVoicePrecedence(t *testing.T) {
+	c := newClient(&Options{})
+	args := c.buildArgs(&GenerateOptions{
+		Voice:        "alba",
+		VoiceURL:     "hf://kyutai/tts-voices/alba.wav",
+		VoiceWAVPath: "/tmp/me.wav",
+	})
+	pairMustExist(t, args, "--voice", "hf://kyutai/tts-voices/alba.wav")
+}
+
+func TestGenerateOptions_ServerOptions(t *testing.T) {
+	cases := []struct {
+		name    string
+		in      *GenerateOptions
+		wantURL string
+		wantWAV string
+	}{
+		{"nil", nil, "", ""},
+		{"voice name", &GenerateOptions{Voice: "alba"}, "alba", ""},
+		{"voice hf url", &GenerateOptions{Voice: "hf://kyutai/tts-voices/alba.wav"}, "hf://kyutai/tts-voices/alba.wav", ""},
+		{"voice absolute path", &GenerateOptions{Voice: "/x/voice.safetensors"}, "", "/x/voice.safetensors"},
+		{"voice relative path", &GenerateOptions{Voice: "./me.wav"}, "", "./me.wav"},
+		{"voice file name", &GenerateOptions{Voice: "me.wav"}, "", "me.wav"},
+		{"voice url", &GenerateOptions{Voice: "alba", VoiceURL: "hf://x"}, "hf://x", ""},
+		{"wav path", &GenerateOptions{Voice: "alba", VoiceWAVPath: "me.wav"}, "", "me.wav"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			so := tc.in.serverOptions()
+			if so.VoiceURL != tc.wantURL {
+				t.Errorf("VoiceURL: got %q, want %q", so.VoiceURL, tc.wantURL)
+			}
+			if so.VoiceWAVPath != tc.wantWAV {
+				t.Errorf("VoiceWAVPath: got %q, want %q", so.VoiceWAVPath, tc.wantWAV)
+			}
+		})
+	}
+}
+
+func TestServerClient_Synthesize_SendsVoice(t *testing.T) {
+	wav := append(makeWAVHeader(24000, 1, 16), make([]byte, 100)...)
+	var gotVoice string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotVoice = r.FormValue("voice_url")
+		_, _ = w.Write(wav)
+	}))
+	defer ts.Close()
+
+	var s Synthesizer = serverClientFor(ts)
+	if _, err := s.Synthesize(context.Background(), "Hello", &GenerateOptions{Voice: "alba"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gotVoice != "alba" {
+		t.Errorf("voice_url: got %q, want %q", gotVoice, "alba")
+	}
+}
+
+func TestServerClient_Synthesize_UploadsVoicePath(t *testing.T) {
+	voice := writeTempFile(t, "me.wav", []byte("RIFF-voice"))
+	wav := append(makeWAVHeader(24000, 1, 16), make([]byte, 100)...)
+	var gotURL, gotUpload string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotURL = r.FormValue("voice_url")
+		if f, _, err := r.FormFile("voice_wav"); err == nil {
+			b, _ := io.ReadAll(f)
+			gotUpload = string(b)
+		}
+		_, _ = w.Write(wav)
+	}))
+	defer ts.Close()
+
+	var s Synthesizer = serverClientFor(ts)
+	if _, err := s.Synthesize(context.Background(), "Hello", &GenerateOptions{Voice: voice}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gotURL != "" || gotUpload != "RIFF-voice" {
+		t.Errorf("voice_url %q, voice_wav %q; want the file uploaded", gotURL, gotUpload)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// NewSynthesizer
+// ---------------------------------------------------------------------------
+
+func TestNewSynthesizer_Modes(t *testing.T) {
+	ctx := context.Background()
+
+	s, err := NewSynthesizer(ctx, SynthesizerConfig{})
+	if err != nil {
+		t.Fatalf("default mode: %v", err)
+	}
+	if _, ok := s.(*Client); !ok {
+		t.Errorf("default mode: got %T, want *Client", s)
+	}
+
+	s, err = NewSynthesizer(ctx, SynthesizerConfig{Mode: ModeServer})
+	if err != nil {
+		t.Fatalf("server mode: %v", err)
+	}
+	if _, ok := s.(*ServerClient); !ok {
+		t.Errorf("server mode: got %T, want *ServerClient", s)
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("Close on unstarted server: %v", err)
+	}
+
+	s, err = NewSynthesizer(ctx, SynthesizerConfig{Mode: ModeWorker})
+	if err != nil {
+		t.Fatalf("worker mode: %v", err)
+	}
+	if _, ok := s.(*WorkerClient); !ok {
+		t.Errorf("worker mode: got %T, want *WorkerClient", s)
+	}
+	_ = s.Close()
+
+	s, err = NewSynthesizer(ctx, SynthesizerConfig{Mode: ModeFailover})
+	if err != nil {
+		t.Fatalf("failover mode: %v", err)
+	}
+	if _, ok := s.(*Failover); !ok {
+		t.Errorf("failover mode: got %T, want *Failover", s)
+	}
+	_ = s.Close()
+
+	if _, err := NewSynthesizer(ctx, SynthesizerConfig{Mode: "grpc"}); err == nil {
+		t.Error("expected error for unknown mode")
+	}
+}
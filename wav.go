@@ -0,0 +1,202 @@
+package pockettts
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/cwbudde/wav"
+)
+
+// WAV format tags (fmt chunk, after resolving WAVE_FORMAT_EXTENSIBLE).
+const (
+	wavFormatPCM        = 1
+	wavFormatFloat      = 3
+	wavFormatExtensible = 0xFFFE
+)
+
+// wavData is a decoded WAV file: its format and the contents of its data
+// chunk.
+type wavData struct {
+	sampleRate    uint32
+	channels      uint16
+	bitsPerSample uint16
+	formatTag     uint16
+	pcm           []byte
+}
+
+func (w *wavData) blockAlign() int {
+	return int(w.channels) * int(w.bitsPerSample) / 8
+}
+
+// decodeWAVData locates the data chunk of a WAV file with the wav decoder and
+// validates it against the fmt chunk.
+//
+// A declared data size of zero (as written by streaming encoders that cannot
+// know the length up front, or 0xFFFFFFFF after RIFF padding) means "until
+// the end of the file". Any other size larger than the bytes present, or a
+// data chunk that ends in a partial frame, is reported as ErrMalformedWAV.
+func decodeWAVData(data []byte) (*wavData, error) {
+	r := bytes.NewReader(data)
+	dec := wav.NewDecoder(r)
+	if err := dec.FwdToPCM(); err != nil {
+		return nil, fmt.Errorf("%w: locate data chunk: %v", ErrMalformedWAV, err)
+	}
+	off, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, fmt.Errorf("%w: locate data chunk: %v", ErrMalformedWAV, err)
+	}
+
+	w := &wavData{
+		sampleRate:    dec.SampleRate,
+		channels:      dec.NumChans,
+		bitsPerSample: dec.BitDepth,
+		formatTag:     dec.FmtChunk.EffectiveFormatTag(),
+		pcm:           data[off:],
+	}
+	if w.sampleRate == 0 || w.channels == 0 || w.bitsPerSample == 0 {
+		return nil, fmt.Errorf("%w: incomplete fmt chunk", ErrMalformedWAV)
+	}
+
+	if size := dec.PCMLen(); size > 0 {
+		// The decoder rounds odd sizes up to the RIFF pad byte, which a
+		// writer may legitimately omit at the end of the file.
+		if size > int64(len(w.pcm)) && !(size%2 == 0 && size-1 == int64(len(w.pcm))) {
+			return nil, fmt.Errorf("%w: data chunk declares %d bytes but only %d are present (truncated audio)",
+				ErrMalformedWAV, size, len(w.pcm))
+		}
+		if size < int64(len(w.pcm)) {
+			w.pcm = w.pcm[:size]
+		}
+	}
+
+	if ba := w.blockAlign(); ba == 0 || len(w.pcm)%ba != 0 {
+		if ba != 0 && w.bitsPerSample == 8 && len(w.pcm)%ba == 1 {
+			w.pcm = w.pcm[:len(w.pcm)-1] // trailing RIFF pad byte
+		} else {
+			return nil, fmt.Errorf("%w: data chunk of %d bytes does not hold whole %d-byte frames",
+				ErrMalformedWAV, len(w.pcm), ba)
+		}
+	}
+	return w, nil
+}
+
+// float32Samples decodes the data chunk into interleaved samples in [-1, 1].
+func (w *wavData) float32Samples() ([]float32, error) {
+	bytesPer := int(w.bitsPerSample) / 8
+	n := len(w.pcm) / bytesPer
+	out := make([]float32, n)
+	p := w.pcm
+
+	switch {
+	case w.formatTag == wavFormatPCM && w.bitsPerSample == 8:
+		for i := range out {
+			out[i] = float32(int(p[i])-128) / 128
+		}
+	case w.formatTag == wavFormatPCM && w.bitsPerSample == 16:
+		for i := range out {
+			out[i] = float32(int16(binary.LittleEndian.Uint16(p[2*i:]))) / 32768
+		}
+	case w.formatTag == wavFormatPCM && w.bitsPerSample == 24:
+		for i := range out {
+			v := int32(p[3*i]) | int32(p[3*i+1])<<8 | int32(int8(p[3*i+2]))<<16
+			out[i] = float32(v) / (1 << 23)
+		}
+	case w.formatTag == wavFormatPCM && w.bitsPerSample == 32:
+		for i := range out {
+			out[i] = float32(float64(int32(binary.LittleEndian.Uint32(p[4*i:]))) / (1 << 31))
+		}
+	case w.formatTag == wavFormatFloat && w.bitsPerSample == 32:
+		for i := range out {
+			out[i] = math.Float32frombits(binary.LittleEndian.Uint32(p[4*i:]))
+		}
+	case w.formatTag == wavFormatFloat && w.bitsPerSample == 64:
+		for i := range out {
+			out[i] = float32(math.Float64frombits(binary.LittleEndian.Uint64(p[8*i:])))
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported sample format (format tag %d, %d-bit)",
+			ErrMalformedWAV, w.formatTag, w.bitsPerSample)
+	}
+	return out, nil
+}
+
+// int16Samples decodes the data chunk into interleaved 16-bit samples.
+func (w *wavData) int16Samples() ([]int16, error) {
+	if w.formatTag == wavFormatPCM && w.bitsPerSample == 16 {
+		out := make([]int16, len(w.pcm)/2)
+		for i := range out {
+			out[i] = int16(binary.LittleEndian.Uint16(w.pcm[2*i:]))
+		}
+		return out, nil
+	}
+
+	f, err := w.float32Samples()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]int16, len(f))
+	for i, v := range f {
+		out[i] = floatToInt16(v)
+	}
+	return out, nil
+}
+
+// floatToInt16 converts a sample in [-1, 1] to 16-bit PCM, clipping values
+// outside that range.
+func floatToInt16(v float32) int16 {
+	switch {
+	case v >= 1:
+		return math.MaxInt16
+	case v <= -1:
+		return math.MinInt16
+	default:
+		return int16(math.Round(float64(v) * 32768))
+	}
+}
+
+// encodeWAV wraps little-endian PCM samples in a canonical 44-byte PCM WAV
+// header.
+func encodeWAV(sampleRate uint32, channels, bitsPerSample uint16, pcm []byte) []byte {
+	blockAlign := channels * bitsPerSample / 8
+	out := make([]byte, 44, 44+len(pcm))
+	copy(out[0:], "RIFF")
+	binary.LittleEndian.PutUint32(out[4:], uint32(36+len(pcm)))
+	copy(out[8:], "WAVE")
+	copy(out[12:], "fmt ")
+	binary.LittleEndian.PutUint32(out[16:], 16) // fmt chunk size
+	binary.LittleEndian.PutUint16(out[20:], wavFormatPCM)
+	binary.LittleEndian.PutUint16(out[22:], channels)
+	binary.LittleEndian.PutUint32(out[24:], sampleRate)
+	binary.LittleEndian.PutUint32(out[28:], sampleRate*uint32(blockAlign))
+	binary.LittleEndian.PutUint16(out[32:], blockAlign)
+	binary.LittleEndian.PutUint16(out[34:], bitsPerSample)
+	copy(out[36:], "data")
+	binary.LittleEndian.PutUint32(out[40:], uint32(len(pcm)))
+	return append(out, pcm...)
+}
+
+// pcmDuration returns the playback time of n bytes of PCM in the given format.
+func pcmDuration(n int, sampleRate uint32, channels, bitsPerSample uint16) time.Duration {
+	bytesPerSec := int64(sampleRate) * int64(channels) * int64(bitsPerSample) / 8
+	if bytesPerSec == 0 {
+		return 0
+	}
+	return time.Duration(int64(n) * int64(time.Second) / bytesPerSec)
+}
+
+// silence returns d worth of silent PCM in the given format. 8-bit WAV is
+// unsigned, so its silence level is 0x80 rather than zero.
+func silence(d time.Duration, sampleRate uint32, channels, bitsPerSample uint16) []byte {
+	frames := int64(d) * int64(sampleRate) / int64(time.Second)
+	buf := make([]byte, frames*int64(channels)*int64(bitsPerSample/8))
+	if bitsPerSample == 8 {
+		for i := range buf {
+			buf[i] = 0x80
+		}
+	}
+	return buf
+}
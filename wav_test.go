@@ -0,0 +1,142 @@
+package pockettts
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// int16PCM encodes samples as little-endian 16-bit PCM.
+func int16PCM(samples ...int16) []byte {
+	b := make([]byte, 2*len(samples))
+	for i, s := range samples {
+		binary.LittleEndian.PutUint16(b[2*i:], uint16(s))
+	}
+	return b
+}
+
+// ---------------------------------------------------------------------------
+// WAVResult sample accessors
+// ---------------------------------------------------------------------------
+
+func TestWAVResult_Int16Samples(t *testing.T) {
+	want := []int16{0, 1000, -1000, math.MaxInt16, math.MinInt16, 42}
+	r := &WAVResult{Data: encodeWAV(24000, 2, 16, int16PCM(want...))}
+
+	got, err := r.Int16Samples()
+	if err != nil {
+		t.Fatalf("Int16Samples: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d samples, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("sample %d: got %d, want %d", i, got[i], want[i])
+		}
+	}
+
+	frames, err := r.Frames()
+	if err != nil || frames != 3 {
+		t.Errorf("Frames: got %d, %v; want 3", frames, err)
+	}
+}
+
+func TestWAVResult_Float32Samples(t *testing.T) {
+	r := &WAVResult{Data: encodeWAV(24000, 1, 16, int16PCM(0, 16384, -32768))}
+	got, err := r.Float32Samples()
+	if err != nil {
+		t.Fatalf("Float32Samples: %v", err)
+	}
+	want := []float32{0, 0.5, -1}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("sample %d: got %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestWAVResult_FloatFormat(t *testing.T) {
+	pcm := make([]byte, 8)
+	binary.LittleEndian.PutUint32(pcm[0:], math.Float32bits(0.25))
+	binary.LittleEndian.PutUint32(pcm[4:], math.Float32bits(-2)) // out of range: clipped
+	data := encodeWAV(24000, 1, 32, pcm)
+	binary.LittleEndian.PutUint16(data[20:], wavFormatFloat)
+	r := &WAVResult{Data: data}
+
+	f, err := r.Float32Samples()
+	if err != nil {
+		t.Fatalf("Float32Samples: %v", err)
+	}
+	if f[0] != 0.25 || f[1] != -2 {
+		t.Errorf("float samples: got %v", f)
+	}
+	i16, err := r.Int16Samples()
+	if err != nil {
+		t.Fatalf("Int16Samples: %v", err)
+	}
+	if i16[0] != 8192 || i16[1] != math.MinInt16 {
+		t.Errorf("int16 samples: got %v", i16)
+	}
+}
+
+func TestWAVResult_AudioDuration(t *testing.T) {
+	r := &WAVResult{Data: encodeWAV(24000, 1, 16, make([]byte, 2*12000))}
+	d, err := r.AudioDuration()
+	if err != nil {
+		t.Fatalf("AudioDuration: %v", err)
+	}
+	if d != 500*time.Millisecond {
+		t.Errorf("AudioDuration: got %s, want 500ms", d)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Malformed data
+// ---------------------------------------------------------------------------
+
+func TestWAVResult_Truncated(t *testing.T) {
+	data := encodeWAV(24000, 1, 16, int16PCM(1, 2, 3, 4))
+	data = data[:len(data)-4] // header still declares 8 bytes
+	r := &WAVResult{Data: data}
+
+	if _, err := r.Int16Samples(); !errors.Is(err, ErrMalformedWAV) {
+		t.Errorf("expected ErrMalformedWAV for truncated data, got %v", err)
+	}
+	if _, err := r.AudioDuration(); !errors.Is(err, ErrMalformedWAV) {
+		t.Errorf("expected ErrMalformedWAV from AudioDuration, got %v", err)
+	}
+}
+
+func TestWAVResult_PartialFrame(t *testing.T) {
+	data := encodeWAV(24000, 2, 16, []byte{1, 2, 3, 4, 5, 6})
+	r := &WAVResult{Data: data}
+	if _, err := r.Frames(); !errors.Is(err, ErrMalformedWAV) {
+		t.Errorf("expected ErrMalformedWAV for partial frame, got %v", err)
+	}
+}
+
+func TestWAVResult_NotWAV(t *testing.T) {
+	r := &WAVResult{Data: []byte("definitely not a wav file")}
+	if _, err := r.PCM(); !errors.Is(err, ErrMalformedWAV) {
+		t.Errorf("expected ErrMalformedWAV, got %v", err)
+	}
+}
+
+func TestWAVResult_StreamingSizes(t *testing.T) {
+	pcm := int16PCM(1, 2, 3)
+	for _, size := range []uint32{0, 0xFFFFFFFF} {
+		data := encodeWAV(24000, 1, 16, pcm)
+		binary.LittleEndian.PutUint32(data[40:], size)
+		r := &WAVResult{Data: data}
+		got, err := r.Int16Samples()
+		if err != nil {
+			t.Fatalf("size %#x: %v", size, err)
+		}
+		if len(got) != 3 {
+			t.Errorf("size %#x: got %d samples, want 3", size, len(got))
+		}
+	}
+}
@@ -0,0 +1,536 @@
+package pockettts
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// workerScript is the Python side of the worker protocol. It drives the
+// pocket_tts Python API, since the CLI has no request/response mode.
+//
+//go:embed worker.py
+var workerScript string
+
+// maxWorkerFrame bounds the frames accepted from a worker (about 45 minutes
+// of 24 kHz 16-bit audio), so that a corrupt length cannot force a huge
+// allocation.
+const maxWorkerFrame = 128 << 20
+
+// workerExitGrace is how long a failed worker may take to exit on its own
+// before it is killed.
+const workerExitGrace = 500 * time.Millisecond
+
+// WorkerOptions configures a WorkerClient.
+type WorkerOptions struct {
+	// Options holds the generation defaults, ExecutablePath, LogWriter,
+	// Concurrency and Retry, as for Client. Concurrency is the number of worker
+	// processes; zero or negative means one. Quiet is ignored.
+	Options
+
+	// Python is the interpreter that runs the worker. It must be able to
+	// import pocket_tts. Defaults to the interpreter named in the shebang of
+	// the pocket-tts executable, or "python3".
+	Python string
+
+	// MaxRequests recycles a worker after it has served this many requests.
+	// Zero means never.
+	MaxRequests int
+
+	// MaxMemory recycles a worker once its resident memory exceeds this many
+	// bytes, checked after each request. Zero means never. Only supported
+	// where /proc is available (Linux).
+	MaxMemory int64
+}
+
+// WorkerClient synthesizes speech with long-lived pocket-tts worker processes
+// that keep the model loaded, like ServerClient, but talk over stdin and
+// stdout instead of a network socket.
+//
+// Workers are started on demand, up to Options.Concurrency, and reused until
+// they have served MaxRequests requests or grown beyond MaxMemory. A worker
+// that crashes or is interrupted by a cancelled context is replaced by the
+// next request. Failures are classified from the worker's stderr like CLI
+// failures (see classifyExit).
+//
+// Create with NewWorkerClient and Close when done.
+type WorkerClient struct {
+	opts WorkerOptions
+	cli  *Client // option merging and the concurrency limit
+
+	mu     sync.Mutex
+	idle   []*worker
+	all    map[*worker]struct{}
+	closed bool
+}
+
+// NewWorkerClient returns a WorkerClient. No worker is started until the
+// first request.
+func NewWorkerClient(opts WorkerOptions) *WorkerClient {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+	return &WorkerClient{
+		opts: opts,
+		cli:  newClient(&opts.Options),
+		all:  make(map[*worker]struct{}),
+	}
+}
+
+// workerRequest is the request frame sent to a worker.
+type workerRequest struct {
+	Text           string  `json:"text"`
+	Voice          string  `json:"voice,omitempty"`
+	Config         string  `json:"config,omitempty"`
+	Temperature    float64 `json:"temperature,omitempty"`
+	LSDDecodeSteps int     `json:"lsd_decode_steps,omitempty"`
+	NoiseClamp     float64 `json:"noise_clamp,omitempty"`
+	EOSThreshold   float64 `json:"eos_threshold,omitempty"`
+	FramesAfterEOS int     `json:"frames_after_eos,omitempty"`
+	MaxTokens      int     `json:"max_tokens,omitempty"`
+}
+
+// workerReply is a status frame sent by a worker. A successful reply is
+// followed by a frame holding the WAV data.
+type workerReply struct {
+	Ready bool   `json:"ready"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error"`
+}
+
+// Synthesize implements Synthesizer. opts may be nil; its generation
+// parameters override the WorkerOptions defaults as in Client.
+func (c *WorkerClient) Synthesize(ctx context.Context, text string, opts *GenerateOptions) (*WAVResult, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, ErrEmptyText
+	}
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	res, err := c.opts.Retry.do(ctx, func(ctx context.Context) (*WAVResult, error) {
+		return c.synthesizeOnce(ctx, text, opts)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return finishResult(ctx, res, opts)
+}
+
+// synthesizeOnce sends a single request to a worker for Synthesize.
+func (c *WorkerClient) synthesizeOnce(ctx context.Context, text string, opts *GenerateOptions) (result *WAVResult, err error) {
+	obs := observe(c.opts.Observer, ModeWorker, opts.priority())
+	defer func() { obs.done(result, err) }()
+
+	release, queued, err := c.cli.acquire(ctx, opts.priority())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	obs.admitted(queued)
+
+	o := c.cli.effectiveOptions(opts)
+	req := workerRequest{
+		Text:           text,
+		Voice:          o.Voice,
+		Config:         o.Config,
+		Temperature:    o.Temperature,
+		LSDDecodeSteps: o.LSDDecodeSteps,
+		NoiseClamp:     o.NoiseClamp,
+		EOSThreshold:   o.EOSThreshold,
+		FramesAfterEOS: o.FramesAfterEOS,
+		MaxTokens:      o.MaxTokens,
+	}
+
+	start := time.Now()
+	w, spawned, err := c.get(ctx, o.Voice)
+	if err != nil {
+		return nil, err
+	}
+	var spawn time.Duration
+	if spawned {
+		spawn = time.Since(start)
+	}
+	wavBytes, firstByte, err := w.synthesize(ctx, &req)
+	elapsed := time.Since(start)
+	c.put(w)
+	if err != nil {
+		return nil, err
+	}
+
+	sr, ch, bps, err := parseWAVHeader(wavBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := GenerationStats{
+		Duration:  elapsed,
+		QueueWait: queued,
+		SpawnTime: spawn,
+		TTFB:      firstByte.Sub(start),
+		Backend:   ModeWorker,
+	}
+	stats.measure(wavBytes)
+	stats.log(c.opts.LogWriter)
+
+	return &WAVResult{
+		Data:          wavBytes,
+		SampleRate:    sr,
+		Channels:      ch,
+		BitsPerSample: bps,
+		Stats:         stats,
+	}, nil
+}
+
+// Health implements Synthesizer. It returns nil if the worker interpreter is
+// resolvable; it does not start a worker.
+func (c *WorkerClient) Health(ctx context.Context) error {
+	python := c.python()
+	if _, err := exec.LookPath(python); err != nil {
+		return &ErrExecutableNotFound{Executable: python}
+	}
+	return nil
+}
+
+// Close stops all workers. Requests in flight fail; later requests fail with
+// ErrServerStopped.
+func (c *WorkerClient) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	workers := make([]*worker, 0, len(c.all))
+	for w := range c.all {
+		workers = append(workers, w)
+	}
+	c.idle, c.all = nil, make(map[*worker]struct{})
+	c.mu.Unlock()
+
+	for _, w := range workers {
+		w.stop()
+	}
+	return nil
+}
+
+// QueueDepth returns the number of requests waiting for a worker by
+// priority. Priorities without waiting requests are omitted.
+func (c *WorkerClient) QueueDepth() map[Priority]int {
+	return c.cli.QueueDepth()
+}
+
+// Workers returns the number of running worker processes.
+func (c *WorkerClient) Workers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.all)
+}
+
+// get returns an idle worker, starting one if there is none; started
+// reports whether it did. The caller holds a concurrency slot, so at most
+// Concurrency workers exist.
+func (c *WorkerClient) get(ctx context.Context, voice string) (w *worker, started bool, err error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, false, ErrServerStopped
+	}
+	if n := len(c.idle); n > 0 {
+		w := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return w, false, nil
+	}
+	c.mu.Unlock()
+
+	w, err = c.startWorker(ctx, voice)
+	if err != nil {
+		return nil, false, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		w.stop()
+		return nil, false, ErrServerStopped
+	}
+	c.all[w] = struct{}{}
+	return w, true, nil
+}
+
+// put returns w to the idle list, or retires it if it has failed or is due
+// for recycling.
+func (c *WorkerClient) put(w *worker) {
+	retire := w.broken
+	if !retire && c.opts.MaxRequests > 0 && w.requests >= c.opts.MaxRequests {
+		retire = true
+	}
+	if !retire && c.opts.MaxMemory > 0 {
+		if rss, ok := processRSS(w.cmd.Process.Pid); ok && rss > c.opts.MaxMemory {
+			retire = true
+		}
+	}
+
+	c.mu.Lock()
+	_, live := c.all[w]
+	if retire || !live {
+		delete(c.all, w)
+		c.mu.Unlock()
+		go w.stop()
+		return
+	}
+	c.idle = append(c.idle, w)
+	c.mu.Unlock()
+}
+
+// python returns the interpreter that runs the worker script.
+func (c *WorkerClient) python() string {
+	if c.opts.Python != "" {
+		return c.opts.Python
+	}
+	exe := c.opts.ExecutablePath
+	if exe == "" {
+		exe = "pocket-tts"
+	}
+	if path, err := exec.LookPath(exe); err == nil {
+		if python := shebangPython(path); python != "" {
+			return python
+		}
+	}
+	return "python3"
+}
+
+// shebangPython returns the Python interpreter named in the shebang line of
+// the script at path, or "" if there is none.
+func shebangPython(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	line, err := bufio.NewReader(io.LimitReader(f, 512)).ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "#!") {
+		return ""
+	}
+	fields := strings.Fields(line[2:])
+	if len(fields) == 0 {
+		return ""
+	}
+	if strings.HasSuffix(fields[0], "/env") && len(fields) > 1 {
+		fields = fields[1:]
+	}
+	if !strings.Contains(fields[0], "python") {
+		return ""
+	}
+	return fields[0]
+}
+
+// worker is a running worker process. It serves one request at a time.
+type worker struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	stderr *tailBuffer
+	voice  string // for classifying startup failures
+
+	done chan struct{} // closed when the process has exited
+
+	requests int  // requests served; owned by the current user
+	broken   bool // the protocol stream is unusable; retire the worker
+}
+
+// startWorker launches a worker and waits for it to report that the model
+// is loaded, or for ctx to end.
+func (c *WorkerClient) startWorker(ctx context.Context, voice string) (*worker, error) {
+	python := c.python()
+	cmd := exec.Command(python, "-u", "-c", workerScript)
+	setProcessGroup(cmd)
+	cmd.WaitDelay = time.Second
+	w := &worker{cmd: cmd, stderr: &tailBuffer{max: 4096}, voice: voice, done: make(chan struct{})}
+	stdin, stdout, err := startProcess(cmd, w.stderr, c.opts.LogWriter)
+	if err != nil {
+		return nil, err
+	}
+	w.stdin, w.stdout = stdin, bufio.NewReader(stdout)
+	go func() {
+		_ = waitProcess(cmd)
+		close(w.done)
+	}()
+
+	var reply workerReply
+	if err := w.call(ctx, func() error { return w.readJSON(&reply) }); err != nil {
+		w.stop()
+		return nil, err
+	}
+	if !reply.Ready {
+		w.stop()
+		return nil, fmt.Errorf("pockettts: worker sent unexpected greeting %+v", reply)
+	}
+	return w, nil
+}
+
+// synthesize sends req and returns the WAV data of the reply, together with
+// the time the worker began sending it.
+func (w *worker) synthesize(ctx context.Context, req *workerRequest) (wavBytes []byte, firstByte time.Time, err error) {
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("pockettts: encode worker request: %w", err)
+	}
+
+	err = w.call(ctx, func() error {
+		if err := writeFrame(w.stdin, payload); err != nil {
+			return err
+		}
+		var reply workerReply
+		if err := w.readJSON(&reply); err != nil {
+			return err
+		}
+		if !reply.OK {
+			exit := &ErrNonZeroExit{ExitCode: 1, Stderr: truncate(reply.Error, 512)}
+			return &workerFailure{err: classifyExit(exit, req.Voice)}
+		}
+		firstByte = time.Now()
+		wavBytes, err = readFrame(w.stdout)
+		return err
+	})
+	w.requests++
+	var failure *workerFailure
+	if errors.As(err, &failure) {
+		return nil, time.Time{}, failure.err // the worker reported it and is still usable
+	}
+	if err != nil {
+		w.voice = req.Voice
+		return nil, time.Time{}, err
+	}
+	return wavBytes, firstByte, nil
+}
+
+// workerFailure is a synthesis error reported by a healthy worker.
+type workerFailure struct{ err error }
+
+func (f *workerFailure) Error() string { return f.err.Error() }
+
+// call runs fn, which talks to the worker, and maps its failure onto the
+// package's error types. If ctx ends first, the worker is killed to abort fn
+// and the request fails with *ErrProcessTimeout or *ErrCanceled.
+// Any failure other than a workerFailure marks the worker as broken.
+func (w *worker) call(ctx context.Context, fn func() error) error {
+	start := time.Now()
+	stop := context.AfterFunc(ctx, w.kill)
+	err := fn()
+	if !stop() && err == nil {
+		// ctx ended just as fn succeeded and the worker is being killed:
+		// keep the result, but retire the worker.
+		w.broken = true
+		<-w.done
+	}
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*workerFailure); ok {
+		return err
+	}
+
+	w.broken = true
+	// A worker that broke the pipe is usually exiting on its own: give it a
+	// moment, so that its exit status rather than our SIGKILL is reported.
+	killed := false
+	select {
+	case <-w.done:
+	case <-time.After(workerExitGrace):
+		w.kill()
+		<-w.done
+		killed = true
+	}
+	stderr := truncate(w.stderr.String(), 512)
+	if ctx.Err() != nil {
+		return contextError(ctx, time.Since(start), stderr)
+	}
+	if !killed && !w.cmd.ProcessState.Success() {
+		return exitError(w.cmd.ProcessState, w.stderr, w.voice)
+	}
+	return fmt.Errorf("pockettts: worker protocol: %w", err)
+}
+
+// readJSON reads a status frame into v.
+func (w *worker) readJSON(v *workerReply) error {
+	frame, err := readFrame(w.stdout)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(frame, v); err != nil {
+		return fmt.Errorf("decode worker reply: %w", err)
+	}
+	return nil
+}
+
+// kill terminates the worker's process group without waiting.
+func (w *worker) kill() {
+	_ = killProcessGroup(w.cmd.Process)
+}
+
+// stop asks the worker to exit by closing its stdin and kills it if it has
+// not exited within a few seconds.
+func (w *worker) stop() {
+	_ = w.stdin.Close()
+	select {
+	case <-w.done:
+	case <-time.After(5 * time.Second):
+		w.kill()
+		<-w.done
+	}
+}
+
+func writeFrame(wr io.Writer, payload []byte) error {
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(len(payload)))
+	if _, err := wr.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := wr.Write(payload)
+	return err
+}
+
+func readFrame(r io.Reader) ([]byte, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(hdr[:])
+	if n > maxWorkerFrame {
+		return nil, fmt.Errorf("worker frame of %d bytes exceeds limit", n)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// processRSS returns the resident memory of process pid in bytes, if /proc
+// is available.
+func processRSS(pid int) (int64, bool) {
+	status, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/status")
+	if err != nil {
+		return 0, false
+	}
+	for _, line := range bytes.Split(status, []byte("\n")) {
+		if v, ok := bytes.CutPrefix(line, []byte("VmRSS:")); ok {
+			fields := strings.Fields(string(v))
+			if len(fields) != 2 || fields[1] != "kB" {
+				return 0, false
+			}
+			kb, err := strconv.ParseInt(fields[0], 10, 64)
+			return kb << 10, err == nil
+		}
+	}
+	return 0, false
+}
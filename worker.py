@@ -0,0 +1,101 @@
+# pocket-tts worker for WorkerClient (see worker.go).
+#
+# Keeps the model loaded and answers synthesis requests framed on stdin and
+# stdout: every frame is a 4-byte big-endian length followed by the payload.
+#
+#   worker -> client  {"ready": true} once the default model is loaded
+#   client -> worker  {"text": ..., "voice": ..., "config": ..., ...}
+#   worker -> client  {"ok": true} followed by a WAV frame, or
+#                     {"error": "<traceback>"}
+#
+# Anything else the libraries print goes to stderr.
+
+import io
+import json
+import struct
+import sys
+import traceback
+import wave
+
+proto_in = sys.stdin.buffer
+proto_out = sys.stdout.buffer
+sys.stdout = sys.stderr
+
+DEFAULT_VOICE = "alba"
+MODEL_KEYS = ("config", "temperature", "lsd_decode_steps", "noise_clamp", "eos_threshold")
+GENERATE_KEYS = ("frames_after_eos", "max_tokens")
+
+
+def read_frame():
+    header = proto_in.read(4)
+    if len(header) < 4:
+        return None
+    (n,) = struct.unpack(">I", header)
+    return proto_in.read(n)
+
+
+def write_frame(payload):
+    proto_out.write(struct.pack(">I", len(payload)))
+    proto_out.write(payload)
+    proto_out.flush()
+
+
+def write_json(obj):
+    write_frame(json.dumps(obj).encode())
+
+
+from pocket_tts import TTSModel  # noqa: E402  (import errors go to stderr)
+
+models = {}
+voices = {}
+
+
+def model_for(req):
+    key = tuple(req.get(k) for k in MODEL_KEYS)
+    if key not in models:
+        kwargs = {}
+        if req.get("config"):
+            kwargs["variant"] = req["config"]
+        for k in MODEL_KEYS[1:]:
+            if req.get(k):
+                kwargs["temp" if k == "temperature" else k] = req[k]
+        models[key] = TTSModel.load_model(**kwargs)
+    return key, models[key]
+
+
+def voice_state(key, model, voice):
+    if (key, voice) not in voices:
+        voices[(key, voice)] = model.get_state_for_audio_prompt(voice)
+    return voices[(key, voice)]
+
+
+def synthesize(req):
+    key, model = model_for(req)
+    state = voice_state(key, model, req.get("voice") or DEFAULT_VOICE)
+    kwargs = {k: req[k] for k in GENERATE_KEYS if req.get(k)}
+    audio = model.generate_audio(state, req["text"], **kwargs)
+    pcm = (audio.clamp(-1, 1) * 32767).round().short().numpy().tobytes()
+
+    buf = io.BytesIO()
+    with wave.open(buf, "wb") as w:
+        w.setnchannels(1)
+        w.setsampwidth(2)
+        w.setframerate(model.sample_rate)
+        w.writeframes(pcm)
+    return buf.getvalue()
+
+
+model_for({})
+write_json({"ready": True})
+
+while True:
+    frame = read_frame()
+    if frame is None:
+        break
+    try:
+        wav = synthesize(json.loads(frame))
+    except Exception:
+        write_json({"error": traceback.format_exc()})
+        continue
+    write_json({"ok": True})
+    write_frame(wav)
@@ -0,0 +1,372 @@
+package pockettts
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestWorkerHelperProcess is not a real test: it is the fake worker that
+// fakeWorker's interpreter script runs. It speaks the worker.py protocol and
+// reacts to the request text:
+//
+//	"fail ..."  reports an unknown-voice error and keeps serving
+//	"crash"     exits with status 3
+//	"hang"      never answers
+//	"oom"       kills itself with SIGKILL, like the kernel's OOM killer
+//	"garbage"   answers with a frame that is not JSON, then hangs
+//
+// GO_WORKER_HELPER=nodep makes it fail at startup like a missing module.
+func TestWorkerHelperProcess(t *testing.T) {
+	mode := os.Getenv("GO_WORKER_HELPER")
+	if mode == "" {
+		return
+	}
+	if mode == "nodep" {
+		fmt.Fprintln(os.Stderr, "ModuleNotFoundError: No module named 'pocket_tts'")
+		os.Exit(1)
+	}
+
+	in := bufio.NewReader(os.Stdin)
+	send := func(v any) {
+		b, _ := json.Marshal(v)
+		_ = writeFrame(os.Stdout, b)
+	}
+	send(map[string]bool{"ready": true})
+	for {
+		frame, err := readFrame(in)
+		if err != nil {
+			os.Exit(0)
+		}
+		var req workerRequest
+		_ = json.Unmarshal(frame, &req)
+		switch {
+		case strings.HasPrefix(req.Text, "fail"):
+			send(map[string]string{"error": "Traceback...\nValueError: unknown voice " + req.Voice})
+		case req.Text == "crash":
+			fmt.Fprintln(os.Stderr, "Segmentation fault")
+			os.Exit(3)
+		case req.Text == "hang":
+			time.Sleep(time.Minute)
+		case req.Text == "oom":
+			self, _ := os.FindProcess(os.Getpid())
+			_ = self.Kill()
+			time.Sleep(time.Minute)
+		case req.Text == "garbage":
+			_ = writeFrame(os.Stdout, []byte("not json"))
+			time.Sleep(time.Minute)
+		default:
+			send(map[string]bool{"ok": true})
+			wav := append(makeWAVHeader(24000, 1, 16), req.Voice...)
+			_ = writeFrame(os.Stdout, wav)
+		}
+	}
+}
+
+// fakeWorker returns an interpreter that runs TestWorkerHelperProcess in
+// the given mode, and a file that gains a line each time a worker starts.
+func fakeWorker(t *testing.T, mode string) (python, starts string) {
+	t.Helper()
+	starts = filepath.Join(t.TempDir(), "starts")
+	python = fakeExecutable(t, fmt.Sprintf(
+		"echo start >> %q\nGO_WORKER_HELPER=%s exec %q -test.run='^TestWorkerHelperProcess$'",
+		starts, mode, os.Args[0]))
+	return python, starts
+}
+
+func countStarts(t *testing.T, starts string) int {
+	t.Helper()
+	b, err := os.ReadFile(starts)
+	if err != nil {
+		return 0
+	}
+	return strings.Count(string(b), "start")
+}
+
+func TestWorkerClient_ReusesWorker(t *testing.T) {
+	python, starts := fakeWorker(t, "ok")
+	c := NewWorkerClient(WorkerOptions{Python: python, Options: Options{Voice: "alba"}})
+	defer c.Close()
+
+	for i := range 3 {
+		res, err := c.Synthesize(context.Background(), "hello", &GenerateOptions{Voice: fmt.Sprintf("v%d", i)})
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		if res.SampleRate != 24000 || string(res.Data[44:]) != fmt.Sprintf("v%d", i) {
+			t.Errorf("request %d: got rate %d, payload %q", i, res.SampleRate, res.Data[44:])
+		}
+	}
+	if n := countStarts(t, starts); n != 1 {
+		t.Errorf("started %d workers, want 1", n)
+	}
+}
+
+func TestWorkerClient_SpawnTime(t *testing.T) {
+	python, _ := fakeWorker(t, "ok")
+	c := NewWorkerClient(WorkerOptions{Python: python})
+	defer c.Close()
+
+	for i, fresh := range []bool{true, false} {
+		res, err := c.Synthesize(context.Background(), "hello", &GenerateOptions{Voice: "alba"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		st := res.Stats
+		if (st.SpawnTime > 0) != fresh {
+			t.Errorf("request %d: SpawnTime = %s, want > 0 only for a new worker", i, st.SpawnTime)
+		}
+		if st.TTFB < st.SpawnTime || st.TTFB > st.Duration || st.Bytes != 44+len("alba") {
+			t.Errorf("request %d: TTFB %s, Duration %s, Bytes %d", i, st.TTFB, st.Duration, st.Bytes)
+		}
+	}
+}
+
+func TestWorkerClient_DefaultsApplied(t *testing.T) {
+	python, _ := fakeWorker(t, "ok")
+	c := NewWorkerClient(WorkerOptions{Python: python, Options: Options{Voice: "marius"}})
+	defer c.Close()
+
+	res, err := c.Synthesize(context.Background(), "hello", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(res.Data[44:]); got != "marius" {
+		t.Errorf("voice = %q, want marius", got)
+	}
+}
+
+func TestWorkerClient_Concurrency(t *testing.T) {
+	python, starts := fakeWorker(t, "ok")
+	c := NewWorkerClient(WorkerOptions{Python: python, Options: Options{Concurrency: 2}})
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Synthesize(context.Background(), "hello", nil); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := countStarts(t, starts); n < 1 || n > 2 {
+		t.Errorf("started %d workers, want 1 or 2", n)
+	}
+	if n := c.Workers(); n > 2 {
+		t.Errorf("Workers() = %d, want at most 2", n)
+	}
+}
+
+func TestWorkerClient_MaxRequestsRecycles(t *testing.T) {
+	python, starts := fakeWorker(t, "ok")
+	c := NewWorkerClient(WorkerOptions{Python: python, MaxRequests: 2})
+	defer c.Close()
+
+	for range 5 {
+		if _, err := c.Synthesize(context.Background(), "hello", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := countStarts(t, starts); n != 3 {
+		t.Errorf("started %d workers, want 3", n)
+	}
+}
+
+func TestWorkerClient_MaxMemoryRecycles(t *testing.T) {
+	if _, ok := processRSS(os.Getpid()); !ok {
+		t.Skip("/proc not available")
+	}
+	python, starts := fakeWorker(t, "ok")
+	c := NewWorkerClient(WorkerOptions{Python: python, MaxMemory: 1}) // any process exceeds 1 byte
+	defer c.Close()
+
+	for range 2 {
+		if _, err := c.Synthesize(context.Background(), "hello", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := countStarts(t, starts); n != 2 {
+		t.Errorf("started %d workers, want 2", n)
+	}
+}
+
+func TestWorkerClient_ReportedErrorKeepsWorker(t *testing.T) {
+	python, starts := fakeWorker(t, "ok")
+	c := NewWorkerClient(WorkerOptions{Python: python})
+	defer c.Close()
+
+	_, err := c.Synthesize(context.Background(), "fail", &GenerateOptions{Voice: "nobody"})
+	var iv *ErrInvalidVoice
+	if !errors.As(err, &iv) || iv.Voice != "nobody" {
+		t.Fatalf("err = %v, want *ErrInvalidVoice for nobody", err)
+	}
+	if _, err := c.Synthesize(context.Background(), "hello", nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := countStarts(t, starts); n != 1 {
+		t.Errorf("started %d workers, want 1", n)
+	}
+}
+
+func TestWorkerClient_CrashReplacesWorker(t *testing.T) {
+	python, starts := fakeWorker(t, "ok")
+	c := NewWorkerClient(WorkerOptions{Python: python})
+	defer c.Close()
+
+	_, err := c.Synthesize(context.Background(), "crash", nil)
+	var exit *ErrNonZeroExit
+	if !errors.As(err, &exit) || exit.ExitCode != 3 || !strings.Contains(exit.Stderr, "Segmentation fault") {
+		t.Fatalf("err = %v, want *ErrNonZeroExit with code 3 and stderr", err)
+	}
+	if _, err := c.Synthesize(context.Background(), "hello", nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := countStarts(t, starts); n != 2 {
+		t.Errorf("started %d workers, want 2", n)
+	}
+}
+
+func TestWorkerClient_KilledIsOutOfMemory(t *testing.T) {
+	python, _ := fakeWorker(t, "ok")
+	c := NewWorkerClient(WorkerOptions{Python: python})
+	defer c.Close()
+
+	_, err := c.Synthesize(context.Background(), "oom", nil)
+	var oom *ErrOutOfMemory
+	if !errors.As(err, &oom) || oom.Exit.Signal != os.Kill {
+		t.Fatalf("err = %v, want *ErrOutOfMemory with SIGKILL", err)
+	}
+}
+
+func TestWorkerClient_ProtocolErrorIsNotOutOfMemory(t *testing.T) {
+	python, _ := fakeWorker(t, "ok")
+	c := NewWorkerClient(WorkerOptions{Python: python})
+	defer c.Close()
+
+	// The worker is killed by us, not by the kernel.
+	_, err := c.Synthesize(context.Background(), "garbage", nil)
+	var oom *ErrOutOfMemory
+	if err == nil || errors.As(err, &oom) || !strings.Contains(err.Error(), "worker protocol") {
+		t.Fatalf("err = %v, want a worker protocol error", err)
+	}
+}
+
+func TestWorkerClient_StartupFailure(t *testing.T) {
+	python, _ := fakeWorker(t, "nodep")
+	c := NewWorkerClient(WorkerOptions{Python: python})
+	defer c.Close()
+
+	_, err := c.Synthesize(context.Background(), "hello", nil)
+	var md *ErrMissingDependency
+	if !errors.As(err, &md) || md.Module != "pocket_tts" {
+		t.Fatalf("err = %v, want *ErrMissingDependency for pocket_tts", err)
+	}
+	if n := c.Workers(); n != 0 {
+		t.Errorf("Workers() = %d, want 0", n)
+	}
+}
+
+func TestWorkerClient_ContextCancelKillsWorker(t *testing.T) {
+	python, starts := fakeWorker(t, "ok")
+	c := NewWorkerClient(WorkerOptions{Python: python})
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := c.Synthesize(ctx, "hang", nil)
+	var pt *ErrProcessTimeout
+	if !errors.As(err, &pt) {
+		t.Fatalf("err = %v, want *ErrProcessTimeout", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("cancellation took %s", d)
+	}
+	if _, err := c.Synthesize(context.Background(), "hello", nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := countStarts(t, starts); n != 2 {
+		t.Errorf("started %d workers, want 2", n)
+	}
+}
+
+func TestWorkerClient_Close(t *testing.T) {
+	python, _ := fakeWorker(t, "ok")
+	c := NewWorkerClient(WorkerOptions{Python: python})
+	if _, err := c.Synthesize(context.Background(), "hello", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := c.Workers(); n != 0 {
+		t.Errorf("Workers() = %d after Close, want 0", n)
+	}
+	if _, err := c.Synthesize(context.Background(), "hello", nil); !errors.Is(err, ErrServerStopped) {
+		t.Errorf("err = %v, want ErrServerStopped", err)
+	}
+}
+
+// TestWorkerClient_Golden runs worker.py against the real pocket_tts
+// package, exercising every parameter it passes to the Python API. It runs
+// only when the worker interpreter can import pocket_tts.
+func TestWorkerClient_Golden(t *testing.T) {
+	c := NewWorkerClient(WorkerOptions{})
+	defer c.Close()
+	if err := exec.Command(c.python(), "-c", "import pocket_tts").Run(); err != nil {
+		t.Skipf("%s cannot import pocket_tts; skipping golden test", c.python())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	for _, opts := range []*GenerateOptions{
+		nil,
+		{
+			Voice:          "marius",
+			Temperature:    0.5,
+			LSDDecodeSteps: 2,
+			NoiseClamp:     2,
+			EOSThreshold:   -3,
+			FramesAfterEOS: 2,
+			MaxTokens:      200,
+		},
+	} {
+		res, err := c.Synthesize(ctx, "Hello.", opts)
+		if err != nil {
+			t.Fatalf("Synthesize(%+v): %v", opts, err)
+		}
+		if res.SampleRate != 24000 || res.Stats.AudioDuration <= 0 {
+			t.Errorf("Synthesize(%+v): %d Hz, %s of audio", opts, res.SampleRate, res.Stats.AudioDuration)
+		}
+	}
+}
+
+func TestShebangPython(t *testing.T) {
+	dir := t.TempDir()
+	for _, tc := range []struct{ shebang, want string }{
+		{"#!/opt/venv/bin/python3\n", "/opt/venv/bin/python3"},
+		{"#!/usr/bin/env python3\n", "python3"},
+		{"#!/bin/sh\n", ""},
+		{"ELF", ""},
+	} {
+		path := filepath.Join(dir, "exe")
+		if err := os.WriteFile(path, []byte(tc.shebang), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if got := shebangPython(path); got != tc.want {
+			t.Errorf("shebangPython(%q) = %q, want %q", tc.shebang, got, tc.want)
+		}
+	}
+}